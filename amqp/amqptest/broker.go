@@ -0,0 +1,647 @@
+package amqptest
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+type message struct {
+	exchange   string
+	routingKey string
+
+	props Properties
+	body  []byte
+
+	redelivered bool
+	published   time.Time
+}
+
+// binding routes the messages of an exchange to a queue or, for exchange to
+// exchange bindings, to another exchange.
+type binding struct {
+	queue    *queue
+	exchange *exchange
+	key      string
+	args     amqp.Table
+}
+
+type exchange struct {
+	name string
+	kind string
+
+	durable    bool
+	autoDelete bool
+	internal   bool
+	args       amqp.Table
+
+	bindings []*binding
+}
+
+type consumer struct {
+	tag   string
+	ch    *channel
+	queue *queue
+
+	noAck     bool
+	exclusive bool
+	args      amqp.Table
+
+	prefetch int
+	unacked  int
+
+	// offset is the position of the next message of a stream
+	offset int
+}
+
+type queue struct {
+	name string
+
+	durable    bool
+	exclusive  bool
+	autoDelete bool
+	args       amqp.Table
+	owner      *conn
+
+	messages  []*message
+	consumers []*consumer
+	next      int
+	consumed  bool
+}
+
+const (
+	queueClassic = "classic"
+	queueQuorum  = "quorum"
+	queueStream  = "stream"
+)
+
+// kind returns the x-queue-type of the queue, classic by default.
+func (q *queue) kind() string {
+	if kind, ok := q.args["x-queue-type"].(string); ok {
+		return kind
+	}
+	return queueClassic
+}
+
+// invalidQueue returns why a queue cannot be declared, quorum queues and
+// streams are durable and shared.
+func invalidQueue(name string, durable, exclusive, autoDelete bool, args amqp.Table) string {
+	kind, ok := args["x-queue-type"]
+	if !ok {
+		return ""
+	}
+	switch kind {
+	case queueClassic:
+		return ""
+	case queueQuorum, queueStream:
+	default:
+		return fmt.Sprintf("PRECONDITION_FAILED - invalid arg 'x-queue-type' for queue '%s' in vhost '/'", name)
+	}
+	property := ""
+	switch {
+	case !durable:
+		property = "non-durable"
+	case exclusive:
+		property = "exclusive"
+	case autoDelete:
+		property = "auto-delete"
+	default:
+		return ""
+	}
+	return fmt.Sprintf("PRECONDITION_FAILED - invalid property '%s' for queue '%s' in vhost '/'", property, name)
+}
+
+// streamOffset resolves the x-stream-offset of a consumer to a position in
+// the stream: first, last, next, an offset or a timestamp. Consumers start
+// at the next message by default.
+func (q *queue) streamOffset(arg interface{}) (int, bool) {
+	if arg == nil {
+		return len(q.messages), true
+	}
+	if offset, ok := toInt64(arg); ok {
+		if offset < 0 || offset > int64(len(q.messages)) {
+			offset = int64(len(q.messages))
+		}
+		return int(offset), true
+	}
+	switch v := arg.(type) {
+	case string:
+		switch v {
+		case "first":
+			return 0, true
+		case "last":
+			if len(q.messages) == 0 {
+				return 0, true
+			}
+			return len(q.messages) - 1, true
+		case "next":
+			return len(q.messages), true
+		}
+	case time.Time:
+		for i, msg := range q.messages {
+			if !msg.published.Before(v) {
+				return i, true
+			}
+		}
+		return len(q.messages), true
+	}
+	return 0, false
+}
+
+// delivery is a message handed to a channel that still awaits an ack.
+type delivery struct {
+	tag      uint64
+	msg      *message
+	queue    *queue
+	consumer *consumer
+}
+
+func randomName(prefix string) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-"
+	b := make([]byte, 22)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return prefix + string(b)
+}
+
+// delayedMessage is the exchange type of the rabbitmq-delayed-message-exchange
+// plugin, available when the server is created with SetServerDelayedMessages.
+const delayedMessage = "x-delayed-message"
+
+func (s *Server) validExchangeKind(kind string) bool {
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic, amqp.ExchangeHeaders:
+		return true
+	case delayedMessage:
+		return s.delayedMessages
+	}
+	return false
+}
+
+// routingKind returns how the exchange routes, delayed message exchanges
+// route as their x-delayed-type once the delay elapsed.
+func (ex *exchange) routingKind() string {
+	if ex.kind == delayedMessage {
+		if kind, ok := ex.args["x-delayed-type"].(string); ok {
+			return kind
+		}
+		return amqp.ExchangeDirect
+	}
+	return ex.kind
+}
+
+// route resolves the queues a message published to the exchange ends up in,
+// following the exchange to exchange bindings.
+func (s *Server) route(ex *exchange, key string, headers amqp.Table) []*queue {
+	seen := make(map[*queue]bool)
+	queues := make([]*queue, 0)
+	s.routeTo(ex, key, headers, make(map[*exchange]bool), func(q *queue) {
+		if !seen[q] {
+			seen[q] = true
+			queues = append(queues, q)
+		}
+	})
+	return queues
+}
+
+func (s *Server) routeTo(ex *exchange, key string, headers amqp.Table, visited map[*exchange]bool, fn func(*queue)) {
+	if visited[ex] {
+		return
+	}
+	visited[ex] = true
+
+	if ex.name == "" {
+		if q, ok := s.queues[key]; ok {
+			fn(q)
+		}
+		return
+	}
+
+	for _, b := range ex.bindings {
+		if !matches(ex.routingKind(), b, key, headers) {
+			continue
+		}
+		if b.exchange != nil {
+			s.routeTo(b.exchange, key, headers, visited, fn)
+			continue
+		}
+		fn(b.queue)
+	}
+}
+
+func matches(kind string, b *binding, key string, headers amqp.Table) bool {
+	switch kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return matchTopic(strings.Split(b.key, "."), strings.Split(key, "."))
+	case amqp.ExchangeHeaders:
+		return matchHeaders(b.args, headers)
+	default:
+		return b.key == key
+	}
+}
+
+func matchTopic(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchTopic(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchTopic(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchTopic(pattern[1:], words[1:])
+	}
+}
+
+func matchHeaders(args, headers amqp.Table) bool {
+	matchAny := args["x-match"] == "any"
+	matched := 0
+	total := 0
+	for k, v := range args {
+		if strings.HasPrefix(k, "x-") {
+			continue
+		}
+		total++
+		hv, ok := headers[k]
+		if ok && (v == nil || equalValues(v, hv)) {
+			matched++
+			if matchAny {
+				return true
+			}
+		}
+	}
+	if matchAny {
+		return false
+	}
+	return matched == total
+}
+
+func equalValues(a, b interface{}) bool {
+	if na, ok := toInt64(a); ok {
+		nb, ok := toInt64(b)
+		return ok && na == nb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+func equalArgs(a, b amqp.Table) bool {
+	for k, v := range a {
+		if !equalValues(v, b[k]) {
+			return false
+		}
+	}
+	for k, v := range b {
+		if _, ok := a[k]; !ok && v != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// enqueue adds a message to the queue and hands it to ready consumers. In a
+// queue with x-max-priority the message is placed behind the messages of the
+// same or higher priority, and it expires after the x-message-ttl of the queue
+// or its own expiration, whichever is shorter.
+func (s *Server) enqueue(q *queue, msg *message) {
+	if q.kind() == queueStream {
+		msg.published = time.Now()
+		q.messages = append(q.messages, msg)
+		s.dispatch(q)
+		return
+	}
+
+	if q.full() {
+		q.messages = q.messages[1:]
+	}
+
+	i := len(q.messages)
+	if max, ok := toInt64(q.args["x-max-priority"]); ok {
+		priority := q.priority(msg, max)
+		for i > 0 && q.priority(q.messages[i-1], max) < priority {
+			i--
+		}
+	}
+	q.messages = append(q.messages, nil)
+	copy(q.messages[i+1:], q.messages[i:])
+	q.messages[i] = msg
+
+	ttl, ok := toInt64(q.args["x-message-ttl"])
+	if exp, err := strconv.ParseInt(msg.props.Expiration, 10, 64); err == nil && (!ok || exp < ttl) {
+		ttl, ok = exp, true
+	}
+	if ok {
+		time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() {
+			s.expire(q, msg)
+		})
+	}
+	s.dispatch(q)
+}
+
+func (q *queue) priority(msg *message, max int64) int64 {
+	if p := int64(msg.props.Priority); p < max {
+		return p
+	}
+	return max
+}
+
+// full reports whether the queue holds its x-max-length of ready messages.
+func (q *queue) full() bool {
+	max, ok := toInt64(q.args["x-max-length"])
+	return ok && int64(len(q.messages)) >= max
+}
+
+// expire removes a message whose x-message-ttl elapsed while it was still
+// ready in the queue and dead-letters it. Messages delivered before they
+// expired are never expired, even once requeued.
+func (s *Server) expire(q *queue, msg *message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.queues[q.name] != q {
+		return
+	}
+	for i, m := range q.messages {
+		if m != msg {
+			continue
+		}
+		q.messages = append(q.messages[:i:i], q.messages[i+1:]...)
+		s.deadLetter(q, msg, "expired")
+		return
+	}
+}
+
+// deadLetter republishes a message to the x-dead-letter-exchange of its
+// queue, with an x-death header recording why.
+func (s *Server) deadLetter(q *queue, msg *message, reason string) {
+	name, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	ex, ok := s.exchanges[name]
+	if !ok {
+		return
+	}
+	key := msg.routingKey
+	if k, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = k
+	}
+
+	props := msg.props
+	deaths, _ := props.Headers["x-death"].([]interface{})
+	props.Headers = withHeader(props.Headers, "x-death", append([]interface{}{amqp.Table{
+		"queue":        q.name,
+		"reason":       reason,
+		"exchange":     msg.exchange,
+		"routing-keys": []interface{}{msg.routingKey},
+		"count":        int64(1),
+	}}, deaths...))
+
+	for _, dq := range s.route(ex, key, props.Headers) {
+		s.enqueue(dq, &message{
+			exchange:   name,
+			routingKey: key,
+			props:      props,
+			body:       msg.body,
+		})
+	}
+}
+
+// withHeader returns a copy of the headers with the header set, the headers of
+// a message are shared by its copies.
+func withHeader(headers amqp.Table, key string, value interface{}) amqp.Table {
+	copied := amqp.Table{}
+	for k, v := range headers {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
+
+// delay routes a message published to a delayed message exchange once its
+// x-delay header elapsed.
+func (s *Server) delay(ex *exchange, msg *message, d time.Duration) {
+	time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.closed || s.exchanges[ex.name] != ex {
+			return
+		}
+		for _, q := range s.route(ex, msg.routingKey, msg.props.Headers) {
+			m := *msg
+			s.enqueue(q, &m)
+		}
+	})
+}
+
+// rejects reports whether the queue refuses a publishing, as a full queue
+// with the reject-publish overflow does instead of dropping its head.
+func (q *queue) rejects() bool {
+	return q.args["x-overflow"] == "reject-publish" && q.full()
+}
+
+// requeue puts deliveries back at the head of their queues in delivery order.
+// Quorum queues count the deliveries in the x-delivery-count header and
+// dead-letter the messages returned more than their x-delivery-limit, streams
+// keep their messages anyway.
+func (s *Server) requeue(deliveries []*delivery) {
+	touched := make([]*queue, 0)
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		d := deliveries[i]
+		if d.consumer != nil {
+			d.consumer.unacked--
+		}
+		if _, ok := s.queues[d.queue.name]; !ok || s.queues[d.queue.name] != d.queue {
+			continue
+		}
+		if d.queue.kind() == queueStream {
+			continue
+		}
+		msg := *d.msg
+		msg.redelivered = true
+		if d.queue.kind() == queueQuorum {
+			count, _ := toInt64(msg.props.Headers["x-delivery-count"])
+			count++
+			if limit, ok := toInt64(d.queue.args["x-delivery-limit"]); ok && count > limit {
+				s.deadLetter(d.queue, d.msg, "delivery_limit")
+				continue
+			}
+			msg.props.Headers = withHeader(msg.props.Headers, "x-delivery-count", count)
+		}
+		d.queue.messages = append([]*message{&msg}, d.queue.messages...)
+		touched = append(touched, d.queue)
+	}
+	for _, q := range touched {
+		s.dispatch(q)
+	}
+}
+
+// dispatch delivers ready messages round robin to consumers with spare
+// prefetch capacity.
+func (s *Server) dispatch(q *queue) {
+	if q.kind() == queueStream {
+		s.dispatchStream(q)
+		return
+	}
+
+	for len(q.messages) > 0 {
+		c := q.ready()
+		if c == nil {
+			return
+		}
+
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		c.ch.deliver(c, msg)
+	}
+}
+
+// dispatchStream delivers every consumer of a stream the messages past its
+// offset, with their offset in the x-stream-offset header.
+func (s *Server) dispatchStream(q *queue) {
+	for _, c := range q.consumers {
+		for c.offset < len(q.messages) && c.ready() {
+			msg := *q.messages[c.offset]
+			msg.props.Headers = withHeader(msg.props.Headers, "x-stream-offset", int64(c.offset))
+			c.offset++
+			c.ch.deliver(c, &msg)
+		}
+	}
+}
+
+// ready returns the next consumer round robin among the ready consumers of the
+// highest x-priority.
+func (q *queue) ready() *consumer {
+	var ready *consumer
+	next := 0
+	for i := 0; i < len(q.consumers); i++ {
+		j := (q.next + i) % len(q.consumers)
+		c := q.consumers[j]
+		if !c.ready() {
+			continue
+		}
+		if ready == nil || c.priority() > ready.priority() {
+			ready, next = c, j
+		}
+	}
+	if ready != nil {
+		q.next = (next + 1) % len(q.consumers)
+	}
+	return ready
+}
+
+func (c *consumer) priority() int64 {
+	p, _ := toInt64(c.args["x-priority"])
+	return p
+}
+
+// ready reports whether the consumer has spare prefetch capacity.
+func (c *consumer) ready() bool {
+	if c.ch.closing || !c.ch.flow {
+		return false
+	}
+	if !c.noAck && c.prefetch > 0 && c.unacked >= c.prefetch {
+		return false
+	}
+	if !c.noAck && c.ch.prefetchGlobal > 0 && c.ch.unackedCount() >= c.ch.prefetchGlobal {
+		return false
+	}
+	return true
+}
+
+func (q *queue) removeConsumer(c *consumer) {
+	for i, other := range q.consumers {
+		if other == c {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.next >= len(q.consumers) {
+		q.next = 0
+	}
+}
+
+// deleteQueue removes the queue with its bindings and cancels its consumers.
+func (s *Server) deleteQueue(q *queue) int {
+	delete(s.queues, q.name)
+
+	for _, c := range q.consumers {
+		delete(c.ch.consumers, c.tag)
+		c.ch.conn.send(methodFrame(c.ch.id, classBasic, 30, (&encoder{}).shortstr(c.tag).flag(true)))
+	}
+	q.consumers = nil
+
+	for _, ex := range s.exchanges {
+		s.unbind(ex, func(b *binding) bool { return b.queue == q })
+	}
+
+	return len(q.messages)
+}
+
+func (s *Server) unbind(ex *exchange, match func(*binding) bool) {
+	bindings := make([]*binding, 0, len(ex.bindings))
+	for _, b := range ex.bindings {
+		if !match(b) {
+			bindings = append(bindings, b)
+		}
+	}
+	removed := len(bindings) != len(ex.bindings)
+	ex.bindings = bindings
+
+	if removed && ex.autoDelete && len(ex.bindings) == 0 {
+		delete(s.exchanges, ex.name)
+	}
+}
+
+func (s *Server) cancelConsumer(c *consumer) {
+	delete(c.ch.consumers, c.tag)
+	c.queue.removeConsumer(c)
+	if c.queue.autoDelete && c.queue.consumed && len(c.queue.consumers) == 0 {
+		s.deleteQueue(c.queue)
+	}
+}
+
+func notFoundQueue(name string) string {
+	return fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '/'", name)
+}
+
+func notFoundExchange(name string) string {
+	return fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", name)
+}
@@ -0,0 +1,823 @@
+package amqptest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// publish is a basic.publish waiting for its content header and body.
+type publish struct {
+	exchange   string
+	routingKey string
+	mandatory  bool
+
+	header bool
+	size   uint64
+	props  Properties
+	body   []byte
+}
+
+type channel struct {
+	id   uint16
+	conn *conn
+	s    *Server
+
+	closing bool
+	flow    bool
+	confirm bool
+	seq     uint64
+
+	prefetch       int
+	prefetchGlobal int
+
+	tag       uint64
+	unacked   map[uint64]*delivery
+	consumers map[string]*consumer
+
+	pending *publish
+}
+
+func newChannel(c *conn, id uint16) *channel {
+	return &channel{
+		id:        id,
+		conn:      c,
+		s:         c.s,
+		flow:      true,
+		unacked:   make(map[uint64]*delivery),
+		consumers: make(map[string]*consumer),
+	}
+}
+
+func (ch *channel) unackedCount() int {
+	return len(ch.unacked)
+}
+
+// fail closes the channel with a channel exception. Requires s.mu.
+func (ch *channel) fail(code uint16, text string, class, method uint16) {
+	if ch.closing {
+		return
+	}
+	ch.closing = true
+	ch.cleanup()
+	ch.conn.send(methodFrame(ch.id, classChannel, 40, (&encoder{}).
+		short(code).
+		shortstr(text).
+		short(class).
+		short(method)))
+}
+
+// cleanup cancels the consumers of the channel and requeues its unacked
+// deliveries. Requires s.mu.
+func (ch *channel) cleanup() {
+	ch.pending = nil
+	for _, c := range ch.consumers {
+		ch.s.cancelConsumer(c)
+	}
+	ch.s.requeue(ch.settle(0, true))
+}
+
+// settle removes and returns the unacked deliveries matching the tag.
+func (ch *channel) settle(tag uint64, multiple bool) []*delivery {
+	settled := make([]*delivery, 0)
+	if !multiple {
+		if d, ok := ch.unacked[tag]; ok {
+			delete(ch.unacked, tag)
+			settled = append(settled, d)
+		}
+		return settled
+	}
+
+	for t, d := range ch.unacked {
+		if tag == 0 || t <= tag {
+			delete(ch.unacked, t)
+			settled = append(settled, d)
+		}
+	}
+	sort.Slice(settled, func(i, j int) bool { return settled[i].tag < settled[j].tag })
+	return settled
+}
+
+func (ch *channel) deliver(c *consumer, msg *message) {
+	ch.tag++
+	if !c.noAck {
+		ch.unacked[ch.tag] = &delivery{tag: ch.tag, msg: msg, queue: c.queue, consumer: c}
+		c.unacked++
+	}
+
+	frames := []frame{methodFrame(ch.id, classBasic, 60, (&encoder{}).
+		shortstr(c.tag).
+		longlong(ch.tag).
+		flag(msg.redelivered).
+		shortstr(msg.exchange).
+		shortstr(msg.routingKey))}
+	ch.conn.send(append(frames, contentFrames(ch.id, msg.props, msg.body)...)...)
+}
+
+func (ch *channel) handle(f frame) {
+	if ch.closing {
+		if f.typ != frameMethod {
+			return
+		}
+		d := newDecoder(f.payload)
+		switch class, method := d.short(), d.short(); {
+		case class == classChannel && method == 40:
+			ch.conn.send(methodFrame(ch.id, classChannel, 41, nil))
+			delete(ch.conn.channels, ch.id)
+		case class == classChannel && method == 41:
+			delete(ch.conn.channels, ch.id)
+		}
+		return
+	}
+
+	switch f.typ {
+	case frameHeader:
+		ch.handleHeader(f)
+	case frameBody:
+		ch.handleBody(f)
+	case frameMethod:
+		ch.handleMethod(f)
+	}
+}
+
+func (ch *channel) handleHeader(f frame) {
+	p := ch.pending
+	if p == nil || p.header {
+		ch.conn.fail(unexpectedFrame, "UNEXPECTED_FRAME - expected method frame", 0, 0)
+		return
+	}
+
+	d := newDecoder(f.payload)
+	d.short()
+	d.short()
+	p.size = d.longlong()
+	p.props = decodeProperties(d)
+	if d.err != nil {
+		ch.conn.fail(syntaxError, "SYNTAX_ERROR - malformed content header", classBasic, 40)
+		return
+	}
+	p.header = true
+
+	if p.size == 0 {
+		ch.publish()
+	}
+}
+
+func (ch *channel) handleBody(f frame) {
+	p := ch.pending
+	if p == nil || !p.header {
+		ch.conn.fail(unexpectedFrame, "UNEXPECTED_FRAME - expected content header", 0, 0)
+		return
+	}
+
+	p.body = append(p.body, f.payload...)
+	if uint64(len(p.body)) >= p.size {
+		ch.publish()
+	}
+}
+
+func (ch *channel) handleMethod(f frame) {
+	d := newDecoder(f.payload)
+	class, method := d.short(), d.short()
+
+	if ch.pending != nil {
+		ch.conn.fail(unexpectedFrame, "UNEXPECTED_FRAME - expected content header", class, method)
+		return
+	}
+
+	switch class {
+	case classChannel:
+		ch.handleChannel(d, method)
+	case classExchange:
+		ch.handleExchange(d, method)
+	case classQueue:
+		ch.handleQueue(d, method)
+	case classBasic:
+		ch.handleBasic(d, method)
+	case classConfirm:
+		ch.confirm = true
+		if nowait := d.flag(); !nowait {
+			ch.conn.send(methodFrame(ch.id, classConfirm, 11, nil))
+		}
+	default:
+		ch.conn.fail(notImplemented, fmt.Sprintf("NOT_IMPLEMENTED - class %d", class), class, method)
+	}
+
+	if d.err != nil {
+		ch.conn.fail(syntaxError, "SYNTAX_ERROR - malformed method frame", class, method)
+	}
+}
+
+func (ch *channel) handleChannel(d *decoder, method uint16) {
+	switch method {
+	case 10: // open
+		ch.conn.fail(channelError, "CHANNEL_ERROR - second 'channel.open' seen", classChannel, method)
+
+	case 20: // flow
+		ch.flow = d.flag()
+		ch.conn.send(methodFrame(ch.id, classChannel, 21, (&encoder{}).flag(ch.flow)))
+		if ch.flow {
+			for _, c := range ch.consumers {
+				ch.s.dispatch(c.queue)
+			}
+		}
+
+	case 40: // close
+		ch.cleanup()
+		ch.conn.send(methodFrame(ch.id, classChannel, 41, nil))
+		delete(ch.conn.channels, ch.id)
+	}
+}
+
+func (ch *channel) handleExchange(d *decoder, method uint16) {
+	switch method {
+	case 10: // declare
+		d.short()
+		name := d.shortstr()
+		kind := d.shortstr()
+		passive, durable, autoDelete, internal, nowait := d.flag(), d.flag(), d.flag(), d.flag(), d.flag()
+		args := d.table()
+		if d.err != nil {
+			return
+		}
+
+		ex, ok := ch.s.exchanges[name]
+		switch {
+		case passive && !ok:
+			ch.fail(notFound, notFoundExchange(name), classExchange, method)
+			return
+		case ok && !passive && ex.kind != kind:
+			ch.fail(preconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s' in vhost '/': received '%s' but current is '%s'", name, kind, ex.kind), classExchange, method)
+			return
+		case !ok && !passive:
+			if name == "" || len(name) > 3 && name[:4] == "amq." {
+				ch.fail(accessRefused, fmt.Sprintf("ACCESS_REFUSED - exchange name '%s' contains reserved prefix 'amq.*'", name), classExchange, method)
+				return
+			}
+			if !ch.s.validExchangeKind(kind) {
+				ch.conn.fail(commandInvalid, fmt.Sprintf("COMMAND_INVALID - unknown exchange type '%s'", kind), classExchange, method)
+				return
+			}
+			ch.s.exchanges[name] = &exchange{
+				name:       name,
+				kind:       kind,
+				durable:    durable,
+				autoDelete: autoDelete,
+				internal:   internal,
+				args:       args,
+			}
+		}
+
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classExchange, 11, nil))
+		}
+
+	case 20: // delete
+		d.short()
+		name := d.shortstr()
+		ifUnused, nowait := d.flag(), d.flag()
+		if d.err != nil {
+			return
+		}
+
+		if name == "" || len(name) > 3 && name[:4] == "amq." {
+			ch.fail(accessRefused, fmt.Sprintf("ACCESS_REFUSED - operation not permitted on exchange '%s'", name), classExchange, method)
+			return
+		}
+		if ex, ok := ch.s.exchanges[name]; ok {
+			if ifUnused && len(ex.bindings) > 0 {
+				ch.fail(preconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - exchange '%s' in vhost '/' in use", name), classExchange, method)
+				return
+			}
+			delete(ch.s.exchanges, name)
+			for _, other := range ch.s.exchanges {
+				ch.s.unbind(other, func(b *binding) bool { return b.exchange == ex })
+			}
+		}
+
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classExchange, 21, nil))
+		}
+
+	case 30: // bind
+		d.short()
+		dstname, srcname, key := d.shortstr(), d.shortstr(), d.shortstr()
+		nowait := d.flag()
+		args := d.table()
+		if d.err != nil {
+			return
+		}
+
+		dst, src, ok := ch.lookupExchanges(dstname, srcname, method)
+		if !ok {
+			return
+		}
+
+		bound := false
+		for _, b := range src.bindings {
+			if b.exchange == dst && b.key == key && equalArgs(b.args, args) {
+				bound = true
+			}
+		}
+		if !bound {
+			src.bindings = append(src.bindings, &binding{exchange: dst, key: key, args: args})
+		}
+
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classExchange, 31, nil))
+		}
+
+	case 40: // unbind
+		d.short()
+		dstname, srcname, key := d.shortstr(), d.shortstr(), d.shortstr()
+		nowait := d.flag()
+		args := d.table()
+		if d.err != nil {
+			return
+		}
+
+		dst, src, ok := ch.lookupExchanges(dstname, srcname, method)
+		if !ok {
+			return
+		}
+		ch.s.unbind(src, func(b *binding) bool {
+			return b.exchange == dst && b.key == key && equalArgs(b.args, args)
+		})
+
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classExchange, 51, nil))
+		}
+
+	default:
+		ch.conn.fail(notImplemented, fmt.Sprintf("NOT_IMPLEMENTED - exchange method %d", method), classExchange, method)
+	}
+}
+
+// lookupExchanges finds the exchanges of an exchange to exchange binding,
+// failing the channel otherwise. Requires s.mu.
+func (ch *channel) lookupExchanges(dstname, srcname string, method uint16) (*exchange, *exchange, bool) {
+	if dstname == "" || srcname == "" {
+		ch.fail(accessRefused, "ACCESS_REFUSED - operation not permitted on the default exchange", classExchange, method)
+		return nil, nil, false
+	}
+	dst, ok := ch.s.exchanges[dstname]
+	if !ok {
+		ch.fail(notFound, notFoundExchange(dstname), classExchange, method)
+		return nil, nil, false
+	}
+	src, ok := ch.s.exchanges[srcname]
+	if !ok {
+		ch.fail(notFound, notFoundExchange(srcname), classExchange, method)
+		return nil, nil, false
+	}
+	return dst, src, true
+}
+
+// lookupQueue finds a queue the connection is allowed to use, failing the
+// channel otherwise. Requires s.mu.
+func (ch *channel) lookupQueue(name string, class, method uint16) (*queue, bool) {
+	q, ok := ch.s.queues[name]
+	if !ok {
+		ch.fail(notFound, notFoundQueue(name), class, method)
+		return nil, false
+	}
+	if q.exclusive && q.owner != ch.conn {
+		ch.fail(resourceLocked, fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s' in vhost '/'", name), class, method)
+		return nil, false
+	}
+	return q, true
+}
+
+func (ch *channel) handleQueue(d *decoder, method uint16) {
+	switch method {
+	case 10: // declare
+		d.short()
+		name := d.shortstr()
+		passive, durable, exclusive, autoDelete, nowait := d.flag(), d.flag(), d.flag(), d.flag(), d.flag()
+		args := d.table()
+		if d.err != nil {
+			return
+		}
+
+		var q *queue
+		if existing, ok := ch.s.queues[name]; ok {
+			if q, ok = ch.lookupQueue(name, classQueue, method); !ok {
+				return
+			}
+			if !passive && (existing.durable != durable || existing.autoDelete != autoDelete || existing.exclusive != exclusive || !equalArgs(existing.args, args)) {
+				ch.fail(preconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - inequivalent arguments for queue '%s' in vhost '/'", name), classQueue, method)
+				return
+			}
+		} else {
+			if passive {
+				ch.fail(notFound, notFoundQueue(name), classQueue, method)
+				return
+			}
+			if name == "" {
+				name = randomName("amq.gen-")
+			}
+			if text := invalidQueue(name, durable, exclusive, autoDelete, args); text != "" {
+				ch.fail(preconditionFailed, text, classQueue, method)
+				return
+			}
+			q = &queue{
+				name:       name,
+				durable:    durable,
+				exclusive:  exclusive,
+				autoDelete: autoDelete,
+				args:       args,
+			}
+			if exclusive {
+				q.owner = ch.conn
+			}
+			ch.s.queues[name] = q
+		}
+
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classQueue, 11, (&encoder{}).
+				shortstr(q.name).
+				long(uint32(len(q.messages))).
+				long(uint32(len(q.consumers)))))
+		}
+
+	case 20: // bind
+		d.short()
+		name, exname, key := d.shortstr(), d.shortstr(), d.shortstr()
+		nowait := d.flag()
+		args := d.table()
+		if d.err != nil {
+			return
+		}
+
+		q, ok := ch.lookupQueue(name, classQueue, method)
+		if !ok {
+			return
+		}
+		if exname == "" {
+			ch.fail(accessRefused, "ACCESS_REFUSED - operation not permitted on the default exchange", classQueue, method)
+			return
+		}
+		ex, ok := ch.s.exchanges[exname]
+		if !ok {
+			ch.fail(notFound, notFoundExchange(exname), classQueue, method)
+			return
+		}
+
+		bound := false
+		for _, b := range ex.bindings {
+			if b.queue == q && b.key == key && equalArgs(b.args, args) {
+				bound = true
+			}
+		}
+		if !bound {
+			ex.bindings = append(ex.bindings, &binding{queue: q, key: key, args: args})
+		}
+
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classQueue, 21, nil))
+		}
+
+	case 50: // unbind
+		d.short()
+		name, exname, key := d.shortstr(), d.shortstr(), d.shortstr()
+		args := d.table()
+		if d.err != nil {
+			return
+		}
+
+		q, ok := ch.lookupQueue(name, classQueue, method)
+		if !ok {
+			return
+		}
+		if ex, ok := ch.s.exchanges[exname]; ok {
+			ch.s.unbind(ex, func(b *binding) bool {
+				return b.queue == q && b.key == key && equalArgs(b.args, args)
+			})
+		}
+
+		ch.conn.send(methodFrame(ch.id, classQueue, 51, nil))
+
+	case 30: // purge
+		d.short()
+		name := d.shortstr()
+		nowait := d.flag()
+		if d.err != nil {
+			return
+		}
+
+		q, ok := ch.lookupQueue(name, classQueue, method)
+		if !ok {
+			return
+		}
+		count := len(q.messages)
+		q.messages = nil
+
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classQueue, 31, (&encoder{}).long(uint32(count))))
+		}
+
+	case 40: // delete
+		d.short()
+		name := d.shortstr()
+		ifUnused, ifEmpty, nowait := d.flag(), d.flag(), d.flag()
+		if d.err != nil {
+			return
+		}
+
+		count := 0
+		if _, exists := ch.s.queues[name]; exists {
+			q, ok := ch.lookupQueue(name, classQueue, method)
+			if !ok {
+				return
+			}
+			if ifUnused && len(q.consumers) > 0 {
+				ch.fail(preconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - queue '%s' in vhost '/' in use", name), classQueue, method)
+				return
+			}
+			if ifEmpty && len(q.messages) > 0 {
+				ch.fail(preconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - queue '%s' in vhost '/' is not empty", name), classQueue, method)
+				return
+			}
+			count = ch.s.deleteQueue(q)
+		}
+
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classQueue, 41, (&encoder{}).long(uint32(count))))
+		}
+
+	default:
+		ch.conn.fail(notImplemented, fmt.Sprintf("NOT_IMPLEMENTED - queue method %d", method), classQueue, method)
+	}
+}
+
+func (ch *channel) handleBasic(d *decoder, method uint16) {
+	switch method {
+	case 10: // qos
+		d.long()
+		count := int(d.short())
+		global := d.flag()
+		if global {
+			ch.prefetchGlobal = count
+		} else {
+			ch.prefetch = count
+		}
+		ch.conn.send(methodFrame(ch.id, classBasic, 11, nil))
+
+	case 20: // consume
+		d.short()
+		name, tag := d.shortstr(), d.shortstr()
+		_, noAck, exclusive, nowait := d.flag(), d.flag(), d.flag(), d.flag()
+		args := d.table()
+		if d.err != nil {
+			return
+		}
+
+		q, ok := ch.lookupQueue(name, classBasic, method)
+		if !ok {
+			return
+		}
+		if exclusive && len(q.consumers) > 0 || len(q.consumers) > 0 && q.consumers[0].exclusive {
+			ch.fail(accessRefused, fmt.Sprintf("ACCESS_REFUSED - queue '%s' in vhost '/' in exclusive use", name), classBasic, method)
+			return
+		}
+		if tag == "" {
+			tag = randomName("amq.ctag-")
+		}
+		if _, ok := ch.consumers[tag]; ok {
+			ch.conn.fail(notAllowed, fmt.Sprintf("NOT_ALLOWED - attempt to reuse consumer tag '%s'", tag), classBasic, method)
+			return
+		}
+
+		c := &consumer{
+			tag:       tag,
+			ch:        ch,
+			queue:     q,
+			noAck:     noAck,
+			exclusive: exclusive,
+			args:      args,
+			prefetch:  ch.prefetch,
+		}
+		if q.kind() == queueStream {
+			if noAck || ch.prefetch == 0 && ch.prefetchGlobal == 0 {
+				ch.fail(preconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - consumers of stream '%s' in vhost '/' must ack and set a prefetch count", name), classBasic, method)
+				return
+			}
+			if c.offset, ok = q.streamOffset(args["x-stream-offset"]); !ok {
+				ch.fail(preconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - invalid arg 'x-stream-offset' for consumer of stream '%s' in vhost '/'", name), classBasic, method)
+				return
+			}
+		}
+		ch.consumers[tag] = c
+		q.consumers = append(q.consumers, c)
+		q.consumed = true
+
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classBasic, 21, (&encoder{}).shortstr(tag)))
+		}
+		ch.s.dispatch(q)
+
+	case 30: // cancel
+		tag := d.shortstr()
+		nowait := d.flag()
+		if d.err != nil {
+			return
+		}
+
+		if c, ok := ch.consumers[tag]; ok {
+			ch.s.cancelConsumer(c)
+		}
+		if !nowait {
+			ch.conn.send(methodFrame(ch.id, classBasic, 31, (&encoder{}).shortstr(tag)))
+		}
+
+	case 40: // publish
+		d.short()
+		exname, key := d.shortstr(), d.shortstr()
+		mandatory, immediate := d.flag(), d.flag()
+		if d.err != nil {
+			return
+		}
+
+		if immediate {
+			ch.conn.fail(notImplemented, "NOT_IMPLEMENTED - immediate=true", classBasic, method)
+			return
+		}
+		ch.pending = &publish{
+			exchange:   exname,
+			routingKey: key,
+			mandatory:  mandatory,
+		}
+
+	case 70: // get
+		d.short()
+		name := d.shortstr()
+		noAck := d.flag()
+		if d.err != nil {
+			return
+		}
+
+		q, ok := ch.lookupQueue(name, classBasic, method)
+		if !ok {
+			return
+		}
+		if q.kind() == queueStream {
+			ch.fail(preconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - basic.get is not supported by stream '%s' in vhost '/'", name), classBasic, method)
+			return
+		}
+		if len(q.messages) == 0 {
+			ch.conn.send(methodFrame(ch.id, classBasic, 72, (&encoder{}).shortstr("")))
+			return
+		}
+
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		ch.tag++
+		if !noAck {
+			ch.unacked[ch.tag] = &delivery{tag: ch.tag, msg: msg, queue: q}
+		}
+
+		frames := []frame{methodFrame(ch.id, classBasic, 71, (&encoder{}).
+			longlong(ch.tag).
+			flag(msg.redelivered).
+			shortstr(msg.exchange).
+			shortstr(msg.routingKey).
+			long(uint32(len(q.messages))))}
+		ch.conn.send(append(frames, contentFrames(ch.id, msg.props, msg.body)...)...)
+
+	case 80: // ack
+		tag := d.longlong()
+		multiple := d.flag()
+		if d.err != nil {
+			return
+		}
+		ch.ack(tag, multiple, method, func(settled []*delivery) {
+			for _, s := range settled {
+				if s.consumer != nil {
+					s.consumer.unacked--
+				}
+			}
+		})
+
+	case 90: // reject
+		tag := d.longlong()
+		requeue := d.flag()
+		if d.err != nil {
+			return
+		}
+		ch.ack(tag, false, method, ch.reject(requeue))
+
+	case 120: // nack
+		tag := d.longlong()
+		multiple, requeue := d.flag(), d.flag()
+		if d.err != nil {
+			return
+		}
+		ch.ack(tag, multiple, method, ch.reject(requeue))
+
+	case 100, 110: // recover-async, recover
+		d.flag()
+		ch.s.requeue(ch.settle(0, true))
+		if method == 110 {
+			ch.conn.send(methodFrame(ch.id, classBasic, 111, nil))
+		}
+
+	default:
+		ch.conn.fail(notImplemented, fmt.Sprintf("NOT_IMPLEMENTED - basic method %d", method), classBasic, method)
+	}
+}
+
+// ack settles deliveries up to the tag, an unknown tag is a channel error.
+func (ch *channel) ack(tag uint64, multiple bool, method uint16, fn func([]*delivery)) {
+	if _, ok := ch.unacked[tag]; !ok && !(multiple && tag == 0) {
+		ch.fail(preconditionFailed, fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag), classBasic, method)
+		return
+	}
+
+	settled := ch.settle(tag, multiple)
+	fn(settled)
+
+	for _, d := range settled {
+		if q, ok := ch.s.queues[d.queue.name]; ok && q == d.queue {
+			ch.s.dispatch(q)
+		}
+	}
+}
+
+func (ch *channel) reject(requeue bool) func([]*delivery) {
+	return func(settled []*delivery) {
+		if requeue {
+			ch.s.requeue(settled)
+			return
+		}
+		for _, s := range settled {
+			if s.consumer != nil {
+				s.consumer.unacked--
+			}
+		}
+	}
+}
+
+// publish routes the assembled message and confirms it when in confirm mode.
+func (ch *channel) publish() {
+	p := ch.pending
+	ch.pending = nil
+
+	ex, ok := ch.s.exchanges[p.exchange]
+	if !ok {
+		ch.fail(notFound, notFoundExchange(p.exchange), classBasic, 40)
+		return
+	}
+	if ex.internal {
+		ch.fail(accessRefused, fmt.Sprintf("ACCESS_REFUSED - cannot publish to internal exchange '%s' in vhost '/'", p.exchange), classBasic, 40)
+		return
+	}
+
+	if ex.kind == delayedMessage {
+		if delay, ok := toInt64(p.props.Headers["x-delay"]); ok && delay > 0 {
+			ch.s.delay(ex, &message{
+				exchange:   p.exchange,
+				routingKey: p.routingKey,
+				props:      p.props,
+				body:       p.body,
+			}, time.Duration(delay)*time.Millisecond)
+			if ch.confirm {
+				ch.seq++
+				ch.conn.send(methodFrame(ch.id, classBasic, 80, (&encoder{}).longlong(ch.seq).flag(false)))
+			}
+			return
+		}
+	}
+
+	queues := ch.s.route(ex, p.routingKey, p.props.Headers)
+	if len(queues) == 0 && p.mandatory {
+		frames := []frame{methodFrame(ch.id, classBasic, 50, (&encoder{}).
+			short(noRoute).
+			shortstr("NO_ROUTE").
+			shortstr(p.exchange).
+			shortstr(p.routingKey))}
+		ch.conn.send(append(frames, contentFrames(ch.id, p.props, p.body)...)...)
+	}
+
+	rejected := false
+	for _, q := range queues {
+		if q.rejects() {
+			rejected = true
+			continue
+		}
+		ch.s.enqueue(q, &message{
+			exchange:   p.exchange,
+			routingKey: p.routingKey,
+			props:      p.props,
+			body:       p.body,
+		})
+	}
+
+	if ch.confirm {
+		ch.seq++
+		if rejected {
+			ch.conn.send(methodFrame(ch.id, classBasic, 120, (&encoder{}).longlong(ch.seq).flag(false).flag(false)))
+		} else {
+			ch.conn.send(methodFrame(ch.id, classBasic, 80, (&encoder{}).longlong(ch.seq).flag(false)))
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package amqptest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+var errSyntax = errors.New("amqptest: malformed frame")
+
+// decoder reads the AMQP 0-9-1 domain types out of a method or header payload.
+type decoder struct {
+	buf  []byte
+	pos  int
+	bits byte
+	bit  uint
+	err  error
+}
+
+func newDecoder(buf []byte) *decoder {
+	return &decoder{buf: buf}
+}
+
+func (d *decoder) next(n int) []byte {
+	d.bit = 0
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if d.pos+n > len(d.buf) {
+		d.err = errSyntax
+		return make([]byte, n)
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) octet() uint8 {
+	return d.next(1)[0]
+}
+
+func (d *decoder) short() uint16 {
+	return binary.BigEndian.Uint16(d.next(2))
+}
+
+func (d *decoder) long() uint32 {
+	return binary.BigEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) longlong() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) shortstr() string {
+	return string(d.next(int(d.octet())))
+}
+
+func (d *decoder) longstr() string {
+	return string(d.next(int(d.long())))
+}
+
+// flag reads consecutive bit fields packed into octets.
+func (d *decoder) flag() bool {
+	if d.bit == 0 || d.bit > 7 {
+		d.bits = d.next(1)[0]
+		d.bit = 0
+	}
+	v := d.bits&(1<<d.bit) != 0
+	d.bit++
+	return v
+}
+
+func (d *decoder) table() amqp.Table {
+	nested := newDecoder(d.next(int(d.long())))
+	table := amqp.Table{}
+	for nested.err == nil && nested.pos < len(nested.buf) {
+		key := nested.shortstr()
+		table[key] = nested.field()
+	}
+	if nested.err != nil {
+		d.err = nested.err
+	}
+	return table
+}
+
+func (d *decoder) field() interface{} {
+	switch d.octet() {
+	case 't':
+		return d.octet() != 0
+	case 'b', 'B':
+		return d.octet()
+	case 's':
+		return int16(d.short())
+	case 'u':
+		return d.short()
+	case 'I':
+		return int32(d.long())
+	case 'i':
+		return d.long()
+	case 'l':
+		return int64(d.longlong())
+	case 'f':
+		return math.Float32frombits(d.long())
+	case 'd':
+		return math.Float64frombits(d.longlong())
+	case 'D':
+		scale := d.octet()
+		return amqp.Decimal{Scale: scale, Value: int32(d.long())}
+	case 'S':
+		return d.longstr()
+	case 'x':
+		return append([]byte(nil), d.next(int(d.long()))...)
+	case 'T':
+		return time.Unix(int64(d.longlong()), 0)
+	case 'F':
+		return d.table()
+	case 'A':
+		nested := newDecoder(d.next(int(d.long())))
+		array := make([]interface{}, 0)
+		for nested.err == nil && nested.pos < len(nested.buf) {
+			array = append(array, nested.field())
+		}
+		if nested.err != nil {
+			d.err = nested.err
+		}
+		return array
+	case 'V':
+		return nil
+	}
+	d.err = errSyntax
+	return nil
+}
+
+// encoder writes the AMQP 0-9-1 domain types of a method or header payload.
+type encoder struct {
+	buf  bytes.Buffer
+	bits byte
+	bit  uint
+}
+
+func (e *encoder) flush() {
+	if e.bit > 0 {
+		e.buf.WriteByte(e.bits)
+		e.bits, e.bit = 0, 0
+	}
+}
+
+func (e *encoder) octet(v uint8) *encoder {
+	e.flush()
+	e.buf.WriteByte(v)
+	return e
+}
+
+func (e *encoder) short(v uint16) *encoder {
+	e.flush()
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	e.buf.Write(b[:])
+	return e
+}
+
+func (e *encoder) long(v uint32) *encoder {
+	e.flush()
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+	return e
+}
+
+func (e *encoder) longlong(v uint64) *encoder {
+	e.flush()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+	return e
+}
+
+func (e *encoder) shortstr(v string) *encoder {
+	e.octet(uint8(len(v)))
+	e.buf.WriteString(v)
+	return e
+}
+
+func (e *encoder) longstr(v string) *encoder {
+	e.long(uint32(len(v)))
+	e.buf.WriteString(v)
+	return e
+}
+
+func (e *encoder) flag(v bool) *encoder {
+	if e.bit > 7 {
+		e.flush()
+	}
+	if v {
+		e.bits |= 1 << e.bit
+	}
+	e.bit++
+	return e
+}
+
+func (e *encoder) table(t amqp.Table) *encoder {
+	nested := &encoder{}
+	for k, v := range t {
+		nested.shortstr(k)
+		nested.field(v)
+	}
+	nested.flush()
+	e.long(uint32(nested.buf.Len()))
+	e.buf.Write(nested.buf.Bytes())
+	return e
+}
+
+func (e *encoder) field(value interface{}) {
+	switch v := value.(type) {
+	case bool:
+		e.octet('t')
+		if v {
+			e.octet(1)
+		} else {
+			e.octet(0)
+		}
+	case uint8:
+		e.octet('b').octet(v)
+	case int16:
+		e.octet('s').short(uint16(v))
+	case uint16:
+		e.octet('u').short(v)
+	case int:
+		e.octet('I').long(uint32(v))
+	case int32:
+		e.octet('I').long(uint32(v))
+	case uint32:
+		e.octet('i').long(v)
+	case int64:
+		e.octet('l').longlong(uint64(v))
+	case float32:
+		e.octet('f').long(math.Float32bits(v))
+	case float64:
+		e.octet('d').longlong(math.Float64bits(v))
+	case amqp.Decimal:
+		e.octet('D').octet(v.Scale).long(uint32(v.Value))
+	case string:
+		e.octet('S').longstr(v)
+	case []byte:
+		e.octet('x').long(uint32(len(v)))
+		e.buf.Write(v)
+	case time.Time:
+		e.octet('T').longlong(uint64(v.Unix()))
+	case amqp.Table:
+		e.octet('F').table(v)
+	case map[string]interface{}:
+		e.octet('F').table(amqp.Table(v))
+	case []interface{}:
+		nested := &encoder{}
+		for _, item := range v {
+			nested.field(item)
+		}
+		nested.flush()
+		e.octet('A').long(uint32(nested.buf.Len()))
+		e.buf.Write(nested.buf.Bytes())
+	default:
+		e.octet('V')
+	}
+}
+
+func (e *encoder) bytes() []byte {
+	e.flush()
+	return e.buf.Bytes()
+}
+
+const (
+	flagContentType     = 0x8000
+	flagContentEncoding = 0x4000
+	flagHeaders         = 0x2000
+	flagDeliveryMode    = 0x1000
+	flagPriority        = 0x0800
+	flagCorrelationID   = 0x0400
+	flagReplyTo         = 0x0200
+	flagExpiration      = 0x0100
+	flagMessageID       = 0x0080
+	flagTimestamp       = 0x0040
+	flagType            = 0x0020
+	flagUserID          = 0x0010
+	flagAppID           = 0x0008
+)
+
+// Properties are the basic class content properties carried by a message.
+type Properties struct {
+	ContentType     string
+	ContentEncoding string
+	Headers         amqp.Table
+	DeliveryMode    uint8
+	Priority        uint8
+	CorrelationId   string
+	ReplyTo         string
+	Expiration      string
+	MessageId       string
+	Timestamp       time.Time
+	Type            string
+	UserId          string
+	AppId           string
+}
+
+func decodeProperties(d *decoder) Properties {
+	var p Properties
+	flags := d.short()
+	if flags&flagContentType != 0 {
+		p.ContentType = d.shortstr()
+	}
+	if flags&flagContentEncoding != 0 {
+		p.ContentEncoding = d.shortstr()
+	}
+	if flags&flagHeaders != 0 {
+		p.Headers = d.table()
+	}
+	if flags&flagDeliveryMode != 0 {
+		p.DeliveryMode = d.octet()
+	}
+	if flags&flagPriority != 0 {
+		p.Priority = d.octet()
+	}
+	if flags&flagCorrelationID != 0 {
+		p.CorrelationId = d.shortstr()
+	}
+	if flags&flagReplyTo != 0 {
+		p.ReplyTo = d.shortstr()
+	}
+	if flags&flagExpiration != 0 {
+		p.Expiration = d.shortstr()
+	}
+	if flags&flagMessageID != 0 {
+		p.MessageId = d.shortstr()
+	}
+	if flags&flagTimestamp != 0 {
+		p.Timestamp = time.Unix(int64(d.longlong()), 0)
+	}
+	if flags&flagType != 0 {
+		p.Type = d.shortstr()
+	}
+	if flags&flagUserID != 0 {
+		p.UserId = d.shortstr()
+	}
+	if flags&flagAppID != 0 {
+		p.AppId = d.shortstr()
+	}
+	return p
+}
+
+func encodeProperties(e *encoder, p Properties) {
+	var flags uint16
+	if p.ContentType != "" {
+		flags |= flagContentType
+	}
+	if p.ContentEncoding != "" {
+		flags |= flagContentEncoding
+	}
+	if len(p.Headers) > 0 {
+		flags |= flagHeaders
+	}
+	if p.DeliveryMode > 0 {
+		flags |= flagDeliveryMode
+	}
+	if p.Priority > 0 {
+		flags |= flagPriority
+	}
+	if p.CorrelationId != "" {
+		flags |= flagCorrelationID
+	}
+	if p.ReplyTo != "" {
+		flags |= flagReplyTo
+	}
+	if p.Expiration != "" {
+		flags |= flagExpiration
+	}
+	if p.MessageId != "" {
+		flags |= flagMessageID
+	}
+	if !p.Timestamp.IsZero() {
+		flags |= flagTimestamp
+	}
+	if p.Type != "" {
+		flags |= flagType
+	}
+	if p.UserId != "" {
+		flags |= flagUserID
+	}
+	if p.AppId != "" {
+		flags |= flagAppID
+	}
+
+	e.short(flags)
+	if flags&flagContentType != 0 {
+		e.shortstr(p.ContentType)
+	}
+	if flags&flagContentEncoding != 0 {
+		e.shortstr(p.ContentEncoding)
+	}
+	if flags&flagHeaders != 0 {
+		e.table(p.Headers)
+	}
+	if flags&flagDeliveryMode != 0 {
+		e.octet(p.DeliveryMode)
+	}
+	if flags&flagPriority != 0 {
+		e.octet(p.Priority)
+	}
+	if flags&flagCorrelationID != 0 {
+		e.shortstr(p.CorrelationId)
+	}
+	if flags&flagReplyTo != 0 {
+		e.shortstr(p.ReplyTo)
+	}
+	if flags&flagExpiration != 0 {
+		e.shortstr(p.Expiration)
+	}
+	if flags&flagMessageID != 0 {
+		e.shortstr(p.MessageId)
+	}
+	if flags&flagTimestamp != 0 {
+		e.longlong(uint64(p.Timestamp.Unix()))
+	}
+	if flags&flagType != 0 {
+		e.shortstr(p.Type)
+	}
+	if flags&flagUserID != 0 {
+		e.shortstr(p.UserId)
+	}
+	if flags&flagAppID != 0 {
+		e.shortstr(p.AppId)
+	}
+}
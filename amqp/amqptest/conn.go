@@ -0,0 +1,300 @@
+package amqptest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	protocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+	errConnectionClosed = errors.New("amqptest: connection closed")
+)
+
+type conn struct {
+	s  *Server
+	nc net.Conn
+
+	// guarded by s.mu
+	channels  map[uint16]*channel
+	opened    bool
+	closing   bool
+	heartbeat time.Duration
+
+	outMu     sync.Mutex
+	outCond   *sync.Cond
+	out       [][]byte
+	outClosed bool
+	written   chan struct{}
+
+	done chan struct{}
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	c := &conn{
+		s:        s,
+		nc:       nc,
+		channels: make(map[uint16]*channel),
+		written:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.outCond = sync.NewCond(&c.outMu)
+	return c
+}
+
+func (c *conn) serve() {
+	defer c.s.wg.Done()
+	defer c.nc.Close()
+
+	r := bufio.NewReader(c.nc)
+	header := make([]byte, len(protocolHeader))
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header, protocolHeader) {
+		c.nc.Write(protocolHeader)
+		c.s.release(c)
+		return
+	}
+
+	go c.writeLoop()
+
+	c.send(methodFrame(0, classConnection, 10, (&encoder{}).
+		octet(0).
+		octet(9).
+		table(map[string]interface{}{
+			"product": "amqptest",
+			"version": "0.0.1",
+			"capabilities": map[string]interface{}{
+				"publisher_confirms":         true,
+				"basic.nack":                 true,
+				"consumer_cancel_notify":     true,
+				"exchange_exchange_bindings": false,
+				"connection.blocked":         true,
+				"per_consumer_qos":           true,
+			},
+		}).
+		longstr("PLAIN AMQPLAIN").
+		longstr("en_US")))
+
+	for {
+		if hb := c.heartbeatInterval(); hb > 0 {
+			c.nc.SetReadDeadline(time.Now().Add(3 * hb))
+		}
+
+		f, err := readFrame(r)
+		if err != nil {
+			break
+		}
+
+		if err := c.handle(f); err != nil {
+			break
+		}
+	}
+
+	close(c.done)
+	c.s.release(c)
+	c.closeWriter()
+
+	// the client closes the socket once it reads connection.close-ok, closing
+	// it first would make the client see an abrupt disconnection
+	c.nc.SetReadDeadline(time.Now().Add(time.Second))
+	io.Copy(ioutil.Discard, r)
+}
+
+func (c *conn) heartbeatInterval() time.Duration {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	return c.heartbeat
+}
+
+// send queues frames for the writer, it never blocks so it is safe to call
+// while holding the server lock.
+func (c *conn) send(frames ...frame) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	if c.outClosed {
+		return
+	}
+	for _, f := range frames {
+		c.out = append(c.out, f.encode())
+	}
+	c.outCond.Signal()
+}
+
+func (c *conn) writeLoop() {
+	defer close(c.written)
+
+	w := bufio.NewWriter(c.nc)
+	for {
+		c.outMu.Lock()
+		for len(c.out) == 0 && !c.outClosed {
+			c.outCond.Wait()
+		}
+		if len(c.out) == 0 {
+			c.outMu.Unlock()
+			return
+		}
+		batch := c.out
+		c.out = nil
+		c.outMu.Unlock()
+
+		for _, b := range batch {
+			w.Write(b)
+		}
+		if err := w.Flush(); err != nil {
+			c.outMu.Lock()
+			c.outClosed = true
+			c.out = nil
+			c.outMu.Unlock()
+			c.nc.Close()
+			return
+		}
+	}
+}
+
+// closeWriter flushes the queued frames and stops the writer.
+func (c *conn) closeWriter() {
+	c.outMu.Lock()
+	c.outClosed = true
+	c.outCond.Signal()
+	c.outMu.Unlock()
+
+	select {
+	case <-c.written:
+	case <-time.After(time.Second):
+	}
+}
+
+func (c *conn) heartbeater(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.send(frame{typ: frameHeartbeat})
+		}
+	}
+}
+
+// fail closes the connection with a connection exception. Requires s.mu.
+func (c *conn) fail(code uint16, text string, class, method uint16) {
+	if c.closing {
+		return
+	}
+	c.closing = true
+	c.cleanup()
+	c.send(methodFrame(0, classConnection, 50, (&encoder{}).
+		short(code).
+		shortstr(text).
+		short(class).
+		short(method)))
+
+	time.AfterFunc(time.Second, func() { c.nc.Close() })
+}
+
+// cleanup releases every channel and the exclusive queues of the connection.
+// Requires s.mu.
+func (c *conn) cleanup() {
+	for id, ch := range c.channels {
+		ch.cleanup()
+		delete(c.channels, id)
+	}
+
+	for _, q := range c.s.queues {
+		if q.exclusive && q.owner == c {
+			c.s.deleteQueue(q)
+		}
+	}
+}
+
+func (c *conn) handle(f frame) error {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	if f.typ == frameHeartbeat {
+		return nil
+	}
+
+	if f.channel == 0 {
+		return c.handleConnection(f)
+	}
+
+	if c.closing || !c.opened {
+		return nil
+	}
+
+	ch, ok := c.channels[f.channel]
+	if !ok {
+		if f.typ == frameMethod {
+			d := newDecoder(f.payload)
+			class, method := d.short(), d.short()
+			if class == classChannel && method == 10 {
+				ch = newChannel(c, f.channel)
+				c.channels[f.channel] = ch
+				c.send(methodFrame(f.channel, classChannel, 11, (&encoder{}).longstr("")))
+				return nil
+			}
+			c.fail(channelError, "CHANNEL_ERROR - expected 'channel.open'", class, method)
+			return nil
+		}
+		c.fail(channelError, "CHANNEL_ERROR - expected 'channel.open'", 0, 0)
+		return nil
+	}
+
+	ch.handle(f)
+	return nil
+}
+
+func (c *conn) handleConnection(f frame) error {
+	if f.typ != frameMethod {
+		c.fail(unexpectedFrame, "UNEXPECTED_FRAME - expected method frame on channel 0", 0, 0)
+		return nil
+	}
+
+	d := newDecoder(f.payload)
+	class, method := d.short(), d.short()
+	if class != classConnection {
+		c.fail(commandInvalid, "COMMAND_INVALID - expected connection class method", class, method)
+		return nil
+	}
+
+	switch method {
+	case 11: // start-ok
+		c.send(methodFrame(0, classConnection, 30, (&encoder{}).
+			short(2047).
+			long(frameMax).
+			short(0)))
+
+	case 31: // tune-ok
+		d.short()
+		d.long()
+		if hb := d.short(); hb > 0 {
+			c.heartbeat = time.Duration(hb) * time.Second
+			go c.heartbeater(c.heartbeat)
+		}
+
+	case 40: // open
+		c.opened = true
+		c.send(methodFrame(0, classConnection, 41, (&encoder{}).shortstr("")))
+
+	case 50: // close
+		c.closing = true
+		c.cleanup()
+		c.send(methodFrame(0, classConnection, 51, nil))
+		return errConnectionClosed
+
+	case 51: // close-ok
+		return errConnectionClosed
+	}
+
+	return nil
+}
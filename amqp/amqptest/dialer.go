@@ -0,0 +1,149 @@
+package amqptest
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrRefused is returned by Dialer.Dial while the dialer refuses connections.
+var ErrRefused = errors.New("amqptest: connection refused")
+
+// Dialer dials TCP connections whose faults are controlled by the test. Its
+// Dial method matches the signature of amqp.Config.Dial, so it can be handed
+// to the amqp package with SetConnectionDialer.
+type Dialer struct {
+	mu        sync.Mutex
+	conns     map[*faultConn]struct{}
+	delay     time.Duration
+	refuse    bool
+	blackhole bool
+}
+
+// NewDialer returns a dialer without any fault enabled.
+func NewDialer() *Dialer {
+	return &Dialer{
+		conns: make(map[*faultConn]struct{}),
+	}
+}
+
+// Dial connects to the address unless the dialer refuses connections.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	refuse := d.refuse
+	d.mu.Unlock()
+	if refuse {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ErrRefused}
+	}
+
+	nc, err := net.DialTimeout(network, addr, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &faultConn{Conn: nc, d: d}
+	d.mu.Lock()
+	d.conns[c] = struct{}{}
+	d.mu.Unlock()
+
+	return c, nil
+}
+
+// Refuse makes the following dials fail while refuse is true, open
+// connections are left untouched.
+func (d *Dialer) Refuse(refuse bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.refuse = refuse
+}
+
+// Delay adds latency to every read and write of the connections, a zero
+// delay removes it.
+func (d *Dialer) Delay(delay time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.delay = delay
+}
+
+// Blackhole silently discards the traffic of the connections in both
+// directions while blackhole is true. The sockets stay open, so the peers only
+// notice it through heartbeats or deadlines.
+func (d *Dialer) Blackhole(blackhole bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.blackhole = blackhole
+}
+
+// Drop abruptly closes every open connection, as a network failure would.
+func (d *Dialer) Drop() {
+	d.mu.Lock()
+	conns := make([]*faultConn, 0, len(d.conns))
+	for c := range d.conns {
+		conns = append(conns, c)
+	}
+	d.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// Conns returns the number of open connections dialed.
+func (d *Dialer) Conns() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.conns)
+}
+
+func (d *Dialer) faults() (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.delay, d.blackhole
+}
+
+type faultConn struct {
+	net.Conn
+	d *Dialer
+
+	once sync.Once
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(b)
+		delay, blackhole := c.d.faults()
+		if blackhole && err == nil {
+			continue
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		return n, err
+	}
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	delay, blackhole := c.d.faults()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if blackhole {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *faultConn) Close() error {
+	c.once.Do(func() {
+		c.d.mu.Lock()
+		delete(c.d.conns, c)
+		c.d.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,128 @@
+package amqptest
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DialerUnitSuite struct {
+	suite.Suite
+
+	listener net.Listener
+	dialer   *Dialer
+}
+
+func (s *DialerUnitSuite) SetupTest() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.listener = l
+
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer nc.Close()
+				io.Copy(nc, nc)
+			}()
+		}
+	}()
+
+	s.dialer = NewDialer()
+}
+
+func (s *DialerUnitSuite) TearDownTest() {
+	s.listener.Close()
+}
+
+func (s *DialerUnitSuite) echo(nc net.Conn, body string) (string, error) {
+	if _, err := nc.Write([]byte(body)); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, len(body))
+	nc.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	_, err := io.ReadFull(nc, buf)
+	return string(buf), err
+}
+
+func (s *DialerUnitSuite) TestDial() {
+	assert := s.Assert()
+
+	nc, err := s.dialer.Dial("tcp", s.listener.Addr().String())
+	assert.NoError(err)
+	defer nc.Close()
+
+	body, err := s.echo(nc, "body")
+	assert.NoError(err)
+	assert.Equal("body", body)
+	assert.Equal(1, s.dialer.Conns())
+}
+
+func (s *DialerUnitSuite) TestRefuse() {
+	assert := s.Assert()
+
+	s.dialer.Refuse(true)
+	_, err := s.dialer.Dial("tcp", s.listener.Addr().String())
+	assert.Error(err)
+	assert.Equal(0, s.dialer.Conns())
+
+	s.dialer.Refuse(false)
+	nc, err := s.dialer.Dial("tcp", s.listener.Addr().String())
+	assert.NoError(err)
+	nc.Close()
+}
+
+func (s *DialerUnitSuite) TestDrop() {
+	assert := s.Assert()
+
+	nc, err := s.dialer.Dial("tcp", s.listener.Addr().String())
+	assert.NoError(err)
+
+	s.dialer.Drop()
+	assert.Equal(0, s.dialer.Conns())
+
+	_, err = s.echo(nc, "body")
+	assert.Error(err)
+}
+
+func (s *DialerUnitSuite) TestDelay() {
+	assert := s.Assert()
+
+	nc, err := s.dialer.Dial("tcp", s.listener.Addr().String())
+	assert.NoError(err)
+	defer nc.Close()
+
+	s.dialer.Delay(time.Millisecond * 50)
+	start := time.Now()
+	_, err = s.echo(nc, "body")
+	assert.NoError(err)
+	assert.True(time.Since(start) >= time.Millisecond*100)
+}
+
+func (s *DialerUnitSuite) TestBlackhole() {
+	assert := s.Assert()
+
+	nc, err := s.dialer.Dial("tcp", s.listener.Addr().String())
+	assert.NoError(err)
+	defer nc.Close()
+
+	s.dialer.Blackhole(true)
+	_, err = s.echo(nc, "body")
+	assert.Error(err)
+
+	s.dialer.Blackhole(false)
+	body, err := s.echo(nc, "body")
+	assert.NoError(err)
+	assert.Equal("body", body)
+}
+
+func TestDialerUnitSuite(t *testing.T) {
+	suite.Run(t, new(DialerUnitSuite))
+}
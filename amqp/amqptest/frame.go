@@ -0,0 +1,117 @@
+package amqptest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+
+	frameMax = 131072
+)
+
+const (
+	classConnection = 10
+	classChannel    = 20
+	classExchange   = 40
+	classQueue      = 50
+	classBasic      = 60
+	classConfirm    = 85
+	classTx         = 90
+)
+
+const (
+	replySuccess       = 200
+	contentTooLarge    = 311
+	noRoute            = 312
+	noConsumers        = 313
+	connectionForced   = 320
+	invalidPath        = 402
+	accessRefused      = 403
+	notFound           = 404
+	resourceLocked     = 405
+	preconditionFailed = 406
+	frameError         = 501
+	syntaxError        = 502
+	commandInvalid     = 503
+	channelError       = 504
+	unexpectedFrame    = 505
+	resourceError      = 506
+	notAllowed         = 530
+	notImplemented     = 540
+	internalError      = 541
+)
+
+type frame struct {
+	typ     uint8
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var header [7]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		typ:     header[0],
+		channel: binary.BigEndian.Uint16(header[1:3]),
+		payload: make([]byte, binary.BigEndian.Uint32(header[3:7])),
+	}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+
+	end, err := r.ReadByte()
+	if err != nil {
+		return frame{}, err
+	}
+	if end != frameEnd {
+		return frame{}, errSyntax
+	}
+	return f, nil
+}
+
+func (f frame) encode() []byte {
+	buf := make([]byte, 7+len(f.payload)+1)
+	buf[0] = f.typ
+	binary.BigEndian.PutUint16(buf[1:3], f.channel)
+	binary.BigEndian.PutUint32(buf[3:7], uint32(len(f.payload)))
+	copy(buf[7:], f.payload)
+	buf[len(buf)-1] = frameEnd
+	return buf
+}
+
+func methodFrame(channel uint16, class, method uint16, args *encoder) frame {
+	e := &encoder{}
+	e.short(class).short(method)
+	if args != nil {
+		e.buf.Write(args.bytes())
+	}
+	return frame{typ: frameMethod, channel: channel, payload: e.bytes()}
+}
+
+// contentFrames builds the header and body frames carrying a message.
+func contentFrames(channel uint16, props Properties, body []byte) []frame {
+	e := &encoder{}
+	e.short(classBasic).short(0).longlong(uint64(len(body)))
+	encodeProperties(e, props)
+
+	frames := []frame{{typ: frameHeader, channel: channel, payload: e.bytes()}}
+	for len(body) > 0 {
+		n := len(body)
+		if n > frameMax-8 {
+			n = frameMax - 8
+		}
+		frames = append(frames, frame{typ: frameBody, channel: channel, payload: body[:n]})
+		body = body[n:]
+	}
+	return frames
+}
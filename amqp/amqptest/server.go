@@ -0,0 +1,232 @@
+// Package amqptest provides an in-memory AMQP 0-9-1 broker for tests.
+//
+// The server implements the subset of RabbitMQ used by the amqp package:
+// exchanges of the direct, fanout, topic and headers kinds, queues, bindings,
+// basic publish, consume, get, ack, nack, reject and recover, publisher
+// confirms, returns of mandatory messages, consumer priorities and cancel
+// notifications, queue length limits, priorities, message TTL with
+// dead-lettering, quorum queue delivery counts and limits, streams read from
+// an offset, exchange to exchange bindings and channel and connection close
+// handshakes. Messages are never persisted.
+//
+// Dialer complements the server with network faults, it drops, delays,
+// blackholes or refuses the connections dialed through it.
+package amqptest
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+type ServerOptionsFn func(*ServerOptions)
+
+type ServerOptions struct {
+	delayedMessages bool
+}
+
+// SetServerDelayedMessages enables the x-delayed-message exchange type, as the
+// rabbitmq-delayed-message-exchange plugin does. Without it declaring such an
+// exchange closes the connection, as on a broker without the plugin.
+func SetServerDelayedMessages(enabled bool) ServerOptionsFn {
+	return func(o *ServerOptions) {
+		o.delayedMessages = enabled
+	}
+}
+
+// Server is an AMQP broker listening on a local port.
+type Server struct {
+	*ServerOptions
+
+	// URL is the amqp DSN clients dial to reach the server.
+	URL string
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	listener net.Listener
+
+	mu        sync.Mutex
+	exchanges map[string]*exchange
+	queues    map[string]*queue
+	conns     map[*conn]struct{}
+	closed    bool
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a server on a random port of the loopback interface. It
+// panics if it cannot listen, like httptest.NewServer.
+func NewServer(fns ...ServerOptionsFn) *Server {
+	o := &ServerOptions{}
+	SetServerDelayedMessages(false)(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("amqptest: failed to listen on a port: %v", err))
+	}
+
+	s := &Server{
+		ServerOptions: o,
+		URL:           fmt.Sprintf("amqp://guest:guest@%s/", l.Addr().String()),
+		Addr:          l.Addr().String(),
+		listener:      l,
+		exchanges:     make(map[string]*exchange),
+		queues:        make(map[string]*queue),
+		conns:         make(map[*conn]struct{}),
+	}
+
+	s.exchanges[""] = &exchange{name: "", kind: amqp.ExchangeDirect, durable: true}
+	for name, kind := range map[string]string{
+		"amq.direct":  amqp.ExchangeDirect,
+		"amq.fanout":  amqp.ExchangeFanout,
+		"amq.topic":   amqp.ExchangeTopic,
+		"amq.headers": amqp.ExchangeHeaders,
+		"amq.match":   amqp.ExchangeHeaders,
+	} {
+		s.exchanges[name] = &exchange{name: name, kind: kind, durable: true}
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		c := newConn(s, nc)
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go c.serve()
+	}
+}
+
+// Close stops listening, drops every client connection and waits for the
+// connection goroutines to exit.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	s.listener.Close()
+	for _, c := range conns {
+		c.nc.Close()
+	}
+	s.wg.Wait()
+}
+
+// CloseConnections gracefully closes every client connection with a
+// CONNECTION_FORCED error, as a broker does when an operator closes them.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.fail(connectionForced, "CONNECTION_FORCED - closed via management plugin", 0, 0)
+	}
+}
+
+// Block notifies the client connections they are blocked, as a broker does
+// when it raises a resource alarm. The server keeps accepting their
+// publishings.
+func (s *Server) Block(reason string) {
+	s.notify(methodFrame(0, classConnection, 60, (&encoder{}).shortstr(reason)))
+}
+
+// Unblock notifies the client connections they are no longer blocked.
+func (s *Server) Unblock() {
+	s.notify(methodFrame(0, classConnection, 61, nil))
+}
+
+func (s *Server) notify(f frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		if c.opened && !c.closing {
+			c.send(f)
+		}
+	}
+}
+
+// Connections returns the number of open client connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// QueueLength returns the number of messages ready for delivery in the queue,
+// or held by the stream.
+func (s *Server) QueueLength(name string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.queues[name]
+	if !ok {
+		return 0, false
+	}
+	return len(q.messages), true
+}
+
+// Consumers returns the number of consumers attached to the queue.
+func (s *Server) Consumers(name string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.queues[name]
+	if !ok {
+		return 0, false
+	}
+	return len(q.consumers), true
+}
+
+// DeleteQueue deletes the queue as an operator would, its consumers receive a
+// basic.cancel notification.
+func (s *Server) DeleteQueue(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.queues[name]
+	if !ok {
+		return false
+	}
+	s.deleteQueue(q)
+	return true
+}
+
+func (s *Server) release(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.cleanup()
+	delete(s.conns, c)
+}
@@ -0,0 +1,563 @@
+package amqptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/suite"
+)
+
+type ServerUnitSuite struct {
+	suite.Suite
+
+	srv  *Server
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+func (s *ServerUnitSuite) SetupTest() {
+	s.srv = NewServer()
+
+	conn, err := amqp.Dial(s.srv.URL)
+	s.Require().NoError(err)
+	s.conn = conn
+
+	ch, err := conn.Channel()
+	s.Require().NoError(err)
+	s.ch = ch
+}
+
+func (s *ServerUnitSuite) TearDownTest() {
+	s.conn.Close()
+	s.srv.Close()
+}
+
+func (s *ServerUnitSuite) declareTopic(exchange, queue string) {
+	s.Require().NoError(s.ch.ExchangeDeclare(exchange, "topic", false, true, false, false, nil))
+	_, err := s.ch.QueueDeclare(queue, true, false, false, false, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(s.ch.QueueBind(queue, "#", exchange, false, nil))
+}
+
+func (s *ServerUnitSuite) receive(deliveries <-chan amqp.Delivery) amqp.Delivery {
+	select {
+	case d, ok := <-deliveries:
+		s.Require().True(ok, "deliveries closed")
+		return d
+	case <-time.After(time.Second):
+		s.FailNow("no delivery received")
+		return amqp.Delivery{}
+	}
+}
+
+func (s *ServerUnitSuite) TestPublishAndConsume() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+
+	err := s.ch.Publish("exchange", "some.key", false, false, amqp.Publishing{
+		Headers:   amqp.Table{"key": "value", "int": 1, "nested": amqp.Table{"a": true}},
+		MessageId: "id",
+		Body:      []byte("body"),
+	})
+	assert.NoError(err)
+
+	deliveries, err := s.ch.Consume("queue", "consumer", false, false, false, false, nil)
+	assert.NoError(err)
+
+	d := s.receive(deliveries)
+	assert.Equal("body", string(d.Body))
+	assert.Equal("value", d.Headers["key"])
+	assert.Equal(int32(1), d.Headers["int"])
+	assert.Equal(amqp.Table{"a": true}, d.Headers["nested"])
+	assert.Equal("id", d.MessageId)
+	assert.Equal("exchange", d.Exchange)
+	assert.Equal("some.key", d.RoutingKey)
+	assert.Equal("consumer", d.ConsumerTag)
+	assert.False(d.Redelivered)
+	assert.NoError(d.Ack(false))
+}
+
+func (s *ServerUnitSuite) TestLargeBody() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+
+	body := make([]byte, frameMax*3)
+	for i := range body {
+		body[i] = byte(i)
+	}
+	assert.NoError(s.ch.Publish("exchange", "", false, false, amqp.Publishing{Body: body}))
+
+	deliveries, err := s.ch.Consume("queue", "", true, false, false, false, nil)
+	assert.NoError(err)
+	assert.Equal(body, s.receive(deliveries).Body)
+}
+
+func (s *ServerUnitSuite) TestNackRequeueRedelivers() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+
+	assert.NoError(s.ch.Publish("exchange", "", false, false, amqp.Publishing{Body: []byte("body")}))
+
+	deliveries, err := s.ch.Consume("queue", "", false, false, false, false, nil)
+	assert.NoError(err)
+
+	d := s.receive(deliveries)
+	assert.NoError(d.Nack(false, true))
+
+	d = s.receive(deliveries)
+	assert.True(d.Redelivered)
+	assert.NoError(d.Ack(false))
+}
+
+func (s *ServerUnitSuite) TestPrefetch() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+	assert.NoError(s.ch.Qos(1, 0, false))
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(s.ch.Publish("exchange", "", false, false, amqp.Publishing{}))
+	}
+
+	deliveries, err := s.ch.Consume("queue", "", false, false, false, false, nil)
+	assert.NoError(err)
+
+	d := s.receive(deliveries)
+	select {
+	case <-deliveries:
+		s.Fail("prefetch exceeded")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	assert.NoError(d.Ack(false))
+	s.receive(deliveries)
+}
+
+func (s *ServerUnitSuite) TestConfirms() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+
+	assert.NoError(s.ch.Confirm(false))
+	confirms := s.ch.NotifyPublish(make(chan amqp.Confirmation, 2))
+
+	assert.NoError(s.ch.Publish("exchange", "", false, false, amqp.Publishing{}))
+	assert.NoError(s.ch.Publish("exchange", "", false, false, amqp.Publishing{}))
+
+	c := <-confirms
+	assert.True(c.Ack)
+	assert.Equal(uint64(1), c.DeliveryTag)
+	c = <-confirms
+	assert.True(c.Ack)
+	assert.Equal(uint64(2), c.DeliveryTag)
+}
+
+func (s *ServerUnitSuite) TestMaxLength() {
+	assert := s.Assert()
+
+	_, err := s.ch.QueueDeclare("dropping", false, false, false, false, amqp.Table{"x-max-length": int32(1)})
+	s.Require().NoError(err)
+	_, err = s.ch.QueueDeclare("rejecting", false, false, false, false, amqp.Table{
+		"x-max-length": int32(1),
+		"x-overflow":   "reject-publish",
+	})
+	s.Require().NoError(err)
+
+	assert.NoError(s.ch.Confirm(false))
+	confirms := s.ch.NotifyPublish(make(chan amqp.Confirmation, 4))
+
+	for _, body := range []string{"first", "second"} {
+		assert.NoError(s.ch.Publish("", "dropping", false, false, amqp.Publishing{Body: []byte(body)}))
+		assert.True((<-confirms).Ack)
+	}
+	d, ok, err := s.ch.Get("dropping", true)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal("second", string(d.Body))
+
+	assert.NoError(s.ch.Publish("", "rejecting", false, false, amqp.Publishing{Body: []byte("first")}))
+	assert.True((<-confirms).Ack)
+	assert.NoError(s.ch.Publish("", "rejecting", false, false, amqp.Publishing{Body: []byte("second")}))
+	assert.False((<-confirms).Ack)
+	n, _ := s.srv.QueueLength("rejecting")
+	assert.Equal(1, n)
+}
+
+func (s *ServerUnitSuite) TestMessageTTLDeadLetters() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+
+	_, err := s.ch.QueueDeclare("delay", false, false, false, false, amqp.Table{
+		"x-message-ttl":             int64(50),
+		"x-dead-letter-exchange":    "exchange",
+		"x-dead-letter-routing-key": "some.key",
+	})
+	s.Require().NoError(err)
+
+	assert.NoError(s.ch.Publish("", "delay", false, false, amqp.Publishing{Body: []byte("body")}))
+	_, err = s.ch.QueueDeclarePassive("delay", false, false, false, false, nil)
+	s.Require().NoError(err)
+	n, _ := s.srv.QueueLength("delay")
+	assert.Equal(1, n)
+
+	deliveries, err := s.ch.Consume("queue", "", true, false, false, false, nil)
+	s.Require().NoError(err)
+	d := s.receive(deliveries)
+	assert.Equal("body", string(d.Body))
+	assert.Equal("some.key", d.RoutingKey)
+	deaths, ok := d.Headers["x-death"].([]interface{})
+	s.Require().True(ok)
+	assert.Equal("delay", deaths[0].(amqp.Table)["queue"])
+	assert.Equal("expired", deaths[0].(amqp.Table)["reason"])
+
+	n, _ = s.srv.QueueLength("delay")
+	assert.Equal(0, n)
+}
+
+func (s *ServerUnitSuite) TestPriorityAndExpiration() {
+	assert := s.Assert()
+
+	_, err := s.ch.QueueDeclare("queue", false, false, false, false, amqp.Table{"x-max-priority": int32(5)})
+	s.Require().NoError(err)
+
+	for _, p := range []amqp.Publishing{
+		{Body: []byte("low"), Priority: 1},
+		{Body: []byte("expired"), Priority: 9, Expiration: "10"},
+		{Body: []byte("capped"), Priority: 9},
+		{Body: []byte("high"), Priority: 5},
+		{Body: []byte("none")},
+	} {
+		assert.NoError(s.ch.Publish("", "queue", false, false, p))
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	for _, body := range []string{"capped", "high", "low", "none"} {
+		d, ok, err := s.ch.Get("queue", true)
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal(body, string(d.Body))
+	}
+}
+
+func (s *ServerUnitSuite) TestConsumerPriority() {
+	assert := s.Assert()
+
+	_, err := s.ch.QueueDeclare("queue", false, false, false, false, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(s.ch.Qos(2, 0, false))
+
+	low, err := s.ch.Consume("queue", "low", false, false, false, false, nil)
+	s.Require().NoError(err)
+	high, err := s.ch.Consume("queue", "high", false, false, false, false, amqp.Table{"x-priority": int32(10)})
+	s.Require().NoError(err)
+
+	for _, body := range []string{"a", "b", "c"} {
+		s.Require().NoError(s.ch.Publish("", "queue", false, false, amqp.Publishing{Body: []byte(body)}))
+	}
+
+	// the low priority consumer only gets what the high one has no room for
+	assert.Equal("a", string(s.receive(high).Body))
+	assert.Equal("b", string(s.receive(high).Body))
+	assert.Equal("c", string(s.receive(low).Body))
+}
+
+func (s *ServerUnitSuite) TestQuorumDeliveryCount() {
+	assert := s.Assert()
+
+	_, err := s.ch.QueueDeclare("dead", false, false, false, false, nil)
+	s.Require().NoError(err)
+	_, err = s.ch.QueueDeclare("quorum", true, false, false, false, amqp.Table{
+		"x-queue-type":              "quorum",
+		"x-delivery-limit":          int64(1),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": "dead",
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s.ch.Publish("", "quorum", false, false, amqp.Publishing{Body: []byte("poison")}))
+
+	deliveries, err := s.ch.Consume("quorum", "", false, false, false, false, nil)
+	s.Require().NoError(err)
+
+	d := s.receive(deliveries)
+	_, ok := d.Headers["x-delivery-count"]
+	assert.False(ok)
+	s.Require().NoError(d.Nack(false, true))
+
+	d = s.receive(deliveries)
+	assert.Equal(int64(1), d.Headers["x-delivery-count"])
+	s.Require().NoError(d.Nack(false, true))
+
+	// returned more than the limit
+	_, err = s.ch.QueueDeclarePassive("dead", false, false, false, false, nil)
+	s.Require().NoError(err)
+	n, _ := s.srv.QueueLength("dead")
+	assert.Equal(1, n)
+	n, _ = s.srv.QueueLength("quorum")
+	assert.Equal(0, n)
+}
+
+func (s *ServerUnitSuite) TestQuorumQueueIsDurable() {
+	_, err := s.ch.QueueDeclare("quorum", false, false, false, false, amqp.Table{"x-queue-type": "quorum"})
+	s.Require().Error(err)
+	s.Assert().Equal(amqp.PreconditionFailed, err.(*amqp.Error).Code)
+}
+
+func (s *ServerUnitSuite) TestStreamOffsets() {
+	assert := s.Assert()
+
+	_, err := s.ch.QueueDeclare("stream", true, false, false, false, amqp.Table{"x-queue-type": "stream"})
+	s.Require().NoError(err)
+	s.Require().NoError(s.ch.Qos(10, 0, false))
+	for _, body := range []string{"a", "b", "c"} {
+		s.Require().NoError(s.ch.Publish("", "stream", false, false, amqp.Publishing{Body: []byte(body)}))
+	}
+	_, err = s.ch.QueueDeclarePassive("stream", true, false, false, false, nil)
+	s.Require().NoError(err)
+
+	for _, c := range []struct {
+		offset interface{}
+		bodies []string
+	}{
+		{"first", []string{"a", "b", "c"}},
+		{"last", []string{"c"}},
+		{int64(1), []string{"b", "c"}},
+		{time.Now().Add(-time.Hour), []string{"a", "b", "c"}},
+	} {
+		deliveries, err := s.ch.Consume("stream", "", false, false, false, false, amqp.Table{"x-stream-offset": c.offset})
+		s.Require().NoError(err)
+		for _, body := range c.bodies {
+			d := s.receive(deliveries)
+			assert.Equal(body, string(d.Body))
+			assert.NoError(d.Ack(false))
+		}
+	}
+
+	// the messages stay in the stream, a consumer starts after them by default
+	n, _ := s.srv.QueueLength("stream")
+	assert.Equal(3, n)
+	deliveries, err := s.ch.Consume("stream", "", false, false, false, false, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(s.ch.Publish("", "stream", false, false, amqp.Publishing{Body: []byte("d")}))
+	d := s.receive(deliveries)
+	assert.Equal("d", string(d.Body))
+	assert.Equal(int64(3), d.Headers["x-stream-offset"])
+}
+
+func (s *ServerUnitSuite) TestStreamRequiresPrefetch() {
+	_, err := s.ch.QueueDeclare("stream", true, false, false, false, amqp.Table{"x-queue-type": "stream"})
+	s.Require().NoError(err)
+
+	_, err = s.ch.Consume("stream", "", true, false, false, false, nil)
+	s.Require().Error(err)
+	s.Assert().Equal(amqp.PreconditionFailed, err.(*amqp.Error).Code)
+}
+
+func (s *ServerUnitSuite) TestExchangeToExchangeBinding() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+
+	s.Require().NoError(s.ch.ExchangeDeclare("source", "direct", false, false, false, false, nil))
+	s.Require().NoError(s.ch.ExchangeBind("exchange", "key", "source", false, nil))
+
+	assert.NoError(s.ch.Publish("source", "key", false, false, amqp.Publishing{}))
+	assert.NoError(s.ch.Publish("source", "other", false, false, amqp.Publishing{}))
+	_, err := s.ch.QueueDeclarePassive("queue", true, false, false, false, nil)
+	s.Require().NoError(err)
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(1, n)
+
+	s.Require().NoError(s.ch.ExchangeUnbind("exchange", "key", "source", false, nil))
+	assert.NoError(s.ch.Publish("source", "key", false, false, amqp.Publishing{}))
+	_, err = s.ch.QueueDeclarePassive("queue", true, false, false, false, nil)
+	s.Require().NoError(err)
+	n, _ = s.srv.QueueLength("queue")
+	assert.Equal(1, n)
+}
+
+func (s *ServerUnitSuite) TestDelayedMessageExchange() {
+	assert := s.Assert()
+
+	srv := NewServer(SetServerDelayedMessages(true))
+	defer srv.Close()
+	conn, err := amqp.Dial(srv.URL)
+	s.Require().NoError(err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	s.Require().NoError(err)
+
+	s.Require().NoError(ch.ExchangeDeclare("delayed", "x-delayed-message", false, false, false, false, amqp.Table{"x-delayed-type": "topic"}))
+	_, err = ch.QueueDeclare("queue", false, false, false, false, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(ch.QueueBind("queue", "#", "delayed", false, nil))
+
+	assert.NoError(ch.Publish("delayed", "some.key", false, false, amqp.Publishing{
+		Headers: amqp.Table{"x-delay": int32(50)},
+	}))
+	_, err = ch.QueueDeclarePassive("queue", false, false, false, false, nil)
+	s.Require().NoError(err)
+	n, _ := srv.QueueLength("queue")
+	assert.Equal(0, n)
+
+	time.Sleep(time.Millisecond * 100)
+	n, _ = srv.QueueLength("queue")
+	assert.Equal(1, n)
+}
+
+func (s *ServerUnitSuite) TestDelayedMessageExchangeWithoutPlugin() {
+	assert := s.Assert()
+
+	closes := s.conn.NotifyClose(make(chan *amqp.Error, 1))
+	err := s.ch.ExchangeDeclare("delayed", "x-delayed-message", false, false, false, false, nil)
+	assert.Error(err)
+	assert.Equal(amqp.CommandInvalid, (<-closes).Code)
+}
+
+func (s *ServerUnitSuite) TestMandatoryReturn() {
+	assert := s.Assert()
+
+	assert.NoError(s.ch.Confirm(false))
+	returns := s.ch.NotifyReturn(make(chan amqp.Return, 1))
+	confirms := s.ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	assert.NoError(s.ch.Publish("amq.direct", "nowhere", true, false, amqp.Publishing{Body: []byte("body")}))
+
+	r := <-returns
+	assert.Equal(uint16(amqp.NoRoute), r.ReplyCode)
+	assert.Equal("body", string(r.Body))
+	assert.True((<-confirms).Ack)
+}
+
+func (s *ServerUnitSuite) TestPublishToUnknownExchangeClosesChannel() {
+	assert := s.Assert()
+
+	closed := s.ch.NotifyClose(make(chan *amqp.Error, 1))
+	assert.NoError(s.ch.Publish("unknown", "", false, false, amqp.Publishing{}))
+
+	select {
+	case err := <-closed:
+		assert.Equal(amqp.NotFound, err.Code)
+	case <-time.After(time.Second):
+		s.Fail("channel was not closed")
+	}
+}
+
+func (s *ServerUnitSuite) TestGetAndQueueInspect() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(s.ch.Publish("exchange", "", false, false, amqp.Publishing{}))
+	}
+
+	q, err := s.ch.QueueInspect("queue")
+	assert.NoError(err)
+	assert.Equal(3, q.Messages)
+
+	d, ok, err := s.ch.Get("queue", false)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(2, int(d.MessageCount))
+	assert.NoError(d.Reject(true))
+
+	n, err := s.ch.QueuePurge("queue", false)
+	assert.NoError(err)
+	assert.Equal(3, n)
+
+	_, ok, err = s.ch.Get("queue", false)
+	assert.NoError(err)
+	assert.False(ok)
+}
+
+func (s *ServerUnitSuite) TestDeleteQueueCancelsConsumers() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+
+	cancels := s.ch.NotifyCancel(make(chan string, 1))
+	deliveries, err := s.ch.Consume("queue", "consumer", false, false, false, false, nil)
+	assert.NoError(err)
+
+	assert.True(s.srv.DeleteQueue("queue"))
+
+	select {
+	case tag := <-cancels:
+		assert.Equal("consumer", tag)
+	case <-time.After(time.Second):
+		s.Fail("consumer was not cancelled")
+	}
+
+	_, ok := <-deliveries
+	assert.False(ok)
+}
+
+func (s *ServerUnitSuite) TestChannelCloseRequeuesUnacked() {
+	assert := s.Assert()
+	s.declareTopic("exchange", "queue")
+
+	assert.NoError(s.ch.Publish("exchange", "", false, false, amqp.Publishing{}))
+	deliveries, err := s.ch.Consume("queue", "", false, false, false, false, nil)
+	assert.NoError(err)
+	s.receive(deliveries)
+	assert.NoError(s.ch.Close())
+
+	n, ok := s.srv.QueueLength("queue")
+	assert.True(ok)
+	assert.Equal(1, n)
+}
+
+func (s *ServerUnitSuite) TestCloseConnections() {
+	assert := s.Assert()
+
+	closed := s.conn.NotifyClose(make(chan *amqp.Error, 1))
+	s.srv.CloseConnections()
+
+	select {
+	case err := <-closed:
+		assert.Equal(amqp.ConnectionForced, err.Code)
+	case <-time.After(time.Second):
+		s.Fail("connection was not closed")
+	}
+}
+
+func (s *ServerUnitSuite) TestBlock() {
+	assert := s.Assert()
+
+	blocks := s.conn.NotifyBlocked(make(chan amqp.Blocking, 2))
+	s.srv.Block("low on memory")
+	s.srv.Unblock()
+
+	for _, expected := range []amqp.Blocking{{Active: true, Reason: "low on memory"}, {Active: false}} {
+		select {
+		case b := <-blocks:
+			assert.Equal(expected, b)
+		case <-time.After(time.Second):
+			s.FailNow("no blocking notified")
+		}
+	}
+}
+
+func (s *ServerUnitSuite) TestExclusiveQueueIsDeletedWithConnection() {
+	assert := s.Assert()
+
+	q, err := s.ch.QueueDeclare("", false, false, true, false, nil)
+	assert.NoError(err)
+	assert.NotEmpty(q.Name)
+
+	assert.NoError(s.conn.Close())
+	waitFor(func() bool {
+		_, ok := s.srv.QueueLength(q.Name)
+		return !ok
+	})
+
+	_, ok := s.srv.QueueLength(q.Name)
+	assert.False(ok)
+}
+
+func waitFor(check func() bool) {
+	end := time.Now().Add(time.Second)
+	for !check() && time.Now().Before(end) {
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestServerUnitSuite(t *testing.T) {
+	suite.Run(t, new(ServerUnitSuite))
+}
@@ -0,0 +1,233 @@
+package amqp
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// ArchiveFormat is the encoding of an archive.
+type ArchiveFormat int
+
+const (
+	// ArchiveJSON writes one json object per line, it is easy to inspect and
+	// edit but header values come back as strings, numbers, booleans, arrays
+	// and tables only.
+	ArchiveJSON ArchiveFormat = iota
+	// ArchiveBinary writes a gob stream that keeps the exact header types.
+	ArchiveBinary
+)
+
+func init() {
+	gob.Register(amqp.Table{})
+	gob.Register([]interface{}{})
+	gob.Register(amqp.Decimal{})
+	gob.Register(time.Time{})
+}
+
+// ArchivedMessage is a message with the properties it was delivered with.
+type ArchivedMessage struct {
+	Exchange    string `json:"exchange"`
+	RoutingKey  string `json:"routing_key"`
+	Redelivered bool   `json:"redelivered,omitempty"`
+
+	Headers         amqp.Table `json:"headers,omitempty"`
+	ContentType     string     `json:"content_type,omitempty"`
+	ContentEncoding string     `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8      `json:"delivery_mode,omitempty"`
+	Priority        uint8      `json:"priority,omitempty"`
+	CorrelationId   string     `json:"correlation_id,omitempty"`
+	ReplyTo         string     `json:"reply_to,omitempty"`
+	Expiration      string     `json:"expiration,omitempty"`
+	MessageId       string     `json:"message_id,omitempty"`
+	Timestamp       time.Time  `json:"timestamp,omitempty"`
+	Type            string     `json:"type,omitempty"`
+	UserId          string     `json:"user_id,omitempty"`
+	AppId           string     `json:"app_id,omitempty"`
+
+	Body []byte `json:"body"`
+}
+
+func archived(d amqp.Delivery) *ArchivedMessage {
+	return &ArchivedMessage{
+		Exchange:        d.Exchange,
+		RoutingKey:      d.RoutingKey,
+		Redelivered:     d.Redelivered,
+		Headers:         d.Headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+// Publishing returns the message ready to be published again.
+func (m *ArchivedMessage) Publishing() amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         m.Headers,
+		ContentType:     m.ContentType,
+		ContentEncoding: m.ContentEncoding,
+		DeliveryMode:    m.DeliveryMode,
+		Priority:        m.Priority,
+		CorrelationId:   m.CorrelationId,
+		ReplyTo:         m.ReplyTo,
+		Expiration:      m.Expiration,
+		MessageId:       m.MessageId,
+		Timestamp:       m.Timestamp,
+		Type:            m.Type,
+		UserId:          m.UserId,
+		AppId:           m.AppId,
+		Body:            m.Body,
+	}
+}
+
+// ArchiveWriter writes messages to an archive.
+type ArchiveWriter struct {
+	w   *bufio.Writer
+	enc interface{ Encode(interface{}) error }
+}
+
+func NewArchiveWriter(w io.Writer, format ArchiveFormat) *ArchiveWriter {
+	bw := bufio.NewWriter(w)
+	if format == ArchiveBinary {
+		return &ArchiveWriter{w: bw, enc: gob.NewEncoder(bw)}
+	}
+	return &ArchiveWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (a *ArchiveWriter) Write(m *ArchivedMessage) error {
+	if err := a.enc.Encode(m); err != nil {
+		return errors.Wrap(err, "Could not encode archived message")
+	}
+	return nil
+}
+
+// Flush writes the buffered messages to the underlying writer.
+func (a *ArchiveWriter) Flush() error {
+	return a.w.Flush()
+}
+
+// ArchiveReader reads the messages of an archive.
+type ArchiveReader struct {
+	format ArchiveFormat
+	dec    interface{ Decode(interface{}) error }
+}
+
+func NewArchiveReader(r io.Reader, format ArchiveFormat) *ArchiveReader {
+	br := bufio.NewReader(r)
+	if format == ArchiveBinary {
+		return &ArchiveReader{format: format, dec: gob.NewDecoder(br)}
+	}
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	return &ArchiveReader{format: format, dec: dec}
+}
+
+// Read returns the next message of the archive, or io.EOF when there is none
+// left.
+func (a *ArchiveReader) Read() (*ArchivedMessage, error) {
+	m := &ArchivedMessage{}
+	if err := a.dec.Decode(m); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "Could not decode archived message")
+	}
+
+	if a.format == ArchiveJSON && m.Headers != nil {
+		m.Headers = fromJSON(m.Headers).(amqp.Table)
+	}
+	return m, nil
+}
+
+// fromJSON turns decoded json values back into types an amqp.Table accepts.
+func fromJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case amqp.Table:
+		for k, item := range v {
+			v[k] = fromJSON(item)
+		}
+		return v
+	case map[string]interface{}:
+		return fromJSON(amqp.Table(v))
+	case []interface{}:
+		for i, item := range v {
+			v[i] = fromJSON(item)
+		}
+		return v
+	}
+	return v
+}
+
+// confirmer publishes messages one at a time and waits for the server to
+// confirm each of them. It runs on a channel of its own, so its listeners only
+// see its own confirmations, and close releases them.
+type confirmer struct {
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+func newConfirmer(conn *Connection) (*confirmer, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not conn.Channel()")
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, errors.Wrap(err, "Could not channel.Confirm()")
+	}
+	return &confirmer{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+func (c *confirmer) close() error {
+	return c.ch.Close()
+}
+
+// publish returns an error unless the message was routed and confirmed.
+func (c *confirmer) publish(exchange, key string, msg amqp.Publishing) error {
+	if err := c.ch.Publish(exchange, key, true, false, msg); err != nil {
+		return errors.Wrap(err, "Could not Publish, channel.Publish() failed")
+	}
+
+	confirm, ok := <-c.confirms
+	if !ok {
+		return errors.New("Could not Publish, channel closed before the confirmation")
+	}
+
+	// the server sends the return before the confirmation
+	select {
+	case r := <-c.returns:
+		return errors.Errorf("Could not Publish, message returned: %s", r.ReplyText)
+	default:
+	}
+
+	if !confirm.Ack {
+		return errors.New("Could not Publish, message not confirmed")
+	}
+	return nil
+}
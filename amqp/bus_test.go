@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/amqp/amqptest"
 	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/suite"
 )
@@ -210,3 +212,102 @@ func (s *BusIntegrationSuite) TestShutdownWhenGraced() {
 func TestBusIntegrationSuite(t *testing.T) {
 	suite.Run(t, new(BusIntegrationSuite))
 }
+
+type BusUnitSuite struct {
+	suite.Suite
+	srv *amqptest.Server
+	bus Bus
+}
+
+func (s *BusUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+	s.bus = MustBus(SetBusDSN(s.srv.URL))
+	declareTopic(s.srv.URL, "exchange", "queue")
+
+	pub := s.bus.MustPublisher(SetPublisherExchange("exchange"))
+	for i := 0; i < 3; i++ {
+		err, ok := pub.Publish(&Message{})
+		s.Require().NoError(err)
+		s.Require().True(ok)
+	}
+}
+
+func (s *BusUnitSuite) TearDownTest() {
+	s.srv.Close()
+}
+
+func (s *BusUnitSuite) consume() base.Message {
+	sub := s.bus.MustSubscriber(
+		SetSubscriberQueue("queue"),
+		SetSubscriberPrefetchCount(3),
+	)
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+
+	msg := <-msgs
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("queue")
+		return n == 0
+	}, time.Second)
+	return msg
+}
+
+func (s *BusUnitSuite) TestShutdownDrainsSubscribers() {
+	assert := s.Assert()
+	msg := s.consume()
+
+	acked := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		acked <- msg.Ack(false)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(s.bus.Shutdown(ctx))
+	assert.NoError(<-acked)
+
+	// the prefetched messages nobody received are back in the queue
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(2, n)
+	n, _ = s.srv.Consumers("queue")
+	assert.Equal(0, n)
+}
+
+func (s *BusUnitSuite) TestShutdownWhenHandlerTimedOut() {
+	assert := s.Assert()
+	s.consume()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Error(s.bus.Shutdown(ctx))
+
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("queue")
+		return n == 3
+	}, time.Second)
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(3, n)
+}
+
+func (s *BusUnitSuite) TestPublishAfterShutdown() {
+	assert := s.Assert()
+
+	pub := s.bus.MustPublisher(SetPublisherExchange("exchange"))
+	pooled := s.bus.MustPooledPublisher(SetPublisherExchange("exchange"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(s.bus.Shutdown(ctx))
+
+	for _, p := range []Publisher{pub, pooled} {
+		err, ok := p.Publish(&Message{})
+		assert.Equal(ErrBusClosed, err)
+		assert.False(ok)
+	}
+	assert.True(s.bus.(*bus).targets[""].pubconns[0].IsClosed())
+}
+
+func TestBusUnitSuite(t *testing.T) {
+	suite.Run(t, new(BusUnitSuite))
+}
@@ -53,13 +53,19 @@ func SetChannelDone(done <-chan struct{}) ChannelOptionsFn {
 }
 
 type Channel struct {
-	*amqp.Channel
 	*ChannelOptions
 
+	mu          sync.Mutex
+	chnn        *amqp.Channel
 	reconnected []chan<- bool
-
-	conn   *Connection
-	closed int32
+	canceled    map[string]bool
+	listeners   map[string]chan string
+	onCancel    map[string]func() error
+
+	conn    *Connection
+	closed  int32
+	closes  chan *amqp.Error
+	cancels chan string
 }
 
 func MustChannel(conn *Connection, fns ...ChannelOptionsFn) *Channel {
@@ -85,6 +91,9 @@ func NewChannel(conn *Connection, fns ...ChannelOptionsFn) (*Channel, error) {
 		ChannelOptions: o,
 		conn:           conn,
 		reconnected:    make([]chan<- bool, 0),
+		canceled:       make(map[string]bool),
+		listeners:      make(map[string]chan string),
+		onCancel:       make(map[string]func() error),
 	}
 
 	err := chnn.channel()
@@ -99,22 +108,47 @@ func NewChannel(conn *Connection, fns ...ChannelOptionsFn) (*Channel, error) {
 }
 
 func (c *Channel) Reconnected(listener chan<- bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.reconnected = append(c.reconnected, listener)
 }
 
+// Current returns the amqp channel currently open, it is replaced each time
+// the channel reconnects so it should not be kept.
+func (c *Channel) Current() *amqp.Channel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.chnn
+}
+
+// Consume consumes the queue again each time the channel reconnects, until the
+// consumer is canceled or the channel done. When the server cancels the
+// consumer, as it does when the queue is deleted, it consumes the queue again
+// once the OnCancel hook of the consumer succeeded and stops once it failed.
 func (c *Channel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
 	deliveries := make(chan amqp.Delivery)
+	canceled := make(chan string, 1)
+
+	c.mu.Lock()
+	c.listeners[consumer] = canceled
+	delete(c.canceled, consumer)
+	c.mu.Unlock()
 
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
+		defer close(deliveries)
+		defer c.forget(consumer)
 	out:
 		for {
 			select {
 			case <-c.done:
 				break out
 			default:
-				d, err := c.Channel.Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, args)
+				if c.isCanceled(consumer) {
+					break out
+				}
+				d, err := c.Current().Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, args)
 				if err != nil {
 					log.Printf("consume failed, err: %v\n", err)
 					time.Sleep(c.delay)
@@ -122,13 +156,29 @@ func (c *Channel) Consume(queue, consumer string, autoAck, exclusive, noLocal, n
 				}
 
 				for msg := range d {
-					deliveries <- msg
+					select {
+					case deliveries <- msg:
+					case <-c.done:
+						break out
+					}
 				}
 
-				time.Sleep(c.delay)
+				if c.isCanceled(consumer) {
+					break out
+				}
 
-				if c.IsClosed() {
-					break
+				// the server notifies the cancel before it closes the
+				// deliveries, the channel closes otherwise
+				select {
+				case <-c.done:
+					break out
+				case <-canceled:
+					log.Printf("consumer canceled by server, consumer: %s\n", consumer)
+					if err := c.resubscribe(consumer); err != nil {
+						log.Printf("resubscribe failed, err: %v\n", err)
+						break out
+					}
+				case <-time.After(c.delay):
 				}
 			}
 		}
@@ -137,8 +187,79 @@ func (c *Channel) Consume(queue, consumer string, autoAck, exclusive, noLocal, n
 	return deliveries, nil
 }
 
+// OnCancel sets the hook run when the server cancels the consumer with the
+// tag, before Consume consumes the queue again. Consume gives up once the hook
+// returns an error.
+func (c *Channel) OnCancel(consumer string, fn func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onCancel[consumer] = fn
+}
+
+func (c *Channel) resubscribe(consumer string) error {
+	c.mu.Lock()
+	fn := c.onCancel[consumer]
+	c.mu.Unlock()
+
+	if fn == nil {
+		time.Sleep(c.delay)
+		return nil
+	}
+	return fn()
+}
+
+func (c *Channel) notifyCancel(consumer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case c.listeners[consumer] <- consumer:
+	default:
+	}
+}
+
+func (c *Channel) forget(consumer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.listeners, consumer)
+	delete(c.onCancel, consumer)
+}
+
+// Cancel stops the consumer with the tag, Consume does not consume the queue
+// again for it until called with the tag again.
+func (c *Channel) Cancel(consumer string, noWait bool) error {
+	c.mu.Lock()
+	c.canceled[consumer] = true
+	c.mu.Unlock()
+
+	return c.Current().Cancel(consumer, noWait)
+}
+
+func (c *Channel) isCanceled(consumer string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.canceled[consumer]
+}
+
+// SetPrefetch sets the prefetch of the consumers the channel starts from now
+// on, the channel keeps it when it reconnects.
+func (c *Channel) SetPrefetch(count, size int) error {
+	if err := c.Current().Qos(count, size, false); err != nil {
+		return errors.Wrap(err, "Could not channel.Qos()")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefetchCount = count
+	c.prefetchSize = size
+	return nil
+}
+
 func (c *Channel) notifyReconnection() {
-	for _, r := range c.reconnected {
+	c.mu.Lock()
+	reconnected := append([]chan<- bool(nil), c.reconnected...)
+	c.mu.Unlock()
+
+	for _, r := range reconnected {
 		r <- true
 	}
 }
@@ -152,7 +273,49 @@ func (c *Channel) Close() error {
 		return amqp.ErrClosed
 	}
 	atomic.StoreInt32(&c.closed, 1)
-	return c.Channel.Close()
+	return c.Current().Close()
+}
+
+// The methods below run on the amqp channel currently open, see Current.
+
+func (c *Channel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return c.Current().Publish(exchange, key, mandatory, immediate, msg)
+}
+
+func (c *Channel) Confirm(noWait bool) error {
+	return c.Current().Confirm(noWait)
+}
+
+func (c *Channel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	return c.Current().NotifyPublish(confirm)
+}
+
+func (c *Channel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	return c.Current().Get(queue, autoAck)
+}
+
+func (c *Channel) Ack(tag uint64, multiple bool) error {
+	return c.Current().Ack(tag, multiple)
+}
+
+func (c *Channel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return c.Current().ExchangeDeclare(name, kind, durable, autoDelete, internal, noWait, args)
+}
+
+func (c *Channel) ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error {
+	return c.Current().ExchangeBind(destination, key, source, noWait, args)
+}
+
+func (c *Channel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	return c.Current().QueueDeclare(name, durable, autoDelete, exclusive, noWait, args)
+}
+
+func (c *Channel) QueueInspect(name string) (amqp.Queue, error) {
+	return c.Current().QueueInspect(name)
+}
+
+func (c *Channel) QueuePurge(name string, noWait bool) (int, error) {
+	return c.Current().QueuePurge(name, noWait)
 }
 
 func (c *Channel) channel() error {
@@ -160,12 +323,19 @@ func (c *Channel) channel() error {
 	if err != nil {
 		return errors.Wrap(err, "Could not conn.Channel()")
 	}
-	err = chnn.Qos(c.prefetchCount, c.prefetchSize, false)
+	c.mu.Lock()
+	count, size := c.prefetchCount, c.prefetchSize
+	c.mu.Unlock()
+	err = chnn.Qos(count, size, false)
 	if err != nil {
 		return err
 	}
 
-	c.Channel = chnn
+	c.mu.Lock()
+	c.chnn = chnn
+	c.mu.Unlock()
+	c.closes = chnn.NotifyClose(make(chan *amqp.Error, 1))
+	c.cancels = chnn.NotifyCancel(make(chan string, 1))
 	return nil
 }
 
@@ -179,7 +349,14 @@ out:
 		case <-c.done:
 			c.Close()
 
-		case reason, ok := <-c.Channel.NotifyClose(make(chan *amqp.Error)):
+		case consumer, ok := <-c.cancels:
+			if !ok {
+				c.cancels = nil
+				continue
+			}
+			c.notifyCancel(consumer)
+
+		case reason, ok := <-c.closes:
 			if !ok {
 				log.Println("channel closed")
 				atomic.StoreInt32(&c.closed, 1)
@@ -190,7 +367,11 @@ out:
 			atomic.StoreInt32(&c.closed, 1)
 
 			for {
-				time.Sleep(c.delay)
+				select {
+				case <-c.done:
+					break out
+				case <-time.After(c.delay):
+				}
 
 				err := c.channel()
 				if err == nil {
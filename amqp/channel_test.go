@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/movidesk/go-bus/amqp/amqptest"
 	toxi "github.com/shopify/toxiproxy/client"
 	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/suite"
@@ -268,3 +269,133 @@ func (s *ChannelIntegrationSuite) TestChannelConsumeOnNetworkFailure() {
 func TestChannelIntegrationSuite(t *testing.T) {
 	suite.Run(t, new(ChannelIntegrationSuite))
 }
+
+type ChannelUnitSuite struct {
+	suite.Suite
+	srv *amqptest.Server
+}
+
+func (s *ChannelUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+}
+
+func (s *ChannelUnitSuite) TearDownTest() {
+	s.srv.Close()
+}
+
+func (s *ChannelUnitSuite) TestChannelOnForcedClose() {
+	assert := s.Assert()
+
+	done := make(chan struct{})
+	defer close(done)
+	conn, err := NewConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDelay(time.Millisecond*100),
+		SetConnectionDone(done),
+	)
+	assert.NoError(err)
+
+	chnn, err := NewChannel(conn,
+		SetChannelDelay(time.Millisecond*100),
+		SetChannelDone(done),
+	)
+	assert.NoError(err)
+
+	reconnected := make(chan bool, 1)
+	chnn.Reconnected(reconnected)
+
+	s.srv.CloseConnections()
+	waitToBeTrue(func() bool { return chnn.IsClosed() }, time.Second)
+	assert.True(chnn.IsClosed())
+
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second * 2):
+		s.Fail("channel was not reconnected")
+	}
+	assert.False(chnn.IsClosed())
+}
+
+func (s *ChannelUnitSuite) TestChannelConsumeOnForcedClose() {
+	assert := s.Assert()
+
+	declareTopic(s.srv.URL, "exchange", "queue")
+
+	done := make(chan struct{})
+	defer close(done)
+	conn, err := NewConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDelay(time.Millisecond*100),
+		SetConnectionDone(done),
+	)
+	assert.NoError(err)
+
+	chnn, err := NewChannel(conn,
+		SetChannelDelay(time.Millisecond*100),
+		SetChannelDone(done),
+	)
+	assert.NoError(err)
+
+	deliveries, err := chnn.Consume("queue", "", false, false, false, false, nil)
+	assert.NoError(err)
+
+	err = chnn.Publish("exchange", "", false, false, amqp.Publishing{Body: []byte("body 1")})
+	assert.NoError(err)
+	msg := <-deliveries
+	assert.Equal("body 1", string(msg.Body))
+
+	s.srv.CloseConnections()
+	waitToBeTrue(func() bool { return chnn.IsClosed() }, time.Second)
+	waitToBeTrue(func() bool { return !chnn.IsClosed() }, time.Second*2)
+	assert.False(chnn.IsClosed())
+
+	msg = <-deliveries
+	assert.True(msg.Redelivered)
+	assert.Equal("body 1", string(msg.Body))
+	assert.NoError(msg.Ack(false))
+}
+
+func (s *ChannelUnitSuite) TestChannelConsumeOnDrop() {
+	assert := s.Assert()
+
+	declareTopic(s.srv.URL, "exchange", "queue")
+
+	dialer := amqptest.NewDialer()
+	done := make(chan struct{})
+	defer close(done)
+	conn, err := NewConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDialer(dialer.Dial),
+		SetConnectionDelay(time.Millisecond*100),
+		SetConnectionDone(done),
+	)
+	assert.NoError(err)
+
+	chnn, err := NewChannel(conn,
+		SetChannelDelay(time.Millisecond*100),
+		SetChannelDone(done),
+	)
+	assert.NoError(err)
+
+	deliveries, err := chnn.Consume("queue", "", false, false, false, false, nil)
+	assert.NoError(err)
+
+	err = chnn.Publish("exchange", "", false, false, amqp.Publishing{Body: []byte("body 1")})
+	assert.NoError(err)
+	msg := <-deliveries
+	assert.Equal("body 1", string(msg.Body))
+
+	dialer.Drop()
+	waitToBeTrue(func() bool { return chnn.IsClosed() }, time.Second)
+	waitToBeTrue(func() bool { return !chnn.IsClosed() }, time.Second*2)
+	assert.False(chnn.IsClosed())
+
+	msg = <-deliveries
+	assert.True(msg.Redelivered)
+	assert.Equal("body 1", string(msg.Body))
+	assert.NoError(msg.Ack(false))
+}
+
+func TestChannelUnitSuite(t *testing.T) {
+	suite.Run(t, new(ChannelUnitSuite))
+}
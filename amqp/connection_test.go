@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/movidesk/go-bus/amqp/amqptest"
 	toxi "github.com/shopify/toxiproxy/client"
 	"github.com/stretchr/testify/suite"
 )
@@ -178,3 +179,149 @@ func (s *ConnectionIntegrationSuite) TestConnectionWaitGroupOnDone() {
 func TestConnectionIntegrationSuite(t *testing.T) {
 	suite.Run(t, new(ConnectionIntegrationSuite))
 }
+
+type ConnectionUnitSuite struct {
+	suite.Suite
+	srv *amqptest.Server
+}
+
+func (s *ConnectionUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+}
+
+func (s *ConnectionUnitSuite) TearDownTest() {
+	s.srv.Close()
+}
+
+func (s *ConnectionUnitSuite) TestNewConnection() {
+	assert := s.Assert()
+
+	conn, err := NewConnection(SetConnectionDSN(s.srv.URL))
+	assert.NoError(err)
+	assert.NotNil(conn)
+	assert.False(conn.IsClosed())
+}
+
+func (s *ConnectionUnitSuite) TestConnectionOnForcedClose() {
+	assert := s.Assert()
+
+	done := make(chan struct{})
+	defer close(done)
+	conn, err := NewConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDelay(time.Millisecond*100),
+		SetConnectionDone(done),
+	)
+	assert.NoError(err)
+
+	s.srv.CloseConnections()
+	waitToBeTrue(func() bool { return conn.IsClosed() }, time.Second)
+	assert.True(conn.IsClosed())
+
+	waitToBeTrue(func() bool { return !conn.IsClosed() }, time.Second)
+	assert.False(conn.IsClosed())
+	assert.Equal(1, s.srv.Connections())
+}
+
+func (s *ConnectionUnitSuite) TestConnectionBlocked() {
+	assert := s.Assert()
+
+	done := make(chan struct{})
+	defer close(done)
+	conn, err := NewConnection(SetConnectionDSN(s.srv.URL), SetConnectionDone(done))
+	s.Require().NoError(err)
+	assert.False(conn.IsBlocked())
+
+	s.srv.Block("low on memory")
+	waitToBeTrue(conn.IsBlocked, time.Second)
+	assert.True(conn.IsBlocked())
+
+	s.srv.Unblock()
+	waitToBeTrue(func() bool { return !conn.IsBlocked() }, time.Second)
+	assert.False(conn.IsBlocked())
+}
+
+func (s *ConnectionUnitSuite) TestConnectionWaitGroupOnDone() {
+	assert := s.Assert()
+
+	wg := &sync.WaitGroup{}
+	done := make(chan struct{})
+	_, err := NewConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionWaitGroup(wg),
+		SetConnectionDone(done),
+	)
+	assert.NoError(err)
+
+	assert.True(waitForTimeout(wg.Wait, time.Millisecond*100))
+	close(done)
+	assert.False(waitForTimeout(wg.Wait, time.Second))
+}
+
+func (s *ConnectionUnitSuite) TestConnectionOnDrop() {
+	assert := s.Assert()
+
+	dialer := amqptest.NewDialer()
+	done := make(chan struct{})
+	defer close(done)
+	conn, err := NewConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDialer(dialer.Dial),
+		SetConnectionDelay(time.Millisecond*100),
+		SetConnectionDone(done),
+	)
+	assert.NoError(err)
+
+	dialer.Drop()
+	waitToBeTrue(func() bool { return conn.IsClosed() }, time.Second)
+	assert.True(conn.IsClosed())
+
+	waitToBeTrue(func() bool { return !conn.IsClosed() }, time.Second)
+	assert.False(conn.IsClosed())
+	assert.Equal(1, dialer.Conns())
+}
+
+func (s *ConnectionUnitSuite) TestConnectionOnDropWhileRefused() {
+	assert := s.Assert()
+
+	dialer := amqptest.NewDialer()
+	done := make(chan struct{})
+	defer close(done)
+	conn, err := NewConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDialer(dialer.Dial),
+		SetConnectionDelay(time.Millisecond*100),
+		SetConnectionDone(done),
+	)
+	assert.NoError(err)
+
+	dialer.Refuse(true)
+	dialer.Drop()
+	waitToBeTrue(func() bool { return conn.IsClosed() }, time.Second)
+	assert.True(conn.IsClosed())
+
+	time.Sleep(time.Millisecond * 500)
+	assert.True(conn.IsClosed())
+	assert.Equal(0, dialer.Conns())
+
+	dialer.Refuse(false)
+	waitToBeTrue(func() bool { return !conn.IsClosed() }, time.Second)
+	assert.False(conn.IsClosed())
+}
+
+func (s *ConnectionUnitSuite) TestNewConnectionWhileRefused() {
+	assert := s.Assert()
+
+	dialer := amqptest.NewDialer()
+	dialer.Refuse(true)
+	conn, err := NewConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDialer(dialer.Dial),
+	)
+	assert.Error(err)
+	assert.Nil(conn)
+}
+
+func TestConnectionUnitSuite(t *testing.T) {
+	suite.Run(t, new(ConnectionUnitSuite))
+}
@@ -0,0 +1,201 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"github.com/streadway/amqp"
+)
+
+// DelayMode selects how a publisher holds delayed messages back.
+type DelayMode int
+
+const (
+	// DelayAuto uses the delayed message plugin when the server has it and
+	// delay queues otherwise. Publishers to the default exchange always use
+	// delay queues.
+	DelayAuto DelayMode = iota
+	// DelayPlugin publishes with the x-delay header to an exchange of the
+	// rabbitmq-delayed-message-exchange plugin, named after the exchange of the
+	// publisher with a .delayed suffix and bound to it.
+	DelayPlugin
+	// DelayQueues publishes to a queue per delay whose messages expire after
+	// the delay and are dead-lettered to the exchange of the publisher. It
+	// rejects the messages that expire.
+	DelayQueues
+)
+
+// delayExpires is how long a delay queue outlives its last declaration, the
+// queue is declared again before half of it elapsed.
+const delayExpires = time.Minute
+
+// SetPublisherDelayMode sets how delayed messages are held back, DelayAuto by
+// default.
+func SetPublisherDelayMode(mode DelayMode) PublisherOptionsFn {
+	return func(o *PublisherOptions) {
+		o.delayMode = mode
+	}
+}
+
+// SetPublisherDelayResolution rounds the delays of delay queues up to a
+// multiple of the resolution, each distinct delay having a queue of its own.
+func SetPublisherDelayResolution(resolution time.Duration) PublisherOptionsFn {
+	return func(o *PublisherOptions) {
+		o.delayResolution = resolution
+	}
+}
+
+// PublishAfter publishes a message to be delivered once the delay elapsed, it
+// waits for the server to confirm it holds the message.
+func (p *pub) PublishAfter(msg base.Message, d time.Duration) (error, bool) {
+	if d <= 0 {
+		return p.Publish(msg)
+	}
+
+	if err := p.gate.enter(); err != nil {
+		return err, false
+	}
+	defer p.gate.leave()
+
+	p.mu.Lock()
+	tag, err := p.publishDelayed(msg, d)
+	if err != nil {
+		p.mu.Unlock()
+		return err, false
+	}
+
+	ok, err := waitConfirm(p.confirmations, tag, p.gate.abort)
+	if err == nil {
+		tag = 0
+	}
+	p.unlock(tag)
+	p.counters.record(err, ok)
+	return err, ok
+}
+
+// publishDelayed publishes the message through the delay exchange or a delay
+// queue, the lock is held.
+func (p *pub) publishDelayed(msg base.Message, d time.Duration) (uint64, error) {
+	mode, err := p.resolveDelay()
+	if err != nil {
+		return 0, err
+	}
+
+	if mode == DelayPlugin {
+		exchange, err := p.declareDelayExchange()
+		if err != nil {
+			return 0, err
+		}
+		publishing := publishing(msg)
+		headers := amqp.Table{}
+		for k, v := range publishing.Headers {
+			headers[k] = v
+		}
+		headers["x-delay"] = d.Milliseconds()
+		publishing.Headers = headers
+
+		// the plugin routes the message only once the delay elapsed, so it
+		// would return every mandatory message
+		return p.publishTo(exchange, p.key, false, publishing)
+	}
+
+	// the expiration would run in the delay queue and dead-lettering drops it,
+	// so it could not apply once the delay elapsed
+	publishing := publishing(msg)
+	if publishing.Expiration != "" {
+		return 0, errors.New("Could not delay message, delay queues cannot keep its expiration")
+	}
+
+	queue, err := p.declareDelayQueue(d)
+	if err != nil {
+		return 0, err
+	}
+	return p.publishTo("", queue, p.mandatory, publishing)
+}
+
+// PublishAt publishes a message to be delivered at the time.
+func (p *pub) PublishAt(msg base.Message, t time.Time) (error, bool) {
+	return p.PublishAfter(msg, time.Until(t))
+}
+
+// resolveDelay resolves DelayAuto by declaring a delayed message exchange on a
+// connection of its own, a server without the plugin closes the connection.
+func (p *pub) resolveDelay() (DelayMode, error) {
+	if p.delayed == DelayPlugin && p.exchange == "" {
+		return 0, errors.New("Could not delay message, the default exchange cannot be bound to a delayed exchange")
+	}
+	if p.delayed != DelayAuto {
+		return p.delayed, nil
+	}
+	if p.exchange == "" {
+		p.delayed = DelayQueues
+		return p.delayed, nil
+	}
+
+	name := "delay.probe." + uuid.NewV4().String()
+	err := p.Session.Connection.probe(func(ch *amqp.Channel) error {
+		err := ch.ExchangeDeclare(name, "x-delayed-message", false, true, false, false, amqp.Table{"x-delayed-type": "direct"})
+		if err != nil {
+			return err
+		}
+		return ch.ExchangeDelete(name, false, false)
+	})
+	if err == nil {
+		p.delayed = DelayPlugin
+		return p.delayed, nil
+	}
+	if aerr, ok := errors.Cause(err).(*amqp.Error); ok && aerr.Code == amqp.CommandInvalid {
+		p.delayed = DelayQueues
+		return p.delayed, nil
+	}
+	return 0, errors.Wrap(err, "Could not probe for the delayed message plugin")
+}
+
+func (p *pub) declareDelayExchange() (string, error) {
+	name := p.exchange + ".delayed"
+	if _, ok := p.delays[name]; ok {
+		return name, nil
+	}
+
+	err := p.Session.Channel.ExchangeDeclare(name, "x-delayed-message", true, false, false, false, amqp.Table{"x-delayed-type": "topic"})
+	if err != nil {
+		return "", errors.Wrap(err, "Could not declare delayed exchange")
+	}
+	err = p.Session.Channel.ExchangeBind(p.exchange, "#", name, false, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "Could not bind delayed exchange")
+	}
+
+	p.delays[name] = time.Now()
+	return name, nil
+}
+
+func (p *pub) declareDelayQueue(d time.Duration) (string, error) {
+	resolution := p.delayResolution
+	if resolution <= 0 {
+		resolution = time.Millisecond
+	}
+	d = (d + resolution - 1) / resolution * resolution
+	ttl := d.Milliseconds()
+
+	name := fmt.Sprintf("delay.%s.%s.%d", p.exchange, p.key, ttl)
+	if declared, ok := p.delays[name]; ok && time.Since(declared) < delayExpires/2 {
+		return name, nil
+	}
+
+	_, err := p.Session.Channel.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-message-ttl":             ttl,
+		"x-dead-letter-exchange":    p.exchange,
+		"x-dead-letter-routing-key": p.key,
+		"x-expires":                 ttl + delayExpires.Milliseconds(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "Could not declare delay queue")
+	}
+
+	p.delays[name] = time.Now()
+	return name, nil
+}
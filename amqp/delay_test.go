@@ -0,0 +1,130 @@
+package amqp
+
+import (
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/stretchr/testify/suite"
+)
+
+type DelayUnitSuite struct {
+	suite.Suite
+	srv  *amqptest.Server
+	done chan struct{}
+}
+
+func (s *DelayUnitSuite) TearDownTest() {
+	close(s.done)
+	s.srv.Close()
+}
+
+func (s *DelayUnitSuite) start(fns ...amqptest.ServerOptionsFn) base.DelayedPublisher {
+	s.srv = amqptest.NewServer(fns...)
+	declareTopic(s.srv.URL, "exchange", "queue")
+
+	s.done = make(chan struct{})
+	conn := MustConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDone(s.done),
+	)
+	sess := MustSession(conn, SetChannelDone(s.done))
+	pub := MustPublisher(sess,
+		SetPublisherExchange("exchange"),
+		SetPublisherDelayResolution(time.Millisecond*50),
+		SetPublisherClose(s.done),
+	)
+
+	delayed, ok := pub.(base.DelayedPublisher)
+	s.Require().True(ok)
+	return delayed
+}
+
+func (s *DelayUnitSuite) queued() int {
+	n, _ := s.srv.QueueLength("queue")
+	return n
+}
+
+func (s *DelayUnitSuite) TestPublishAfterWithDelayQueues() {
+	assert := s.Assert()
+	pub := s.start()
+
+	err, ok := pub.PublishAfter(&Message{Body: []byte("later")}, time.Millisecond*80)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(0, s.queued())
+
+	// the delay is rounded up to the resolution
+	n, ok := s.srv.QueueLength("delay.exchange..100")
+	assert.True(ok)
+	assert.Equal(1, n)
+
+	waitToBeTrue(func() bool { return s.queued() == 1 }, time.Second)
+	assert.Equal(1, s.queued())
+
+	// the probe for the plugin did not close the connection of the publisher
+	err, ok = pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func (s *DelayUnitSuite) TestDelayQueuesRejectExpiration() {
+	assert := s.Assert()
+	pub := s.start()
+
+	err, ok := pub.PublishAfter(&Message{Expiration: time.Millisecond * 50}, time.Millisecond*80)
+	assert.Error(err)
+	assert.False(ok)
+
+	_, ok = s.srv.QueueLength("delay.exchange..100")
+	assert.False(ok)
+}
+
+func (s *DelayUnitSuite) TestPublishAfterWithPlugin() {
+	assert := s.Assert()
+	pub := s.start(amqptest.SetServerDelayedMessages(true))
+
+	err, ok := pub.PublishAfter(&Message{Body: []byte("later")}, time.Millisecond*80)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(0, s.queued())
+
+	_, ok = s.srv.QueueLength("delay.exchange..100")
+	assert.False(ok)
+
+	waitToBeTrue(func() bool { return s.queued() == 1 }, time.Second)
+	assert.Equal(1, s.queued())
+}
+
+func (s *DelayUnitSuite) TestPublishAtInThePast() {
+	assert := s.Assert()
+	pub := s.start()
+
+	err, ok := pub.PublishAt(&Message{}, time.Now().Add(-time.Minute))
+	assert.NoError(err)
+	assert.True(ok)
+
+	waitToBeTrue(func() bool { return s.queued() == 1 }, time.Second)
+	assert.Equal(1, s.queued())
+}
+
+func (s *DelayUnitSuite) TestPluginOnDefaultExchange() {
+	assert := s.Assert()
+	s.start(amqptest.SetServerDelayedMessages(true))
+
+	conn := MustConnection(SetConnectionDSN(s.srv.URL), SetConnectionDone(s.done))
+	pub := MustPublisher(MustSession(conn, SetChannelDone(s.done)),
+		SetPublisherKey("queue"),
+		SetPublisherDelayMode(DelayPlugin),
+		SetPublisherClose(s.done),
+	)
+
+	err, ok := pub.(base.DelayedPublisher).PublishAfter(&Message{}, time.Second)
+	assert.Error(err)
+	assert.False(ok)
+}
+
+func TestDelayUnitSuite(t *testing.T) {
+	suite.Run(t, new(DelayUnitSuite))
+}
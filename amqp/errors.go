@@ -3,5 +3,10 @@ package amqp
 import "errors"
 
 var (
-	ConnectionError = errors.New("Unable to connect to amqp broker")
+	ConnectionError    = errors.New("Unable to connect to amqp broker")
+	QueueNotFoundError = errors.New("Queue not found")
+
+	// ErrBusClosed is returned by the publishings of a bus that is shutting
+	// down or closed.
+	ErrBusClosed = errors.New("Bus closed")
 )
@@ -1,6 +1,10 @@
 package amqp
 
 import (
+	"strconv"
+	"time"
+
+	base "github.com/movidesk/go-bus"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
 )
@@ -8,8 +12,28 @@ import (
 type Message struct {
 	*amqp.Delivery
 
-	Headers map[string]interface{}
-	Body    []byte
+	MessageId  string
+	Expiration time.Duration
+	Priority   uint8
+	Headers    map[string]interface{}
+	Body       []byte
+
+	settled func(tag uint64, multiple, acked bool)
+}
+
+// newMessage wraps a delivery.
+func newMessage(dlv *amqp.Delivery) *Message {
+	msg := &Message{
+		Delivery:  dlv,
+		MessageId: dlv.MessageId,
+		Priority:  dlv.Priority,
+		Headers:   dlv.Headers,
+		Body:      dlv.Body,
+	}
+	if ms, err := strconv.ParseInt(dlv.Expiration, 10, 64); err == nil {
+		msg.Expiration = time.Duration(ms) * time.Millisecond
+	}
+	return msg
 }
 
 func (m *Message) Ack(multiple bool) error {
@@ -17,6 +41,7 @@ func (m *Message) Ack(multiple bool) error {
 		return errors.New("Unable to ack message, delivery is not set")
 	}
 
+	defer m.settle(multiple, true)
 	return m.Delivery.Ack(multiple)
 }
 
@@ -25,6 +50,7 @@ func (m *Message) Nack(multiple bool, requeue bool) error {
 		return errors.New("Unable to nack message, delivery is not set")
 	}
 
+	defer m.settle(multiple, false)
 	return m.Delivery.Nack(multiple, requeue)
 }
 
@@ -33,9 +59,104 @@ func (m *Message) Reject(requeue bool) error {
 		return errors.New("Unable to reject message, delivery is not set")
 	}
 
+	defer m.settle(false, false)
 	return m.Delivery.Reject(requeue)
 }
 
+// settle tells the subscriber of the message it was settled, even when the
+// channel was closed in between.
+func (m *Message) settle(multiple, acked bool) {
+	if m.settled != nil {
+		m.settled(m.Delivery.DeliveryTag, multiple, acked)
+	}
+}
+
+// GetMessageId returns the application id of the message, the publisher sends
+// it as the message-id property.
+func (m *Message) GetMessageId() string {
+	return m.MessageId
+}
+
+func (m *Message) SetMessageId(id string) {
+	m.MessageId = id
+}
+
+// GetExpiration returns how long the message may wait in a queue before it is
+// dropped or dead-lettered, the publisher sends it as the expiration property
+// rounded to milliseconds.
+func (m *Message) GetExpiration() time.Duration {
+	return m.Expiration
+}
+
+func (m *Message) SetExpiration(d time.Duration) {
+	m.Expiration = d
+}
+
+// GetPriority returns the priority of the message, honoured by the queues
+// declared with a max priority.
+func (m *Message) GetPriority() uint8 {
+	return m.Priority
+}
+
+func (m *Message) SetPriority(p uint8) {
+	m.Priority = p
+}
+
+// GetRoutingKey returns the routing key the message was published with, it is
+// empty for messages that were not delivered.
+func (m *Message) GetRoutingKey() string {
+	if m.Delivery == nil {
+		return ""
+	}
+	return m.Delivery.RoutingKey
+}
+
+// GetDeliveryCount returns how many times a quorum queue delivered the message
+// before, from the x-delivery-count header. It is 0 for first deliveries and
+// for the messages of other queues, which only tell Redelivered.
+func (m *Message) GetDeliveryCount() int64 {
+	count, _ := headerInt(m.Headers, "x-delivery-count")
+	return count
+}
+
+// GetStreamOffset returns the offset of a message read from a stream, to start
+// a subscriber after it with StreamOffsetOf.
+func (m *Message) GetStreamOffset() (int64, bool) {
+	return headerInt(m.Headers, "x-stream-offset")
+}
+
+func headerInt(headers map[string]interface{}, key string) (int64, bool) {
+	switch v := headers[key].(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// DeliveryInfo returns the properties of the delivery, only the message id for
+// messages that were not consumed.
+func (m *Message) DeliveryInfo() base.DeliveryInfo {
+	info := base.DeliveryInfo{MessageId: m.MessageId}
+	if m.Delivery == nil {
+		return info
+	}
+
+	info.Exchange = m.Delivery.Exchange
+	info.RoutingKey = m.Delivery.RoutingKey
+	info.ConsumerTag = m.Delivery.ConsumerTag
+	info.Timestamp = m.Delivery.Timestamp
+	info.Redelivered = m.Delivery.Redelivered
+	return info
+}
+
 func (m *Message) GetHeaders() map[string]interface{} {
 	return m.Headers
 }
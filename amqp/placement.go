@@ -0,0 +1,93 @@
+package amqp
+
+// Placement is how a bus picks the connection of a new publisher or subscriber
+// among the connections of its target.
+type Placement int
+
+const (
+	// PlacementRoundRobin picks the connections in turn, the default.
+	PlacementRoundRobin Placement = iota
+	// PlacementLeastChannels picks the connection with the fewest open
+	// channels.
+	PlacementLeastChannels
+)
+
+// SetBusConnections sets how many connections the bus opens to each of its
+// targets for the publishers, and as many for the subscribers, 1 by default.
+// A connection blocked by the server or lost only stops the channels on it,
+// new publishers and subscribers are placed on the others meanwhile.
+func SetBusConnections(n int) BusOptionsFn {
+	return func(o *BusOptions) {
+		o.connections = n
+	}
+}
+
+// SetBusPlacement sets how the bus spreads its publishers and subscribers
+// over its connections, PlacementRoundRobin by default.
+func SetBusPlacement(placement Placement) BusOptionsFn {
+	return func(o *BusOptions) {
+		o.placement = placement
+	}
+}
+
+// place returns the index of the connection for a new channel, skipping the
+// connections closed or blocked while others are not. The connections not
+// opened yet count as healthy. Requires the lock of the target.
+func (b *bus) place(conns []*Connection, next *int, pools []*Pool) int {
+	healthy := make([]int, 0, len(conns))
+	for i, conn := range conns {
+		if conn == nil || (!conn.IsClosed() && !conn.IsBlocked()) {
+			healthy = append(healthy, i)
+		}
+	}
+	if len(healthy) == 0 {
+		for i := range conns {
+			healthy = append(healthy, i)
+		}
+	}
+
+	if b.placement == PlacementLeastChannels {
+		counts := b.channels(conns, pools)
+		best := healthy[0]
+		for _, i := range healthy[1:] {
+			if counts[i] < counts[best] {
+				best = i
+			}
+		}
+		return best
+	}
+
+	for _, i := range healthy {
+		if i >= *next {
+			*next = i + 1
+			return i
+		}
+	}
+	*next = healthy[0] + 1
+	return healthy[0]
+}
+
+// channels counts the open channels of the sessions and pools of the bus on
+// each of the connections.
+func (b *bus) channels(conns []*Connection, pools []*Pool) []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counts := make([]int, len(conns))
+	for _, sess := range b.sessions {
+		if sess.Channel.IsClosed() {
+			continue
+		}
+		for i, conn := range conns {
+			if conn != nil && sess.Connection == conn {
+				counts[i]++
+			}
+		}
+	}
+	for i, pool := range pools {
+		if pool != nil && pool.conn == conns[i] {
+			counts[i] += pool.Channels()
+		}
+	}
+	return counts
+}
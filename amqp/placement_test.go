@@ -0,0 +1,102 @@
+package amqp
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/stretchr/testify/suite"
+)
+
+type PlacementUnitSuite struct {
+	suite.Suite
+	srv *amqptest.Server
+	bus Bus
+}
+
+func (s *PlacementUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+	declareTopic(s.srv.URL, "exchange", "queue")
+}
+
+func (s *PlacementUnitSuite) TearDownTest() {
+	s.bus.Close()
+	s.srv.Close()
+}
+
+func (s *PlacementUnitSuite) start(fns ...BusOptionsFn) *target {
+	s.bus = MustBus(append([]BusOptionsFn{SetBusDSN(s.srv.URL), SetBusConnections(2)}, fns...)...)
+	return s.bus.(*bus).targets[""]
+}
+
+func (s *PlacementUnitSuite) publisher(fns ...PublisherOptionsFn) *Connection {
+	p := s.bus.MustPublisher(append(fns, SetPublisherExchange("exchange"))...)
+	return p.(*pub).Session.Connection
+}
+
+func (s *PlacementUnitSuite) TestRoundRobin() {
+	assert := s.Assert()
+	t := s.start()
+
+	conns := []*Connection{s.publisher(), s.publisher(), s.publisher()}
+	assert.Same(t.pubconns[0], conns[0])
+	assert.Same(t.pubconns[1], conns[1])
+	assert.Same(t.pubconns[0], conns[2])
+	assert.NotSame(conns[0], conns[1])
+
+	subscriber := s.bus.MustSubscriber(SetSubscriberQueue("queue"))
+	assert.Same(t.subconns[0], subscriber.(*sub).Session.Connection)
+	assert.NotSame(t.pubconns[0], t.subconns[0])
+}
+
+func (s *PlacementUnitSuite) TestLeastChannels() {
+	assert := s.Assert()
+	t := s.start(SetBusPlacement(PlacementLeastChannels))
+
+	assert.Same(t.pubconns[0], s.publisher(SetPublisherName("first")))
+	assert.Same(t.pubconns[1], s.publisher())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Require().NoError(s.bus.ClosePublisher(ctx, "first"))
+
+	assert.Same(t.pubconns[0], s.publisher())
+	assert.Same(t.pubconns[0], s.publisher())
+	assert.Same(t.pubconns[1], s.publisher())
+}
+
+func (s *PlacementUnitSuite) TestBlockedConnection() {
+	assert := s.Assert()
+	t := s.start()
+
+	blocked := s.bus.MustPublisher(SetPublisherExchange("exchange"))
+	other := s.bus.MustPublisher(SetPublisherExchange("exchange"))
+	atomic.StoreInt32(&t.pubconns[0].blocked, 1)
+
+	// new publishers avoid the blocked connection
+	assert.Same(t.pubconns[1], s.publisher())
+	assert.Same(t.pubconns[1], s.publisher())
+
+	// the publishers of the other connection keep publishing
+	err, ok := other.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+
+	atomic.StoreInt32(&t.pubconns[0].blocked, 0)
+	assert.Same(t.pubconns[0], s.publisher())
+	err, ok = blocked.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func (s *PlacementUnitSuite) TestInvalidConnections() {
+	_, err := NewBus(SetBusConnections(0))
+	s.Assert().Error(err)
+	s.bus = MustBus(SetBusDSN(s.srv.URL))
+}
+
+func TestPlacementUnitSuite(t *testing.T) {
+	suite.Run(t, new(PlacementUnitSuite))
+}
@@ -0,0 +1,385 @@
+package amqp
+
+import (
+	"context"
+	"sync"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+type PoolOptionsFn func(*PoolOptions)
+
+type PoolOptions struct {
+	size int
+
+	wg   *sync.WaitGroup
+	done <-chan struct{}
+}
+
+// SetPoolSize sets the most channels the pool opens, callers wait for a
+// channel to be released once they are all in use.
+func SetPoolSize(size int) PoolOptionsFn {
+	return func(o *PoolOptions) {
+		o.size = size
+	}
+}
+
+func SetPoolWaitGroup(wg *sync.WaitGroup) PoolOptionsFn {
+	return func(o *PoolOptions) {
+		o.wg = wg
+	}
+}
+
+// SetPoolDone closes the pool once done is closed.
+func SetPoolDone(done <-chan struct{}) PoolOptionsFn {
+	return func(o *PoolOptions) {
+		o.done = done
+	}
+}
+
+// Pool shares the channels of a connection between goroutines. Its channels
+// are opened in confirm mode and lent to one goroutine at a time, a channel
+// closed by the server or by a reconnection is discarded and replaced by a new
+// one on the next Get.
+type Pool struct {
+	*PoolOptions
+
+	conn  *Connection
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*PoolChannel
+	closed bool
+}
+
+func MustPool(conn *Connection, fns ...PoolOptionsFn) *Pool {
+	pool, err := NewPool(conn, fns...)
+	if err != nil {
+		panic(err)
+	}
+	return pool
+}
+
+// NewPool creates a pool of up to 8 channels by default. Channels are opened
+// on demand.
+func NewPool(conn *Connection, fns ...PoolOptionsFn) (*Pool, error) {
+	o := &PoolOptions{}
+	SetPoolSize(8)(o)
+	SetPoolWaitGroup(&sync.WaitGroup{})(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if conn == nil {
+		return nil, errors.New("Could not create pool, connection is required")
+	}
+	if o.size < 1 {
+		return nil, errors.New("Could not create pool, size must be at least 1")
+	}
+
+	p := &Pool{
+		PoolOptions: o,
+		conn:        conn,
+		slots:       make(chan struct{}, o.size),
+		idle:        make([]*PoolChannel, 0, o.size),
+	}
+
+	if p.done != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			<-p.done
+			p.Close()
+		}()
+	}
+
+	return p, nil
+}
+
+// Get lends a channel, waiting for one to be released when the pool is full.
+// The channel must be handed back with Put.
+func (p *Pool) Get(ctx context.Context) (*PoolChannel, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "Could not get channel from pool")
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, errors.New("Could not get channel from pool, pool is closed")
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if c.healthy() {
+			return c, nil
+		}
+		c.Channel.Close()
+	}
+
+	c, err := p.open()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// Put hands a channel back to the pool, an unhealthy channel is closed instead
+// of being kept. The confirmations of the publishings on it must have been
+// read.
+func (p *Pool) Put(c *PoolChannel) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || !c.healthy() {
+		c.Channel.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+// Close closes the idle channels, the channels lent are closed as they are
+// handed back.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for _, c := range p.idle {
+		c.Channel.Close()
+	}
+	p.idle = nil
+}
+
+// Channels returns the number of channels of the pool, idle or lent.
+func (p *Pool) Channels() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.idle) + len(p.slots)
+}
+
+// Len returns the number of idle channels.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.idle)
+}
+
+func (p *Pool) open() (*PoolChannel, error) {
+	if p.conn.IsClosed() {
+		return nil, errors.New("Could not open pool channel, connection is closed")
+	}
+
+	chnn, err := p.conn.Channel()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not conn.Channel()")
+	}
+	if err := chnn.Confirm(false); err != nil {
+		chnn.Close()
+		return nil, errors.Wrap(err, "Could not channel.Confirm()")
+	}
+
+	return &PoolChannel{
+		Channel:  chnn,
+		confirms: chnn.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		closes:   chnn.NotifyClose(make(chan *amqp.Error, 1)),
+	}, nil
+}
+
+// PoolChannel is a channel of a pool, in confirm mode.
+type PoolChannel struct {
+	*amqp.Channel
+
+	confirms chan amqp.Confirmation
+	closes   chan *amqp.Error
+	tag      uint64
+}
+
+// Publish publishes on the channel and returns the delivery tag its
+// confirmation carries.
+func (c *PoolChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (uint64, error) {
+	if err := c.Channel.Publish(exchange, key, mandatory, immediate, msg); err != nil {
+		return 0, err
+	}
+	c.tag++
+	return c.tag, nil
+}
+
+// Confirmations returns the confirmations of the publishings on the channel.
+func (c *PoolChannel) Confirmations() <-chan amqp.Confirmation {
+	return c.confirms
+}
+
+func (c *PoolChannel) healthy() bool {
+	select {
+	case <-c.closes:
+		return false
+	default:
+		return true
+	}
+}
+
+type pooledPub struct {
+	*PublisherOptions
+
+	pool     *Pool
+	gate     *gate
+	counters publishCounters
+}
+
+func MustPooledPublisher(pool *Pool, fns ...PublisherOptionsFn) Publisher {
+	pub, err := NewPooledPublisher(pool, fns...)
+	if err != nil {
+		panic(err)
+	}
+	return pub
+}
+
+// NewPooledPublisher creates a publisher safe for use from many goroutines,
+// each publishing borrows a channel of the pool. Publishings are always
+// confirmed, SetPublisherConfirm has no effect.
+func NewPooledPublisher(pool *Pool, fns ...PublisherOptionsFn) (Publisher, error) {
+	o := &PublisherOptions{}
+	SetPublisherMandatory(false)(o)
+	SetPublisherImmediate(false)(o)
+	SetPublisherWaitGroup(&sync.WaitGroup{})(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if pool == nil {
+		return nil, errors.New("Could not create publisher, pool is required")
+	}
+
+	p := &pooledPub{
+		PublisherOptions: o,
+		pool:             pool,
+		gate:             newGate(),
+	}
+
+	if p.close != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			<-p.close
+			p.gate.close()
+		}()
+	}
+
+	return p, nil
+}
+
+func (p *pooledPub) Publish(msg base.Message) (error, bool) {
+	if err := p.gate.enter(); err != nil {
+		return err, false
+	}
+	defer p.gate.leave()
+
+	c, err := p.pool.Get(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "Could not Publish"), false
+	}
+
+	tag, err := p.publish(c)(msg)
+	if err != nil {
+		p.pool.Put(c)
+		p.counters.record(err, false)
+		return err, false
+	}
+	ok, err := waitConfirm(c.confirms, tag, p.gate.abort)
+	if err == nil {
+		tag = 0
+	}
+	p.put(c, tag)
+	p.counters.record(err, ok)
+	return err, ok
+}
+
+func (p *pooledPub) PublishBatch(ctx context.Context, msgs []base.Message) []PublishResult {
+	if err := p.gate.enter(); err != nil {
+		return failedResults(msgs, err)
+	}
+	defer p.gate.leave()
+
+	c, err := p.pool.Get(ctx)
+	if err != nil {
+		return failedResults(msgs, err)
+	}
+
+	results, unread := publishBatch(ctx, msgs, p.publish(c), c.confirms, p.gate.abort)
+	p.put(c, unread)
+	p.counters.recordBatch(results)
+	return results
+}
+
+// put hands the channel back to the pool once the confirmations up to the tag
+// were read. Those a publishing gave up on are read in the background, the
+// pool would otherwise lend a channel whose confirmations hold the connection
+// back.
+func (p *pooledPub) put(c *PoolChannel, tag uint64) {
+	if tag == 0 {
+		p.pool.Put(c)
+		return
+	}
+	go func() {
+		discardConfirms(c.confirms, tag)
+		p.pool.Put(c)
+	}()
+}
+
+func (p *pooledPub) Drain(ctx context.Context) error {
+	return p.gate.drain(ctx)
+}
+
+func (p *pooledPub) Confirms() bool {
+	return true
+}
+
+func (p *pooledPub) Info() PublisherInfo {
+	info := PublisherInfo{
+		Name:      p.name,
+		Target:    p.target,
+		Exchange:  p.exchange,
+		Key:       p.key,
+		Mandatory: p.mandatory,
+		Confirm:   true,
+		Pooled:    true,
+		State:     p.gate.state(),
+	}
+	p.counters.fill(&info)
+	return info
+}
+
+// shutdown aborts the publishings in flight, the channels belong to the pool.
+func (p *pooledPub) shutdown() {
+	p.gate.close()
+}
+
+func (p *pooledPub) publish(c *PoolChannel) func(base.Message) (uint64, error) {
+	return func(msg base.Message) (uint64, error) {
+		tag, err := c.Publish(p.exchange, p.key, p.mandatory, p.immediate, publishing(msg))
+		if err != nil {
+			return 0, errors.Wrap(err, "Could not Publish, channel.Publish() failed")
+		}
+		return tag, nil
+	}
+}
@@ -0,0 +1,232 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/suite"
+)
+
+type PoolUnitSuite struct {
+	suite.Suite
+	srv  *amqptest.Server
+	conn *Connection
+}
+
+func (s *PoolUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+	s.conn = MustConnection(SetConnectionDSN(s.srv.URL))
+
+	ch, err := s.conn.Channel()
+	s.Require().NoError(err)
+	_, err = ch.QueueDeclare("queue", false, false, false, false, nil)
+	s.Require().NoError(err)
+	ch.Close()
+}
+
+func (s *PoolUnitSuite) TearDownTest() {
+	s.conn.Close()
+	s.srv.Close()
+}
+
+func (s *PoolUnitSuite) TestGetReusesChannels() {
+	assert := s.Assert()
+
+	pool := MustPool(s.conn)
+	defer pool.Close()
+
+	c, err := pool.Get(context.Background())
+	s.Require().NoError(err)
+	assert.Equal(0, pool.Len())
+
+	pool.Put(c)
+	assert.Equal(1, pool.Len())
+
+	again, err := pool.Get(context.Background())
+	s.Require().NoError(err)
+	assert.True(c == again)
+	pool.Put(again)
+}
+
+func (s *PoolUnitSuite) TestGetWaitsForAFreeChannel() {
+	assert := s.Assert()
+
+	pool := MustPool(s.conn, SetPoolSize(1))
+	defer pool.Close()
+
+	c, err := pool.Get(context.Background())
+	s.Require().NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err = pool.Get(ctx)
+	assert.Error(err)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		pool.Put(c)
+	}()
+	again, err := pool.Get(context.Background())
+	assert.NoError(err)
+	assert.True(c == again)
+	pool.Put(again)
+}
+
+func (s *PoolUnitSuite) TestDiscardsClosedChannels() {
+	assert := s.Assert()
+
+	pool := MustPool(s.conn)
+	defer pool.Close()
+
+	c, err := pool.Get(context.Background())
+	s.Require().NoError(err)
+	pool.Put(c)
+
+	// publishing to an unexistent exchange closes the channel
+	c, err = pool.Get(context.Background())
+	s.Require().NoError(err)
+	_, err = c.Publish("unexistent", "", false, false, amqp.Publishing{})
+	assert.NoError(err)
+	_, ok := <-c.Confirmations()
+	assert.False(ok)
+	pool.Put(c)
+	assert.Equal(0, pool.Len())
+
+	again, err := pool.Get(context.Background())
+	s.Require().NoError(err)
+	assert.False(c == again)
+	tag, err := again.Publish("", "queue", false, false, amqp.Publishing{})
+	assert.NoError(err)
+	assert.Equal(uint64(1), tag)
+	confirm := <-again.Confirmations()
+	assert.True(confirm.Ack)
+	pool.Put(again)
+}
+
+func (s *PoolUnitSuite) TestClosesOnDone() {
+	assert := s.Assert()
+
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	pool := MustPool(s.conn, SetPoolDone(done), SetPoolWaitGroup(wg))
+
+	c, err := pool.Get(context.Background())
+	s.Require().NoError(err)
+	pool.Put(c)
+
+	close(done)
+	wg.Wait()
+	assert.Equal(0, pool.Len())
+
+	_, err = pool.Get(context.Background())
+	assert.Error(err)
+}
+
+func (s *PoolUnitSuite) TestPooledPublisherIsSafeForConcurrentUse() {
+	assert := s.Assert()
+
+	pool := MustPool(s.conn, SetPoolSize(3))
+	defer pool.Close()
+	pub := MustPooledPublisher(pool, SetPublisherKey("queue"))
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err, ok := pub.Publish(&Message{Body: []byte(fmt.Sprint(i))})
+			assert.NoError(err)
+			assert.True(ok)
+		}(i)
+	}
+	wg.Wait()
+
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(50, n)
+	assert.True(pool.Len() <= 3)
+}
+
+func (s *PoolUnitSuite) TestPooledPublishBatch() {
+	assert := s.Assert()
+
+	pool := MustPool(s.conn)
+	defer pool.Close()
+	pub := MustPooledPublisher(pool, SetPublisherKey("queue"))
+
+	results := pub.PublishBatch(context.Background(), []base.Message{&Message{}, &Message{}})
+	assert.Len(results, 2)
+	for _, result := range results {
+		assert.NoError(result.Err)
+		assert.True(result.Ok)
+	}
+
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(2, n)
+}
+
+func (s *PoolUnitSuite) TestPutReadsUnreadConfirmations() {
+	assert := s.Assert()
+
+	pool := MustPool(s.conn, SetPoolSize(1))
+	defer pool.Close()
+	pub := MustPooledPublisher(pool, SetPublisherKey("queue"))
+
+	c, err := pool.Get(context.Background())
+	s.Require().NoError(err)
+	var tag uint64
+	for i := 0; i < 3; i++ {
+		tag, err = c.Publish("", "queue", false, false, amqp.Publishing{})
+		s.Require().NoError(err)
+	}
+
+	pub.(*pooledPub).put(c, tag)
+	waitToBeTrue(func() bool { return pool.Len() == 1 }, time.Second)
+	assert.Equal(1, pool.Len())
+	assert.Len(c.confirms, 0)
+
+	err, ok := pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func (s *PoolUnitSuite) TestBusPooledPublisher() {
+	assert := s.Assert()
+
+	bus := MustBus(SetBusDSN(s.srv.URL), SetBusPoolSize(2))
+	pub := bus.MustPooledPublisher(SetPublisherKey("queue"))
+	other := bus.MustPooledPublisher(SetPublisherKey("queue"))
+
+	err, ok := pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+	err, ok = other.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(2, n)
+
+	bus.Close()
+	bus.Wait()
+}
+
+func (s *PoolUnitSuite) TestInvalidOptions() {
+	assert := s.Assert()
+
+	_, err := NewPool(s.conn, SetPoolSize(0))
+	assert.Error(err)
+	_, err = NewPool(nil)
+	assert.Error(err)
+	_, err = NewPooledPublisher(nil)
+	assert.Error(err)
+}
+
+func TestPoolUnitSuite(t *testing.T) {
+	suite.Run(t, new(PoolUnitSuite))
+}
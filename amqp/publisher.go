@@ -1,8 +1,11 @@
 package amqp
 
 import (
+	"context"
 	"log"
+	"strconv"
 	"sync"
+	"time"
 
 	base "github.com/movidesk/go-bus"
 	"github.com/pkg/errors"
@@ -12,6 +15,9 @@ import (
 type PublisherOptionsFn func(*PublisherOptions)
 
 type PublisherOptions struct {
+	name   string
+	target string
+
 	confirm       bool
 	confirmations chan amqp.Confirmation
 
@@ -21,10 +27,20 @@ type PublisherOptions struct {
 	mandatory bool
 	immediate bool
 
+	delayMode       DelayMode
+	delayResolution time.Duration
+
 	close <-chan struct{}
 	wg    *sync.WaitGroup
 }
 
+// SetPublisherName names the publisher in the bus, names are unique to a bus.
+func SetPublisherName(name string) PublisherOptionsFn {
+	return func(o *PublisherOptions) {
+		o.name = name
+	}
+}
+
 func SetPublisherConfirm(confirm bool) PublisherOptionsFn {
 	return func(o *PublisherOptions) {
 		o.confirm = confirm
@@ -79,12 +95,50 @@ func SetPublisherWaitGroup(wg *sync.WaitGroup) PublisherOptionsFn {
 
 type Publisher interface {
 	base.Publisher
+
+	// PublishBatch publishes the messages one after the other and then waits
+	// for their confirmations together. It returns a result per message, in
+	// the order of the messages, so the ones not confirmed can be published
+	// again. Messages still waiting for their confirmation when the context is
+	// done are reported with the context error.
+	PublishBatch(context.Context, []base.Message) []PublishResult
+	// Drain stops the publisher from accepting publishings, they fail with
+	// ErrBusClosed, and waits for the confirmations of those in flight.
+	Drain(context.Context) error
+	// Confirms tells whether the publisher waits for the server to confirm
+	// its publishings, ok is always false otherwise.
+	Confirms() bool
+	// Info describes the publisher.
+	Info() PublisherInfo
 }
+
+// PublishResult is the outcome of publishing a message of a batch. Ok is set
+// when the server confirmed the message, a message nacked by the server has
+// neither Ok nor Err set.
+type PublishResult struct {
+	Message base.Message
+	Err     error
+	Ok      bool
+}
+
 type pub struct {
 	*PublisherOptions
 	*Session
 
 	reconnected chan bool
+
+	// delayed is the delay mode once DelayAuto was resolved, delays holds when
+	// the delay exchange and queues were last declared.
+	delayed DelayMode
+	delays  map[string]time.Time
+
+	// mu serializes the publishings so the confirmations can be matched to
+	// them, tag is the delivery tag of the last publishing on the channel.
+	mu  sync.Mutex
+	tag uint64
+
+	gate     *gate
+	counters publishCounters
 }
 
 func MustPublisher(sess *Session, fns ...PublisherOptionsFn) Publisher {
@@ -102,6 +156,8 @@ func NewPublisher(sess *Session, fns ...PublisherOptionsFn) (Publisher, error) {
 	SetPublisherMandatory(false)(o)
 	SetPublisherImmediate(false)(o)
 	SetPublisherConfirm(true)(o)
+	SetPublisherDelayMode(DelayAuto)(o)
+	SetPublisherDelayResolution(time.Second)(o)
 	for _, fn := range fns {
 		fn(o)
 	}
@@ -112,6 +168,9 @@ func NewPublisher(sess *Session, fns ...PublisherOptionsFn) (Publisher, error) {
 		Session:          sess,
 		PublisherOptions: o,
 		reconnected:      reconnected,
+		delayed:          o.delayMode,
+		delays:           make(map[string]time.Time),
+		gate:             newGate(),
 	}
 
 	err := p.setup()
@@ -126,29 +185,252 @@ func NewPublisher(sess *Session, fns ...PublisherOptionsFn) (Publisher, error) {
 }
 
 func (p *pub) Publish(msg base.Message) (error, bool) {
+	if err := p.gate.enter(); err != nil {
+		return err, false
+	}
+	defer p.gate.leave()
+
+	p.mu.Lock()
+	tag, err := p.publish(msg)
+	if err != nil {
+		p.mu.Unlock()
+		p.counters.record(err, false)
+		return err, false
+	}
+
+	//TODO: confirmation timeout
+	ok, err := waitConfirm(p.confirmations, tag, p.gate.abort)
+	if err == nil {
+		tag = 0
+	}
+	p.unlock(tag)
+	p.counters.record(err, ok)
+	return err, ok
+}
+
+func (p *pub) PublishBatch(ctx context.Context, msgs []base.Message) []PublishResult {
+	if err := p.gate.enter(); err != nil {
+		return failedResults(msgs, err)
+	}
+	defer p.gate.leave()
+
+	p.mu.Lock()
+	results, unread := publishBatch(ctx, msgs, p.publish, p.confirmations, p.gate.abort)
+	p.unlock(unread)
+	p.counters.recordBatch(results)
+	return results
+}
+
+// unlock releases the lock of the publishings once the confirmations up to the
+// tag were read. Those a publishing gave up on are read in the background with
+// the lock held, so they never hold the connection back and the next
+// publishing does not take them for its own.
+func (p *pub) unlock(tag uint64) {
+	if tag == 0 {
+		p.mu.Unlock()
+		return
+	}
+	confirms := p.confirmations
+	go func() {
+		discardConfirms(confirms, tag)
+		p.mu.Unlock()
+	}()
+}
+
+// Drain stops the publisher from accepting publishings and waits for the
+// confirmations of those in flight, until the context is done. The callers
+// still waiting then fail with ErrBusClosed.
+func (p *pub) Drain(ctx context.Context) error {
+	return p.gate.drain(ctx)
+}
+
+func (p *pub) Confirms() bool {
+	return p.confirm
+}
+
+func (p *pub) Info() PublisherInfo {
+	info := PublisherInfo{
+		Name:      p.name,
+		Target:    p.target,
+		Exchange:  p.exchange,
+		Key:       p.key,
+		Mandatory: p.mandatory,
+		Confirm:   p.confirm,
+		State:     p.gate.state(),
+	}
+	p.counters.fill(&info)
+	return info
+}
+
+// shutdown aborts the publishings in flight and closes the channel.
+func (p *pub) shutdown() {
+	p.gate.close()
+	p.Session.Channel.Close()
+}
+
+// publish returns the delivery tag of the publishing, zero when the publisher
+// does not wait for confirmations.
+func (p *pub) publish(msg base.Message) (uint64, error) {
+	return p.publishTo(p.exchange, p.key, p.mandatory, publishing(msg))
+}
+
+func (p *pub) publishTo(exchange, key string, mandatory bool, publishing amqp.Publishing) (uint64, error) {
 	if p.Session.Channel.IsClosed() {
-		return errors.New("Could not Publish, session channel is closed"), false
+		return 0, errors.New("Could not Publish, session channel is closed")
 	}
 
 	if p.Session.Connection.IsClosed() {
-		return errors.New("Could not Publish, session connection is closed"), false
+		return 0, errors.New("Could not Publish, session connection is closed")
 	}
 
-	err := p.Session.Channel.Publish(p.exchange, p.key, p.mandatory, p.immediate, amqp.Publishing{
+	err := p.Session.Channel.Publish(exchange, key, mandatory, p.immediate, publishing)
+	if err != nil {
+		return 0, errors.Wrap(err, "Could not Publish, channel.Publish() failed")
+	}
+	p.tag++
+
+	if !p.confirm {
+		return 0, nil
+	}
+	return p.tag, nil
+}
+
+func publishing(msg base.Message) amqp.Publishing {
+	publishing := amqp.Publishing{
 		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.DeliveryInfo().MessageId,
+		Timestamp:    time.Now(),
 		Headers:      msg.GetHeaders(),
 		Body:         msg.GetBody(),
-	})
-	if err != nil {
-		return errors.Wrap(err, "Could not Publish, channel.Publish() failed"), false
 	}
+	if m, ok := msg.(base.Expirable); ok {
+		if d := m.GetExpiration(); d > 0 {
+			// rounded up so a short expiration never means expire right away
+			ms := (d + time.Millisecond - 1) / time.Millisecond
+			publishing.Expiration = strconv.FormatInt(int64(ms), 10)
+		}
+	}
+	if m, ok := msg.(base.Prioritizable); ok {
+		publishing.Priority = m.GetPriority()
+	}
+	return publishing
+}
 
-	//TODO: confirmation timeout
-	c := <-p.confirmations
-	return nil, c.Ack
+// waitConfirm waits for the confirmation of the publishing with the tag, the
+// confirmations of earlier publishings nobody waited for are skipped. It gives up
+// with ErrBusClosed once abort is closed, leaving the confirmation unread.
+func waitConfirm(confirms <-chan amqp.Confirmation, tag uint64, abort <-chan struct{}) (bool, error) {
+	for {
+		select {
+		case <-abort:
+			return false, ErrBusClosed
+		case c, ok := <-confirms:
+			if !ok {
+				return false, nil
+			}
+			if c.DeliveryTag >= tag {
+				return c.Ack, nil
+			}
+		}
+	}
+}
+
+func failedResults(msgs []base.Message, err error) []PublishResult {
+	results := make([]PublishResult, len(msgs))
+	for i, msg := range msgs {
+		results[i] = PublishResult{Message: msg, Err: err}
+	}
+	return results
+}
+
+// discardConfirms reads the confirmations up to the one of the tag, or until
+// they are closed.
+func discardConfirms(confirms <-chan amqp.Confirmation, tag uint64) {
+	for c := range confirms {
+		if c.DeliveryTag >= tag {
+			return
+		}
+	}
+}
+
+// publishBatch publishes the messages one after the other and then matches
+// the confirmations to them by delivery tag. When it gives up on them it
+// returns the last tag published, the confirmations up to it are left unread.
+func publishBatch(ctx context.Context, msgs []base.Message, publish func(base.Message) (uint64, error), confirms <-chan amqp.Confirmation, abort <-chan struct{}) ([]PublishResult, uint64) {
+	results := make([]PublishResult, len(msgs))
+	pending := make(map[uint64]int, len(msgs))
+	var last uint64
+	confirmed := func(c amqp.Confirmation) {
+		if i, ok := pending[c.DeliveryTag]; ok {
+			results[i].Ok = c.Ack
+			delete(pending, c.DeliveryTag)
+		}
+	}
+
+	for i, msg := range msgs {
+		results[i].Message = msg
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		tag, err := publish(msg)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if tag > 0 {
+			pending[tag] = i
+			last = tag
+		}
+
+		// the confirmations arriving meanwhile are taken so the connection
+		// is never held back delivering them
+		for drained := false; !drained; {
+			select {
+			case c, ok := <-confirms:
+				if !ok {
+					drained = true
+					continue
+				}
+				confirmed(c)
+			default:
+				drained = true
+			}
+		}
+	}
+
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			for _, i := range pending {
+				results[i].Err = ctx.Err()
+			}
+			return results, last
+		case <-abort:
+			for _, i := range pending {
+				results[i].Err = ErrBusClosed
+			}
+			return results, last
+		case c, ok := <-confirms:
+			if !ok {
+				for _, i := range pending {
+					results[i].Err = errors.New("Could not confirm publishing, confirmations are closed")
+				}
+				return results, 0
+			}
+			confirmed(c)
+		}
+	}
+
+	return results, 0
 }
 
 func (p *pub) setup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tag = 0
 	p.confirmations = make(chan amqp.Confirmation, 1)
 	if !p.confirm {
 		close(p.confirmations)
@@ -174,6 +456,7 @@ out:
 	for running {
 		select {
 		case <-p.close:
+			p.gate.close()
 			running = false
 			break out
 		case <-p.reconnected:
@@ -188,3 +471,90 @@ out:
 		}
 	}
 }
+
+// gate stops a publisher from accepting publishings and counts those in
+// flight, so a drain can wait for their confirmations.
+type gate struct {
+	mu       sync.Mutex
+	closing  bool
+	inflight int
+	idle     chan struct{}
+
+	abort     chan struct{}
+	abortOnce sync.Once
+}
+
+func newGate() *gate {
+	return &gate{abort: make(chan struct{})}
+}
+
+// enter admits a publishing, it fails with ErrBusClosed once the gate closes.
+func (g *gate) enter() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closing {
+		return ErrBusClosed
+	}
+	g.inflight++
+	return nil
+}
+
+func (g *gate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.inflight--
+	if g.inflight == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// drain closes the gate and waits for the publishings in flight, they are
+// aborted once the context is done.
+func (g *gate) drain(ctx context.Context) error {
+	g.mu.Lock()
+	g.closing = true
+	if g.inflight == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	g.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		g.close()
+		return errors.Wrap(ctx.Err(), "Could not drain publisher")
+	}
+}
+
+func (g *gate) state() State {
+	select {
+	case <-g.abort:
+		return StateClosed
+	default:
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closing {
+		return StateDraining
+	}
+	return StateActive
+}
+
+// close closes the gate and aborts the publishings in flight.
+func (g *gate) close() {
+	g.mu.Lock()
+	g.closing = true
+	g.mu.Unlock()
+
+	g.abortOnce.Do(func() { close(g.abort) })
+}
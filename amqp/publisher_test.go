@@ -1,8 +1,13 @@
 package amqp
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -126,3 +131,237 @@ func (s *PublisherIntegrationSuite) TestPublishWithHeaderAndBody() {
 func TestPublisherIntegrationSuite(t *testing.T) {
 	suite.Run(t, new(PublisherIntegrationSuite))
 }
+
+type PublisherUnitSuite struct {
+	suite.Suite
+	srv *amqptest.Server
+}
+
+func (s *PublisherUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+}
+
+func (s *PublisherUnitSuite) TearDownTest() {
+	s.srv.Close()
+}
+
+func (s *PublisherUnitSuite) TestPublishWithConfirmOnUnexistentExchange() {
+	assert := s.Assert()
+
+	conn, _ := NewConnection(SetConnectionDSN(s.srv.URL))
+	sess, _ := NewSession(conn)
+	pub, _ := NewPublisher(
+		sess,
+		SetPublisherExchange("unexistent"),
+	)
+
+	err, ok := pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.False(ok)
+}
+
+func (s *PublisherUnitSuite) TestPublishWithConfirmOnExistentExchange() {
+	assert := s.Assert()
+
+	conn, _ := NewConnection(SetConnectionDSN(s.srv.URL))
+	sess, _ := NewSession(conn)
+	pub, _ := NewPublisher(
+		sess,
+		SetPublisherExchange("amq.topic"),
+	)
+
+	err, ok := pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func (s *PublisherUnitSuite) TestPublishBatch() {
+	assert := s.Assert()
+
+	conn, _ := NewConnection(SetConnectionDSN(s.srv.URL))
+	sess, _ := NewSession(conn)
+	_, err := sess.Channel.QueueDeclare("queue", false, false, false, false, amqp.Table{
+		"x-max-length": int32(2),
+		"x-overflow":   "reject-publish",
+	})
+	s.Require().NoError(err)
+	pub := MustPublisher(sess, SetPublisherKey("queue"))
+
+	msgs := []base.Message{
+		&Message{Body: []byte("1")},
+		&Message{Body: []byte("2")},
+		&Message{Body: []byte("3")},
+	}
+	results := pub.PublishBatch(context.Background(), msgs)
+	assert.Len(results, 3)
+	for i, result := range results {
+		assert.Equal(msgs[i], result.Message)
+		assert.NoError(result.Err)
+	}
+	assert.True(results[0].Ok)
+	assert.True(results[1].Ok)
+	assert.False(results[2].Ok)
+
+	err, ok := pub.Publish(&Message{Body: []byte("4")})
+	assert.NoError(err)
+	assert.False(ok)
+
+	_, err = sess.Channel.QueuePurge("queue", false)
+	s.Require().NoError(err)
+	err, ok = pub.Publish(&Message{Body: []byte("5")})
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func (s *PublisherUnitSuite) TestPublishBatchOnDoneContext() {
+	assert := s.Assert()
+
+	conn, _ := NewConnection(SetConnectionDSN(s.srv.URL))
+	sess, _ := NewSession(conn)
+	pub := MustPublisher(sess, SetPublisherExchange("amq.topic"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := pub.PublishBatch(ctx, []base.Message{&Message{}, &Message{}})
+	assert.Len(results, 2)
+	for _, result := range results {
+		assert.Equal(context.Canceled, result.Err)
+		assert.False(result.Ok)
+	}
+
+	err, ok := pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func (s *PublisherUnitSuite) TestPublishBatchGivesUpOnConfirmations() {
+	assert := s.Assert()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var tag uint64
+	publish := func(base.Message) (uint64, error) {
+		tag++
+		if tag == 2 {
+			cancel()
+		}
+		return tag, nil
+	}
+	confirms := make(chan amqp.Confirmation, 2)
+
+	results, unread := publishBatch(ctx, []base.Message{&Message{}, &Message{}}, publish, confirms, nil)
+	assert.Equal(uint64(2), unread)
+	for _, result := range results {
+		assert.Equal(context.Canceled, result.Err)
+	}
+
+	// the lock is released once the confirmations left were read
+	p := &pub{PublisherOptions: &PublisherOptions{confirmations: confirms}}
+	p.mu.Lock()
+	p.unlock(unread)
+
+	locked := make(chan struct{})
+	go func() {
+		p.mu.Lock()
+		close(locked)
+	}()
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	select {
+	case <-locked:
+		s.Fail("unlocked before the last confirmation")
+	case <-time.After(time.Millisecond * 50):
+	}
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		s.Fail("not unlocked after the last confirmation")
+	}
+	assert.Len(confirms, 0)
+}
+
+func (s *PublisherUnitSuite) faultyPublisher(dialer *amqptest.Dialer, done chan struct{}) Publisher {
+	conn := MustConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDialer(dialer.Dial),
+		SetConnectionDone(done),
+	)
+	sess := MustSession(conn, SetChannelDone(done))
+	return MustPublisher(sess, SetPublisherExchange("amq.topic"), SetPublisherClose(done))
+}
+
+func (s *PublisherUnitSuite) TestDrainWaitsForConfirmations() {
+	assert := s.Assert()
+
+	dialer := amqptest.NewDialer()
+	done := make(chan struct{})
+	defer close(done)
+	pub := s.faultyPublisher(dialer, done)
+
+	dialer.Delay(time.Millisecond * 50)
+	published := make(chan bool, 1)
+	go func() {
+		_, ok := pub.Publish(&Message{})
+		published <- ok
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(pub.Drain(ctx))
+	assert.True(<-published)
+
+	err, ok := pub.Publish(&Message{})
+	assert.Equal(ErrBusClosed, err)
+	assert.False(ok)
+}
+
+func (s *PublisherUnitSuite) TestDrainAbortsUnconfirmed() {
+	assert := s.Assert()
+
+	dialer := amqptest.NewDialer()
+	done := make(chan struct{})
+	defer close(done)
+	pub := s.faultyPublisher(dialer, done)
+
+	dialer.Blackhole(true)
+	published := make(chan error, 1)
+	go func() {
+		err, _ := pub.Publish(&Message{})
+		published <- err
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Error(pub.Drain(ctx))
+	assert.Equal(ErrBusClosed, <-published)
+}
+
+func (s *PublisherUnitSuite) TestCloseAbortsUnconfirmed() {
+	assert := s.Assert()
+
+	dialer := amqptest.NewDialer()
+	done := make(chan struct{})
+	pub := s.faultyPublisher(dialer, done)
+
+	dialer.Blackhole(true)
+	published := make(chan error, 1)
+	go func() {
+		err, _ := pub.Publish(&Message{})
+		published <- err
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	close(done)
+	select {
+	case err := <-published:
+		assert.Equal(ErrBusClosed, err)
+	case <-time.After(time.Second):
+		s.FailNow("publish did not return")
+	}
+}
+
+func TestPublisherUnitSuite(t *testing.T) {
+	suite.Run(t, new(PublisherUnitSuite))
+}
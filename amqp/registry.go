@@ -0,0 +1,265 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// State is the lifecycle state of a publisher or subscriber of a bus.
+type State int
+
+const (
+	// StateIdle is a subscriber that did not start consuming yet.
+	StateIdle State = iota
+	// StateActive publishes or consumes.
+	StateActive
+	// StatePaused is a subscriber that stopped consuming until it resumes.
+	StatePaused
+	// StateDraining no longer accepts publishings or deliveries and waits for
+	// those in flight.
+	StateDraining
+	// StateClosed is closed for good.
+	StateClosed
+	// StateFailed is a subscriber that could not consume its queue again, see
+	// Subscriber.Err.
+	StateFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateActive:
+		return "active"
+	case StatePaused:
+		return "paused"
+	case StateDraining:
+		return "draining"
+	case StateClosed:
+		return "closed"
+	case StateFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// PublisherInfo describes a publisher of a bus. Published counts the
+// publishings the server confirmed, Nacked those it did not and Failed those
+// that could not be published or confirmed.
+type PublisherInfo struct {
+	Name      string
+	Target    string
+	Exchange  string
+	Key       string
+	Mandatory bool
+	Confirm   bool
+	Pooled    bool
+	State     State
+
+	Published uint64
+	Nacked    uint64
+	Failed    uint64
+}
+
+// SubscriberInfo describes a subscriber of a bus. Delivered counts the
+// messages handed over, Acked and Nacked those settled and Unsettled those
+// handed over still waiting to be settled.
+type SubscriberInfo struct {
+	Name      string
+	Target    string
+	Queue     string
+	Consumer  string
+	Prefetch  int
+	AutoAck   bool
+	Exclusive bool
+	State     State
+
+	Delivered uint64
+	Acked     uint64
+	Nacked    uint64
+	Unsettled int
+}
+
+type publishCounters struct {
+	published uint64
+	nacked    uint64
+	failed    uint64
+}
+
+func (c *publishCounters) record(err error, ok bool) {
+	switch {
+	case err != nil:
+		atomic.AddUint64(&c.failed, 1)
+	case ok:
+		atomic.AddUint64(&c.published, 1)
+	default:
+		atomic.AddUint64(&c.nacked, 1)
+	}
+}
+
+func (c *publishCounters) recordBatch(results []PublishResult) {
+	for _, r := range results {
+		c.record(r.Err, r.Ok)
+	}
+}
+
+func (c *publishCounters) fill(info *PublisherInfo) {
+	info.Published = atomic.LoadUint64(&c.published)
+	info.Nacked = atomic.LoadUint64(&c.nacked)
+	info.Failed = atomic.LoadUint64(&c.failed)
+}
+
+// Publishers describes the publishers of the bus in the order they were
+// created.
+func (b *bus) Publishers() []PublisherInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	infos := make([]PublisherInfo, 0, len(b.pubs))
+	for _, pub := range b.pubs {
+		infos = append(infos, pub.Info())
+	}
+	return infos
+}
+
+// Subscribers describes the subscribers of the bus in the order they were
+// created.
+func (b *bus) Subscribers() []SubscriberInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	infos := make([]SubscriberInfo, 0, len(b.subs))
+	for _, sub := range b.subs {
+		infos = append(infos, sub.Info())
+	}
+	return infos
+}
+
+// ClosePublisher drains the publisher with the name until the context is done,
+// closes it and removes it from the bus.
+func (b *bus) ClosePublisher(ctx context.Context, name string) error {
+	b.mu.Lock()
+	var found Publisher
+	for i, pub := range b.pubs {
+		if pub.Info().Name == name {
+			found = pub
+			b.pubs = append(b.pubs[:i:i], b.pubs[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	if found == nil {
+		return errors.Errorf("Could not close publisher, %s not found", name)
+	}
+	err := found.Drain(ctx)
+	found.(closer).shutdown()
+	return err
+}
+
+// CloseSubscriber drains the subscriber with the name until the context is
+// done, closes it and removes it from the bus.
+func (b *bus) CloseSubscriber(ctx context.Context, name string) error {
+	b.mu.Lock()
+	var found Subscriber
+	for i, sub := range b.subs {
+		if sub.Info().Name == name {
+			found = sub
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	if found == nil {
+		return errors.Errorf("Could not close subscriber, %s not found", name)
+	}
+	err := found.Drain(ctx)
+	found.(closer).shutdown()
+	return err
+}
+
+// PauseSubscriber pauses the subscriber with the name, it stays registered and
+// resumes with ResumeSubscriber. Publishers have nothing to pause, they only
+// publish when called.
+func (b *bus) PauseSubscriber(name string) error {
+	sub := b.subscriber(name)
+	if sub == nil {
+		return errors.Errorf("Could not pause subscriber, %s not found", name)
+	}
+	return sub.Pause()
+}
+
+// ResumeSubscriber resumes the subscriber with the name.
+func (b *bus) ResumeSubscriber(name string) error {
+	sub := b.subscriber(name)
+	if sub == nil {
+		return errors.Errorf("Could not resume subscriber, %s not found", name)
+	}
+	return sub.Resume()
+}
+
+func (b *bus) subscriber(name string) Subscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sub := range b.subs {
+		if sub.Info().Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// closer is implemented by the publishers and subscribers a bus can close one
+// by one.
+type closer interface {
+	shutdown()
+}
+
+// registerPub adds the publisher to the bus, its name has to be unique.
+func (b *bus) registerPub(pub Publisher) (Publisher, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := pub.Info().Name
+	for _, other := range b.pubs {
+		if other.Info().Name == name {
+			pub.(closer).shutdown()
+			return nil, errors.Errorf("Could not create publisher, name %s is taken", name)
+		}
+	}
+	b.pubs = append(b.pubs, pub)
+	return pub, nil
+}
+
+// registerSub adds the subscriber to the bus, its name has to be unique.
+func (b *bus) registerSub(sub Subscriber) (Subscriber, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := sub.Info().Name
+	for _, other := range b.subs {
+		if other.Info().Name == name {
+			sub.(closer).shutdown()
+			return nil, errors.Errorf("Could not create subscriber, name %s is taken", name)
+		}
+	}
+	b.subs = append(b.subs, sub)
+	return sub, nil
+}
+
+// name returns the next default name of the kind.
+func (b *bus) name(kind string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.names == nil {
+		b.names = make(map[string]int)
+	}
+	b.names[kind]++
+	return fmt.Sprintf("%s-%d", kind, b.names[kind])
+}
@@ -0,0 +1,213 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/stretchr/testify/suite"
+)
+
+type RegistryUnitSuite struct {
+	suite.Suite
+	srv *amqptest.Server
+	bus Bus
+}
+
+func (s *RegistryUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+	s.bus = MustBus(SetBusDSN(s.srv.URL))
+	declareTopic(s.srv.URL, "exchange", "queue")
+}
+
+func (s *RegistryUnitSuite) TearDownTest() {
+	s.bus.Close()
+	s.srv.Close()
+}
+
+func (s *RegistryUnitSuite) TestNames() {
+	assert := s.Assert()
+
+	s.bus.MustPublisher()
+	s.bus.MustPooledPublisher(SetPublisherName("pooled"))
+	s.bus.MustSubscriber(SetSubscriberQueue("queue"))
+
+	pubs := s.bus.Publishers()
+	s.Require().Len(pubs, 2)
+	assert.Equal("publisher-1", pubs[0].Name)
+	assert.False(pubs[0].Pooled)
+	assert.Equal("pooled", pubs[1].Name)
+	assert.True(pubs[1].Pooled)
+
+	subs := s.bus.Subscribers()
+	s.Require().Len(subs, 1)
+	assert.Equal("subscriber-1", subs[0].Name)
+	assert.Equal("queue", subs[0].Queue)
+	assert.Equal(StateIdle, subs[0].State)
+
+	_, err := s.bus.NewPublisher(SetPublisherName("pooled"))
+	assert.Error(err)
+	assert.Len(s.bus.Publishers(), 2)
+	assert.Panics(func() {
+		s.bus.MustSubscriber(SetSubscriberName("subscriber-1"))
+	})
+}
+
+func (s *RegistryUnitSuite) TestTakenNameKeepsNoChannel() {
+	assert := s.Assert()
+	b := s.bus.(*bus)
+
+	s.bus.MustPublisher(SetPublisherName("taken"))
+	s.bus.MustSubscriber(SetSubscriberName("taken"), SetSubscriberQueue("queue"))
+	s.Require().Len(b.sessions, 2)
+
+	_, err := s.bus.NewPublisher(SetPublisherName("taken"))
+	assert.Error(err)
+	_, err = s.bus.NewSubscriber(SetSubscriberName("taken"), SetSubscriberQueue("queue"))
+	assert.Error(err)
+	assert.Len(b.sessions, 2)
+	for _, sess := range b.sessions {
+		assert.False(sess.Channel.IsClosed())
+	}
+}
+
+func (s *RegistryUnitSuite) TestCounters() {
+	assert := s.Assert()
+
+	pub := s.bus.MustPublisher(SetPublisherExchange("exchange"))
+	for i := 0; i < 3; i++ {
+		err, ok := pub.Publish(&Message{})
+		s.Require().NoError(err)
+		s.Require().True(ok)
+	}
+	info := pub.Info()
+	assert.Equal(StateActive, info.State)
+	assert.Equal(uint64(3), info.Published)
+	assert.Equal(uint64(0), info.Failed)
+
+	sub := s.bus.MustSubscriber(
+		SetSubscriberQueue("queue"),
+		SetSubscriberPrefetchCount(3),
+	)
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+
+	first, second, third := <-msgs, <-msgs, <-msgs
+	assert.NoError(first.Ack(false))
+	assert.NoError(second.Nack(false, false))
+
+	subs := s.bus.Subscribers()
+	s.Require().Len(subs, 1)
+	assert.Equal(StateActive, subs[0].State)
+	assert.Equal(3, subs[0].Prefetch)
+	assert.Equal(uint64(3), subs[0].Delivered)
+	assert.Equal(uint64(1), subs[0].Acked)
+	assert.Equal(uint64(1), subs[0].Nacked)
+	assert.Equal(1, subs[0].Unsettled)
+
+	assert.NoError(third.Ack(false))
+	assert.Equal(0, sub.Info().Unsettled)
+}
+
+func (s *RegistryUnitSuite) TestClosePublisher() {
+	assert := s.Assert()
+
+	pub := s.bus.MustPublisher(SetPublisherExchange("exchange"), SetPublisherName("closed"))
+	other := s.bus.MustPublisher(SetPublisherExchange("exchange"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(s.bus.ClosePublisher(ctx, "closed"))
+	assert.Error(s.bus.ClosePublisher(ctx, "closed"))
+
+	assert.Equal(StateClosed, pub.Info().State)
+	err, ok := pub.Publish(&Message{})
+	assert.Equal(ErrBusClosed, err)
+	assert.False(ok)
+
+	pubs := s.bus.Publishers()
+	s.Require().Len(pubs, 1)
+	assert.Equal(other.Info().Name, pubs[0].Name)
+	err, ok = other.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func (s *RegistryUnitSuite) TestCloseSubscriber() {
+	assert := s.Assert()
+
+	pub := s.bus.MustPublisher(SetPublisherExchange("exchange"))
+	for i := 0; i < 2; i++ {
+		err, ok := pub.Publish(&Message{})
+		s.Require().NoError(err)
+		s.Require().True(ok)
+	}
+
+	sub := s.bus.MustSubscriber(
+		SetSubscriberQueue("queue"),
+		SetSubscriberName("closed"),
+		SetSubscriberPrefetchCount(2),
+	)
+	msgs, closer, err := sub.Consume()
+	s.Require().NoError(err)
+	assert.NoError((<-msgs).Ack(false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(s.bus.CloseSubscriber(ctx, "closed"))
+	assert.Error(s.bus.CloseSubscriber(ctx, "closed"))
+
+	select {
+	case <-closer:
+	case <-time.After(time.Second):
+		s.Fail("subscriber not closed")
+	}
+	assert.Equal(StateClosed, sub.Info().State)
+	assert.Len(s.bus.Subscribers(), 0)
+
+	// the message it did not hand over is back in the queue
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(1, n)
+	n, _ = s.srv.Consumers("queue")
+	assert.Equal(0, n)
+
+	err, ok := pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func (s *RegistryUnitSuite) TestPauseSubscriber() {
+	assert := s.Assert()
+
+	sub := s.bus.MustSubscriber(SetSubscriberQueue("queue"), SetSubscriberName("paused"))
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+
+	assert.NoError(s.bus.PauseSubscriber("paused"))
+	assert.Equal(StatePaused, sub.Info().State)
+	assert.Error(s.bus.PauseSubscriber("unknown"))
+
+	err, ok := s.bus.MustPublisher(SetPublisherExchange("exchange")).Publish(&Message{})
+	s.Require().NoError(err)
+	s.Require().True(ok)
+	select {
+	case <-msgs:
+		s.Fail("message delivered while paused")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	assert.NoError(s.bus.ResumeSubscriber("paused"))
+	assert.Equal(StateActive, sub.Info().State)
+	assert.Error(s.bus.ResumeSubscriber("unknown"))
+	select {
+	case msg := <-msgs:
+		assert.NoError(msg.Ack(false))
+	case <-time.After(time.Second):
+		s.Fail("message not delivered once resumed")
+	}
+}
+
+func TestRegistryUnitSuite(t *testing.T) {
+	suite.Run(t, new(RegistryUnitSuite))
+}
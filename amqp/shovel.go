@@ -0,0 +1,260 @@
+package amqp
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+type DumpOptionsFn func(*DumpOptions)
+
+type DumpOptions struct {
+	format ArchiveFormat
+	limit  int
+	remove bool
+}
+
+func SetDumpFormat(format ArchiveFormat) DumpOptionsFn {
+	return func(o *DumpOptions) {
+		o.format = format
+	}
+}
+
+// SetDumpLimit stops the dump after limit messages, zero dumps the whole queue.
+func SetDumpLimit(limit int) DumpOptionsFn {
+	return func(o *DumpOptions) {
+		o.limit = limit
+	}
+}
+
+// SetDumpRemove acks the dumped messages once the archive is written, by
+// default they are requeued.
+func SetDumpRemove(remove bool) DumpOptionsFn {
+	return func(o *DumpOptions) {
+		o.remove = remove
+	}
+}
+
+// Dump writes the messages ready in the queue to an archive. The messages are
+// fetched without being acked, so each is read once, and are requeued or
+// removed at the end.
+func Dump(sess *Session, queue string, w io.Writer, fns ...DumpOptionsFn) (int, error) {
+	o := &DumpOptions{}
+	SetDumpFormat(ArchiveJSON)(o)
+	SetDumpLimit(0)(o)
+	SetDumpRemove(false)(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	// the gets are settled on a channel of their own, closing it requeues
+	// whatever is left unsettled
+	ch, err := sess.Connection.Channel()
+	if err != nil {
+		return 0, errors.Wrap(err, "Could not conn.Channel()")
+	}
+	defer ch.Close()
+
+	archive := NewArchiveWriter(w, o.format)
+
+	var last uint64
+	n := 0
+	for o.limit == 0 || n < o.limit {
+		d, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return n, errors.Wrap(err, "Could not channel.Get()")
+		}
+		if !ok {
+			break
+		}
+		last = d.DeliveryTag
+
+		if err := archive.Write(archived(d)); err != nil {
+			ch.Nack(last, true, true)
+			return n, err
+		}
+		n++
+	}
+
+	if err := archive.Flush(); err != nil {
+		ch.Nack(last, true, true)
+		return n, errors.Wrap(err, "Could not flush archive")
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	if o.remove {
+		return n, ch.Ack(last, true)
+	}
+	return n, ch.Nack(last, true, true)
+}
+
+type RestoreOptionsFn func(*RestoreOptions)
+
+type RestoreOptions struct {
+	format   ArchiveFormat
+	exchange *string
+	key      *string
+}
+
+func SetRestoreFormat(format ArchiveFormat) RestoreOptionsFn {
+	return func(o *RestoreOptions) {
+		o.format = format
+	}
+}
+
+// SetRestoreExchange publishes every message to the exchange instead of the
+// one it was archived from, empty is the default exchange.
+func SetRestoreExchange(exchange string) RestoreOptionsFn {
+	return func(o *RestoreOptions) {
+		o.exchange = &exchange
+	}
+}
+
+// SetRestoreKey publishes every message with the routing key instead of the
+// one it was archived with.
+func SetRestoreKey(key string) RestoreOptionsFn {
+	return func(o *RestoreOptions) {
+		o.key = &key
+	}
+}
+
+// Restore publishes the messages of an archive and waits for the server to
+// confirm each of them. It stops at the first message that is not routed or
+// confirmed and returns how many were restored before it.
+func Restore(sess *Session, r io.Reader, fns ...RestoreOptionsFn) (int, error) {
+	o := &RestoreOptions{}
+	SetRestoreFormat(ArchiveJSON)(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	c, err := newConfirmer(sess.Connection)
+	if err != nil {
+		return 0, err
+	}
+	defer c.close()
+
+	archive := NewArchiveReader(r, o.format)
+	n := 0
+	for {
+		m, err := archive.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		exchange, key := m.Exchange, m.RoutingKey
+		if o.exchange != nil {
+			exchange = *o.exchange
+		}
+		if o.key != nil {
+			key = *o.key
+		}
+
+		if err := c.publish(exchange, key, m.Publishing()); err != nil {
+			return n, errors.Wrapf(err, "Could not restore message %d", n+1)
+		}
+		n++
+	}
+}
+
+type MoveOptionsFn func(*MoveOptions)
+
+type MoveOptions struct {
+	limit  int
+	filter func(amqp.Delivery) bool
+}
+
+// SetMoveLimit stops after limit messages were moved, zero moves every
+// message.
+func SetMoveLimit(limit int) MoveOptionsFn {
+	return func(o *MoveOptions) {
+		o.limit = limit
+	}
+}
+
+// SetMoveFilter moves only the messages the filter accepts, the others are
+// left in the source queue.
+func SetMoveFilter(filter func(amqp.Delivery) bool) MoveOptionsFn {
+	return func(o *MoveOptions) {
+		o.filter = filter
+	}
+}
+
+// MatchHeaders is a move filter accepting the messages that have every header
+// of the table. Values are compared by their printed form, so 1 matches "1".
+func MatchHeaders(headers amqp.Table) func(amqp.Delivery) bool {
+	return func(d amqp.Delivery) bool {
+		for k, v := range headers {
+			hv, ok := d.Headers[k]
+			if !ok || fmt.Sprint(hv) != fmt.Sprint(v) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Move publishes the messages of the queue from to the queue to through the
+// default exchange. Each source message is acked only after the server
+// confirmed its copy, so a failure never loses a message, though it may leave
+// it in both queues.
+func Move(sess *Session, from, to string, fns ...MoveOptionsFn) (int, error) {
+	o := &MoveOptions{}
+	SetMoveLimit(0)(o)
+	SetMoveFilter(func(amqp.Delivery) bool { return true })(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	c, err := newConfirmer(sess.Connection)
+	if err != nil {
+		return 0, err
+	}
+	defer c.close()
+	ch := c.ch
+
+	// skipped messages stay unacked so the next get moves past them, they are
+	// requeued at the end
+	skipped := make([]uint64, 0)
+	defer func() {
+		for _, tag := range skipped {
+			ch.Nack(tag, false, true)
+		}
+	}()
+
+	n := 0
+	for o.limit == 0 || n < o.limit {
+		d, ok, err := ch.Get(from, false)
+		if err != nil {
+			return n, errors.Wrap(err, "Could not channel.Get()")
+		}
+		if !ok {
+			return n, nil
+		}
+
+		if !o.filter(d) {
+			skipped = append(skipped, d.DeliveryTag)
+			continue
+		}
+
+		if err := c.publish("", to, archived(d).Publishing()); err != nil {
+			d.Nack(false, true)
+			return n, errors.Wrapf(err, "Could not move message %d", n+1)
+		}
+
+		if err := d.Ack(false); err != nil {
+			return n, errors.Wrap(err, "Could not ack moved message")
+		}
+		n++
+	}
+
+	return n, nil
+}
@@ -0,0 +1,215 @@
+package amqp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/suite"
+)
+
+type ShovelUnitSuite struct {
+	suite.Suite
+	srv  *amqptest.Server
+	sess *Session
+	done chan struct{}
+}
+
+func (s *ShovelUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+	declareTopic(s.srv.URL, "exchange", "queue")
+	declareTopic(s.srv.URL, "other", "dead")
+
+	s.done = make(chan struct{})
+	conn := MustConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDone(s.done),
+	)
+	s.sess = MustSession(conn, SetChannelDone(s.done))
+}
+
+func (s *ShovelUnitSuite) TearDownTest() {
+	close(s.done)
+	s.srv.Close()
+}
+
+func (s *ShovelUnitSuite) publish(exchange string, headers amqp.Table, bodies ...string) {
+	for _, body := range bodies {
+		s.Require().NoError(s.sess.Channel.Publish(exchange, "", false, false, amqp.Publishing{
+			Headers:     headers,
+			ContentType: "text/plain",
+			MessageId:   body,
+			Timestamp:   time.Unix(1600000000, 0),
+			Body:        []byte(body),
+		}))
+	}
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("queue")
+		m, _ := s.srv.QueueLength("dead")
+		return n+m >= len(bodies)
+	}, time.Second)
+}
+
+func (s *ShovelUnitSuite) TestDumpRequeues() {
+	assert := s.Assert()
+	s.publish("exchange", amqp.Table{"key": "value", "int": int32(1)}, "body 1", "body 2")
+
+	buf := &bytes.Buffer{}
+	n, err := Dump(s.sess, "queue", buf)
+	assert.NoError(err)
+	assert.Equal(2, n)
+
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("queue")
+		return n == 2
+	}, time.Second)
+	n, _ = s.srv.QueueLength("queue")
+	assert.Equal(2, n)
+
+	archive := NewArchiveReader(buf, ArchiveJSON)
+	for _, body := range []string{"body 1", "body 2"} {
+		m, err := archive.Read()
+		assert.NoError(err)
+		assert.Equal(body, string(m.Body))
+		assert.Equal("exchange", m.Exchange)
+		assert.Equal("text/plain", m.ContentType)
+		assert.Equal(body, m.MessageId)
+		assert.Equal("value", m.Headers["key"])
+		assert.Equal(int64(1), m.Headers["int"])
+		assert.NoError(m.Headers.Validate())
+	}
+	_, err = archive.Read()
+	assert.Equal(io.EOF, err)
+}
+
+func (s *ShovelUnitSuite) TestDumpRemoveAndRestoreBinary() {
+	assert := s.Assert()
+	s.publish("exchange", amqp.Table{"int": int32(1), "nested": amqp.Table{"a": true}}, "body 1", "body 2", "body 3")
+
+	buf := &bytes.Buffer{}
+	n, err := Dump(s.sess, "queue", buf,
+		SetDumpFormat(ArchiveBinary),
+		SetDumpLimit(2),
+		SetDumpRemove(true),
+	)
+	assert.NoError(err)
+	assert.Equal(2, n)
+
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("queue")
+		return n == 1
+	}, time.Second)
+	n, _ = s.srv.QueueLength("queue")
+	assert.Equal(1, n)
+
+	n, err = Restore(s.sess, buf,
+		SetRestoreFormat(ArchiveBinary),
+		SetRestoreExchange("other"),
+	)
+	assert.NoError(err)
+	assert.Equal(2, n)
+
+	d, ok, err := s.sess.Channel.Get("dead", true)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal("body 1", string(d.Body))
+	assert.Equal("other", d.Exchange)
+	assert.Equal(int32(1), d.Headers["int"])
+	assert.Equal(amqp.Table{"a": true}, d.Headers["nested"])
+	assert.True(time.Unix(1600000000, 0).Equal(d.Timestamp))
+}
+
+func (s *ShovelUnitSuite) TestRestoreUnroutable() {
+	assert := s.Assert()
+
+	buf := &bytes.Buffer{}
+	archive := NewArchiveWriter(buf, ArchiveJSON)
+	assert.NoError(archive.Write(&ArchivedMessage{Exchange: "exchange", Body: []byte("body 1")}))
+	assert.NoError(archive.Write(&ArchivedMessage{Exchange: "amq.direct", Body: []byte("body 2")}))
+	assert.NoError(archive.Flush())
+
+	n, err := Restore(s.sess, buf)
+	assert.Error(err)
+	assert.Equal(1, n)
+}
+
+func (s *ShovelUnitSuite) TestRestoreAndMoveTwice() {
+	assert := s.Assert()
+
+	for i := 0; i < 2; i++ {
+		buf := &bytes.Buffer{}
+		archive := NewArchiveWriter(buf, ArchiveJSON)
+		assert.NoError(archive.Write(&ArchivedMessage{Exchange: "exchange", Body: []byte("body 1")}))
+		assert.NoError(archive.Write(&ArchivedMessage{Exchange: "exchange", Body: []byte("body 2")}))
+		assert.NoError(archive.Flush())
+
+		n, err := Restore(s.sess, buf)
+		assert.NoError(err)
+		assert.Equal(2, n)
+	}
+
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("queue")
+		return n == 4
+	}, time.Second)
+
+	for i := 0; i < 2; i++ {
+		n, err := Move(s.sess, "queue", "dead", SetMoveLimit(2))
+		assert.NoError(err)
+		assert.Equal(2, n)
+	}
+
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("dead")
+		return n == 4
+	}, time.Second)
+	n, _ := s.srv.QueueLength("dead")
+	assert.Equal(4, n)
+}
+
+func (s *ShovelUnitSuite) TestMoveWithFilter() {
+	assert := s.Assert()
+	s.publish("exchange", amqp.Table{"kind": "a"}, "body 1", "body 2")
+	s.publish("exchange", amqp.Table{"kind": "b"}, "body 3")
+
+	n, err := Move(s.sess, "queue", "dead", SetMoveFilter(MatchHeaders(amqp.Table{"kind": "a"})))
+	assert.NoError(err)
+	assert.Equal(2, n)
+
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("queue")
+		return n == 1
+	}, time.Second)
+	n, _ = s.srv.QueueLength("queue")
+	assert.Equal(1, n)
+	n, _ = s.srv.QueueLength("dead")
+	assert.Equal(2, n)
+
+	d, ok, err := s.sess.Channel.Get("queue", true)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal("body 3", string(d.Body))
+}
+
+func (s *ShovelUnitSuite) TestMoveToMissingQueueKeepsSource() {
+	assert := s.Assert()
+	s.publish("exchange", nil, "body 1")
+
+	n, err := Move(s.sess, "queue", "missing")
+	assert.Error(err)
+	assert.Equal(0, n)
+
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("queue")
+		return n == 1
+	}, time.Second)
+	n, _ = s.srv.QueueLength("queue")
+	assert.Equal(1, n)
+}
+
+func TestShovelUnitSuite(t *testing.T) {
+	suite.Run(t, new(ShovelUnitSuite))
+}
@@ -0,0 +1,38 @@
+package amqp
+
+import (
+	"time"
+)
+
+// StreamOffset is where a consumer of a stream starts reading.
+type StreamOffset struct {
+	value interface{}
+}
+
+var (
+	// StreamOffsetFirst reads the stream from its first message still kept.
+	StreamOffsetFirst = StreamOffset{"first"}
+	// StreamOffsetLast reads the stream from its last chunk of messages.
+	StreamOffsetLast = StreamOffset{"last"}
+	// StreamOffsetNext reads the messages published after the consumer started,
+	// the default.
+	StreamOffsetNext = StreamOffset{"next"}
+)
+
+// StreamOffsetAt reads the stream from the first message published at or after
+// the time, rounded to seconds.
+func StreamOffsetAt(t time.Time) StreamOffset {
+	return StreamOffset{t}
+}
+
+// StreamOffsetOf reads the stream from the message at the offset, as returned
+// by Message.GetStreamOffset.
+func StreamOffsetOf(offset int64) StreamOffset {
+	return StreamOffset{offset}
+}
+
+// SetSubscriberStreamOffset sets where the subscriber of a stream starts
+// reading.
+func SetSubscriberStreamOffset(offset StreamOffset) SubscriberOptionsFn {
+	return SetSubscriberArg("x-stream-offset", offset.value)
+}
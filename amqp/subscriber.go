@@ -1,15 +1,23 @@
 package amqp
 
 import (
+	"context"
+	"log"
 	"sync"
+	"sync/atomic"
 
 	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
 	"github.com/streadway/amqp"
 )
 
 type SubscriberOptionsFn func(*SubscriberOptions)
 
 type SubscriberOptions struct {
+	name   string
+	target string
+
 	deliveries chan base.Message
 
 	queue    string
@@ -22,16 +30,114 @@ type SubscriberOptions struct {
 
 	args amqp.Table
 
+	prefetchCount int
+
+	topology      func(*Session) error
+	cancellations chan<- Cancellation
+
 	close <-chan struct{}
 	wg    *sync.WaitGroup
 }
 
+// SetSubscriberName names the subscriber in the bus, names are unique to a bus.
+func SetSubscriberName(name string) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.name = name
+	}
+}
+
 func SetSubscriberQueue(queue string) SubscriberOptionsFn {
 	return func(o *SubscriberOptions) {
 		o.queue = queue
 	}
 }
 
+// SetSubscriberConsumer sets the consumer tag, unique to the connection. A tag
+// is generated by default.
+func SetSubscriberConsumer(consumer string) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.consumer = consumer
+	}
+}
+
+// SetSubscriberAutoAck makes the server consider the messages acked once
+// delivered, they are lost if the subscriber fails to handle them.
+func SetSubscriberAutoAck(autoAck bool) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.autoAck = autoAck
+	}
+}
+
+// SetSubscriberExclusive makes the subscriber the only consumer of the queue.
+func SetSubscriberExclusive(exclusive bool) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.exclusive = exclusive
+	}
+}
+
+// SetSubscriberNoLocal is not supported by RabbitMQ, it is kept for other
+// brokers.
+func SetSubscriberNoLocal(noLocal bool) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.noLocal = noLocal
+	}
+}
+
+func SetSubscriberNoWait(noWait bool) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.noWait = noWait
+	}
+}
+
+// SetSubscriberArgs replaces the consumer arguments.
+func SetSubscriberArgs(args amqp.Table) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.args = amqp.Table{}
+		for k, v := range args {
+			o.args[k] = v
+		}
+	}
+}
+
+// SetSubscriberArg sets a consumer argument the other options do not cover.
+func SetSubscriberArg(key string, value interface{}) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.args[key] = value
+	}
+}
+
+// SetSubscriberPriority makes the queue deliver to the subscriber before the
+// consumers of lower priority, as long as it has room for the messages.
+func SetSubscriberPriority(priority int) SubscriberOptionsFn {
+	return SetSubscriberArg("x-priority", int32(priority))
+}
+
+// SetSubscriberPrefetchCount sets how many unacked messages the server hands
+// the subscriber, in place of the prefetch count of its session. It applies to
+// every consumer the session starts afterwards.
+func SetSubscriberPrefetchCount(count int) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.prefetchCount = count
+	}
+}
+
+// SetSubscriberTopology sets how to declare the queue and its bindings again
+// when the server canceled the subscriber, as it does when the queue is
+// deleted. Without it the subscriber gives up once the queue is gone.
+func SetSubscriberTopology(declare func(*Session) error) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.topology = declare
+	}
+}
+
+// SetSubscriberCancellations sets a channel receiving the cancellations of the
+// subscriber by the server, they are dropped when it is full.
+func SetSubscriberCancellations(cancellations chan<- Cancellation) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.cancellations = cancellations
+	}
+}
+
 func SetSubscriberDeliveries(deliveries chan base.Message) SubscriberOptionsFn {
 	return func(o *SubscriberOptions) {
 		o.deliveries = deliveries
@@ -50,13 +156,60 @@ func SetSubscriberWaitGroup(wg *sync.WaitGroup) SubscriberOptionsFn {
 	}
 }
 
+// Cancellation tells the server canceled a subscriber. Err is set when the
+// subscriber could not consume the queue again and stopped.
+type Cancellation struct {
+	Queue    string
+	Consumer string
+	Err      error
+}
+
 type Subscriber interface {
 	base.Subscriber
+
+	// Consumer returns the consumer tag of the subscriber.
+	Consumer() string
+	// Cancel stops the server from delivering to the subscriber, the messages
+	// already delivered can still be acked.
+	Cancel() error
+	// Err returns why the subscriber stopped consuming the queue, the closer
+	// returned by Consume is closed then.
+	Err() error
+	// Drain cancels the subscriber and waits for the messages it handed over
+	// to be settled, the others are requeued.
+	Drain(ctx context.Context) error
+	// Pause cancels the subscriber and requeues the messages it did not hand
+	// over yet, the channel of Consume stays open and the messages handed over
+	// can still be settled. Resume consumes the queue again.
+	Pause() error
+	Resume() error
+	// Info describes the subscriber.
+	Info() SubscriberInfo
 }
 
 type sub struct {
 	*SubscriberOptions
 	*Session
+
+	// toggle serializes Pause and Resume
+	toggle sync.Mutex
+
+	mu        sync.Mutex
+	err       error
+	consuming bool
+	paused    bool
+	pausing   chan struct{}
+	forwarded chan struct{}
+	inflight  map[uint64]bool
+	settles   chan struct{}
+	stopped   chan struct{}
+	stop      sync.Once
+	draining  chan struct{}
+	drain     sync.Once
+
+	delivered uint64
+	acked     uint64
+	nacked    uint64
 }
 
 func MustSubscriber(sess *Session, fns ...SubscriberOptionsFn) Subscriber {
@@ -68,7 +221,8 @@ func MustSubscriber(sess *Session, fns ...SubscriberOptionsFn) Subscriber {
 }
 
 func NewSubscriber(sess *Session, fns ...SubscriberOptionsFn) (Subscriber, error) {
-	o := &SubscriberOptions{}
+	o := &SubscriberOptions{args: amqp.Table{}}
+	SetSubscriberConsumer("ctag-" + uuid.NewV4().String())(o)
 	SetSubscriberDeliveries(make(chan base.Message, 1))(o)
 	SetSubscriberWaitGroup(&sync.WaitGroup{})(o)
 	for _, fn := range fns {
@@ -77,40 +231,402 @@ func NewSubscriber(sess *Session, fns ...SubscriberOptionsFn) (Subscriber, error
 	return &sub{
 		Session:           sess,
 		SubscriberOptions: o,
+		inflight:          make(map[uint64]bool),
+		settles:           make(chan struct{}, 1),
+		stopped:           make(chan struct{}),
+		draining:          make(chan struct{}),
 	}, nil
 }
 
 func (s *sub) Consume() (<-chan base.Message, <-chan struct{}, error) {
 	//TODO: garantee redeliveries
-	deliveries, err := s.Channel.Consume(s.queue, s.consumer, s.autoAck, s.exclusive, s.noLocal, s.noWait, s.args)
-	if err != nil {
+	if s.prefetchCount > 0 {
+		if err := s.Channel.SetPrefetch(s.prefetchCount, s.Channel.prefetchSize); err != nil {
+			return s.deliveries, s.close, err
+		}
+	}
+	if err := s.consume(); err != nil {
 		return s.deliveries, s.close, err
 	}
 
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		done := false
-	out:
-		for !done {
+		select {
+		case <-s.close:
+			s.stop.Do(func() { close(s.stopped) })
+		case <-s.stopped:
+		}
+	}()
+
+	return s.deliveries, s.stopped, nil
+}
+
+// consume starts the consumer and the goroutine forwarding its deliveries.
+func (s *sub) consume() error {
+	s.Channel.OnCancel(s.consumer, s.canceled)
+	deliveries, err := s.Channel.Consume(s.queue, s.consumer, s.autoAck, s.exclusive, s.noLocal, s.noWait, s.args)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.consuming = true
+	s.paused = false
+	s.pausing = make(chan struct{})
+	s.forwarded = make(chan struct{})
+	pausing, forwarded := s.pausing, s.forwarded
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.forward(deliveries, pausing, forwarded)
+	return nil
+}
+
+// forward hands the deliveries over until the subscriber is closed or stops
+// consuming. Once draining or pausing it requeues them instead, draining
+// requeues those it handed over that were not received yet as well.
+func (s *sub) forward(deliveries <-chan amqp.Delivery, pausing <-chan struct{}, forwarded chan<- struct{}) {
+	defer s.wg.Done()
+	defer close(forwarded)
+
+	draining := s.draining
+	handing := true
+	for {
+		select {
+		case <-s.close:
+			return
+		case <-s.stopped:
+			return
+		case <-draining:
+			draining = nil
+			handing = false
+			s.requeueHandedOver()
+		case <-pausing:
+			pausing = nil
+			handing = false
+		case dlv, ok := <-deliveries:
+			if !ok {
+				if s.Err() != nil {
+					s.stop.Do(func() { close(s.stopped) })
+				}
+				return
+			}
+			msg := newMessage(&dlv)
+			if !s.autoAck {
+				msg.settled = s.settled
+			}
+			if !handing {
+				s.requeue(msg)
+				continue
+			}
+			if !s.autoAck {
+				s.handing(dlv.DeliveryTag)
+			}
+			atomic.AddUint64(&s.delivered, 1)
 			select {
+			case s.deliveries <- msg:
 			case <-s.close:
-				done = true
-				break out
-			case dlv := <-deliveries:
-				msg := &Message{
-					Delivery: &dlv,
-					Body:     dlv.Body,
-					Headers:  dlv.Headers,
-				}
-				s.deliveries <- msg
+				return
+			case <-s.stopped:
+				return
+			case <-draining:
+				draining = nil
+				handing = false
+				s.requeue(msg)
+				s.requeueHandedOver()
+			case <-pausing:
+				pausing = nil
+				handing = false
+				s.requeue(msg)
 			}
 		}
-	}()
+	}
+}
 
-	return s.deliveries, s.close, nil
+func (s *sub) handing(tag uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight[tag] = true
 }
 
+// settled forgets the messages a message settled and counts them.
+func (s *sub) settled(tag uint64, multiple, acked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.inflight)
+	if multiple {
+		for t := range s.inflight {
+			if t <= tag {
+				delete(s.inflight, t)
+			}
+		}
+	} else {
+		delete(s.inflight, tag)
+	}
+	if acked {
+		atomic.AddUint64(&s.acked, uint64(n-len(s.inflight)))
+	} else {
+		atomic.AddUint64(&s.nacked, uint64(n-len(s.inflight)))
+	}
+
+	select {
+	case s.settles <- struct{}{}:
+	default:
+	}
+}
+
+func (s *sub) unsettled() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.inflight)
+}
+
+// requeueHandedOver requeues the messages waiting in the deliveries, nothing
+// handles them.
+func (s *sub) requeueHandedOver() {
+	for {
+		select {
+		case msg, ok := <-s.deliveries:
+			if !ok {
+				return
+			}
+			s.requeue(msg)
+		default:
+			return
+		}
+	}
+}
+
+func (s *sub) requeue(msg base.Message) {
+	if s.autoAck {
+		return
+	}
+	if err := msg.Nack(false, true); err != nil {
+		log.Printf("requeue failed, err: %v\n", err)
+	}
+}
+
+// Drain cancels the subscriber, requeues the messages delivered that were not
+// handed over yet and waits for those handed over to be settled, until the
+// context is done.
+func (s *sub) Drain(ctx context.Context) error {
+	s.mu.Lock()
+	consuming, paused, forwarded := s.consuming, s.paused, s.forwarded
+	s.mu.Unlock()
+	if !consuming {
+		return nil
+	}
+	// closed on every return so the handlers and later calls waiting on it
+	// are never left behind
+	defer s.drain.Do(func() { close(s.draining) })
+
+	if !paused {
+		if err := s.Cancel(); err != nil {
+			return err
+		}
+	}
+	s.drain.Do(func() { close(s.draining) })
+
+	select {
+	case <-forwarded:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "Could not drain subscriber")
+	}
+	if paused {
+		s.requeueHandedOver()
+	}
+	for s.unsettled() > 0 {
+		select {
+		case <-s.settles:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "Could not drain subscriber")
+		}
+	}
+	return nil
+}
+
+// canceled declares the topology again after the server canceled the
+// subscriber, or checks the queue still exists when there is none.
+func (s *sub) canceled() error {
+	err := s.redeclare()
+	if err != nil {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+	}
+
+	if s.cancellations != nil {
+		select {
+		case s.cancellations <- Cancellation{Queue: s.queue, Consumer: s.consumer, Err: err}:
+		default:
+		}
+	}
+	return err
+}
+
+func (s *sub) redeclare() error {
+	if s.topology != nil {
+		if err := s.topology(s.Session); err != nil {
+			return errors.Wrap(err, "Could not declare topology")
+		}
+		return nil
+	}
+
+	ch, err := s.Session.Connection.Channel()
+	if err != nil {
+		// the channel closes as well, it consumes again once reconnected
+		return nil
+	}
+	defer ch.Close()
+
+	_, err = ch.QueueDeclarePassive(s.queue, false, false, false, false, nil)
+	if aerr, ok := err.(*amqp.Error); ok && aerr.Code == amqp.NotFound {
+		return errors.Wrapf(QueueNotFoundError, "Could not consume queue %s", s.queue)
+	}
+	return nil
+}
+
+// Pause cancels the subscriber and waits for it to requeue the messages the
+// server delivered that it did not hand over yet.
+func (s *sub) Pause() error {
+	s.toggle.Lock()
+	defer s.toggle.Unlock()
+
+	s.mu.Lock()
+	if !s.consuming || s.closing() {
+		s.mu.Unlock()
+		return errors.New("Could not pause subscriber, it is not consuming")
+	}
+	if s.paused {
+		s.mu.Unlock()
+		return nil
+	}
+	s.paused = true
+	pausing, forwarded := s.pausing, s.forwarded
+	s.mu.Unlock()
+
+	close(pausing)
+	if err := s.Cancel(); err != nil {
+		return err
+	}
+	<-forwarded
+	return nil
+}
+
+// Resume consumes the queue again with the same consumer tag.
+func (s *sub) Resume() error {
+	s.toggle.Lock()
+	defer s.toggle.Unlock()
+
+	s.mu.Lock()
+	if s.closing() {
+		s.mu.Unlock()
+		return errors.New("Could not resume subscriber, it is closed")
+	}
+	if !s.paused {
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	return s.consume()
+}
+
+// closing tells the subscriber is draining or stopped, the lock is held.
+func (s *sub) closing() bool {
+	select {
+	case <-s.draining:
+		return true
+	case <-s.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *sub) Consumer() string {
+	return s.consumer
+}
+
+func (s *sub) Cancel() error {
+	if err := s.Channel.Cancel(s.consumer, s.noWait); err != nil {
+		return errors.Wrap(err, "Could not channel.Cancel()")
+	}
+	return nil
+}
+
+func (s *sub) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *sub) Info() SubscriberInfo {
+	prefetch := s.prefetchCount
+	if prefetch == 0 {
+		prefetch = s.Channel.prefetchCount
+	}
+	return SubscriberInfo{
+		Name:      s.name,
+		Target:    s.target,
+		Queue:     s.queue,
+		Consumer:  s.consumer,
+		Prefetch:  prefetch,
+		AutoAck:   s.autoAck,
+		Exclusive: s.exclusive,
+		State:     s.state(),
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Acked:     atomic.LoadUint64(&s.acked),
+		Nacked:    atomic.LoadUint64(&s.nacked),
+		Unsettled: s.unsettled(),
+	}
+}
+
+func (s *sub) state() State {
+	if s.Err() != nil {
+		return StateFailed
+	}
+	select {
+	case <-s.stopped:
+		return StateClosed
+	default:
+	}
+	select {
+	case <-s.draining:
+		return StateDraining
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused {
+		return StatePaused
+	}
+	if s.consuming {
+		return StateActive
+	}
+	return StateIdle
+}
+
+// shutdown stops the subscriber and closes its channel, the messages it
+// handed over can no longer be settled.
+func (s *sub) shutdown() {
+	s.stop.Do(func() { close(s.stopped) })
+	s.Session.Channel.Close()
+}
+
+// Close stops the subscriber and closes the channel of Consume once nothing
+// forwards to it anymore.
 func (s *sub) Close() {
+	s.stop.Do(func() { close(s.stopped) })
+
+	s.mu.Lock()
+	forwarded := s.forwarded
+	s.mu.Unlock()
+	if forwarded != nil {
+		<-forwarded
+	}
 	close(s.deliveries)
 }
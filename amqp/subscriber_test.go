@@ -1,9 +1,15 @@
 package amqp
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 	toxi "github.com/shopify/toxiproxy/client"
 	"github.com/stretchr/testify/suite"
@@ -220,3 +226,353 @@ func (s *SubscriberIntegrationSuite) TestConsumeOnNetworkFailureWhileMessageIsIn
 func TestSubscriberIntegrationSuite(t *testing.T) {
 	suite.Run(t, new(SubscriberIntegrationSuite))
 }
+
+type SubscriberUnitSuite struct {
+	suite.Suite
+	srv  *amqptest.Server
+	done chan struct{}
+	pub  Publisher
+}
+
+func (s *SubscriberUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+	s.done = make(chan struct{})
+
+	sess := s.session()
+	_, err := DeclareQueue(sess, "queue")
+	s.Require().NoError(err)
+	s.pub = MustPublisher(sess, SetPublisherKey("queue"), SetPublisherClose(s.done))
+}
+
+func (s *SubscriberUnitSuite) TearDownTest() {
+	close(s.done)
+	s.srv.Close()
+}
+
+func (s *SubscriberUnitSuite) session() *Session {
+	conn := MustConnection(SetConnectionDSN(s.srv.URL), SetConnectionDone(s.done))
+	return MustSession(conn, SetChannelDelay(time.Millisecond*10), SetChannelDone(s.done))
+}
+
+func (s *SubscriberUnitSuite) subscriber(fns ...SubscriberOptionsFn) (Subscriber, <-chan base.Message) {
+	sub := MustSubscriber(s.session(), append(fns,
+		SetSubscriberQueue("queue"),
+		SetSubscriberClose(s.done),
+	)...)
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+	return sub, msgs
+}
+
+func (s *SubscriberUnitSuite) publish(n int) {
+	for i := 0; i < n; i++ {
+		err, ok := s.pub.Publish(&Message{Body: []byte(fmt.Sprint(i))})
+		s.Require().NoError(err)
+		s.Require().True(ok)
+	}
+}
+
+func (s *SubscriberUnitSuite) consumers() int {
+	n, _ := s.srv.Consumers("queue")
+	return n
+}
+
+func (s *SubscriberUnitSuite) TestConsumerTag() {
+	assert := s.Assert()
+
+	a, _ := s.subscriber()
+	b, _ := s.subscriber()
+	c, _ := s.subscriber(SetSubscriberConsumer("tag"))
+
+	assert.NotEqual(a.Consumer(), b.Consumer())
+	assert.Equal("tag", c.Consumer())
+	waitToBeTrue(func() bool { return s.consumers() == 3 }, time.Second)
+	assert.Equal(3, s.consumers())
+}
+
+func (s *SubscriberUnitSuite) TestDeliveryInfo() {
+	assert := s.Assert()
+
+	err, ok := s.pub.Publish(&Message{MessageId: "id"})
+	s.Require().NoError(err)
+	s.Require().True(ok)
+
+	_, msgs := s.subscriber(SetSubscriberConsumer("tag"))
+	msg := <-msgs
+	info := msg.DeliveryInfo()
+	assert.Equal("id", info.MessageId)
+	assert.Equal("", info.Exchange)
+	assert.Equal("queue", info.RoutingKey)
+	assert.Equal("tag", info.ConsumerTag)
+	assert.False(info.Timestamp.IsZero())
+	assert.False(info.Redelivered)
+
+	s.Require().NoError(msg.Nack(false, true))
+	msg = <-msgs
+	assert.True(msg.DeliveryInfo().Redelivered)
+	assert.Equal("id", msg.DeliveryInfo().MessageId)
+
+	assert.Equal(base.DeliveryInfo{MessageId: "id"}, (&Message{MessageId: "id"}).DeliveryInfo())
+}
+
+func (s *SubscriberUnitSuite) TestDrainCancelFailed() {
+	assert := s.Assert()
+
+	subscriber, _ := s.subscriber()
+	s.Require().NoError(subscriber.(*sub).Session.Channel.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Error(subscriber.Drain(ctx))
+
+	select {
+	case <-subscriber.(*sub).draining:
+	default:
+		s.Fail("draining not closed")
+	}
+	assert.Equal(StateDraining, subscriber.Info().State)
+}
+
+func (s *SubscriberUnitSuite) TestCloseWhileForwarding() {
+	assert := s.Assert()
+
+	s.publish(3)
+	subscriber, msgs := s.subscriber(SetSubscriberPrefetchCount(3))
+	waitToBeTrue(func() bool { return len(msgs) == 1 }, time.Second)
+	s.Require().NoError(subscriber.Pause())
+
+	subscriber.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(subscriber.Drain(ctx))
+
+	_, ok := <-msgs
+	assert.False(ok)
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("queue")
+		return n == 3
+	}, time.Second)
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(3, n)
+}
+
+func (s *SubscriberUnitSuite) TestCancel() {
+	assert := s.Assert()
+
+	sub, msgs := s.subscriber(SetSubscriberDeliveries(make(chan base.Message, 10)))
+	s.publish(1)
+	msg := <-msgs
+
+	s.Require().NoError(sub.Cancel())
+	assert.Equal(0, s.consumers())
+
+	// delivered messages can still be acked, and the queue is not consumed
+	// again
+	assert.NoError(msg.Ack(false))
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(0, s.consumers())
+}
+
+func (s *SubscriberUnitSuite) TestPauseResume() {
+	assert := s.Assert()
+
+	s.publish(5)
+	sub, msgs := s.subscriber(SetSubscriberPrefetchCount(3))
+	msg := <-msgs
+
+	s.Require().NoError(sub.Pause())
+	assert.Equal(0, s.consumers())
+	assert.Equal(StatePaused, sub.Info().State)
+
+	// the message handed over can still be acked, the prefetched ones not
+	// handed over are back in the queue
+	assert.NoError(msg.Ack(false))
+	received := map[string]bool{string(msg.GetBody()): true}
+	for len(msgs) > 0 {
+		msg := <-msgs
+		received[string(msg.GetBody())] = true
+		assert.NoError(msg.Ack(false))
+	}
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(5-len(received), n)
+
+	time.Sleep(time.Millisecond * 50)
+	assert.Len(msgs, 0)
+
+	s.Require().NoError(sub.Resume())
+	assert.Equal(StateActive, sub.Info().State)
+	waitToBeTrue(func() bool { return s.consumers() == 1 }, time.Second)
+	for len(received) < 5 {
+		select {
+		case msg, ok := <-msgs:
+			s.Require().True(ok)
+			received[string(msg.GetBody())] = true
+			assert.NoError(msg.Ack(false))
+		case <-time.After(time.Second):
+			s.FailNow("no message received")
+		}
+	}
+	assert.Equal(uint64(5), sub.Info().Acked)
+}
+
+func (s *SubscriberUnitSuite) TestConcurrentResumes() {
+	assert := s.Assert()
+
+	subscriber, msgs := s.subscriber()
+	s.Require().NoError(subscriber.Pause())
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(subscriber.Resume())
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(StateActive, subscriber.Info().State)
+	waitToBeTrue(func() bool { return s.consumers() == 1 }, time.Second)
+	assert.Equal(1, s.consumers())
+
+	s.publish(1)
+	select {
+	case msg := <-msgs:
+		assert.NoError(msg.Ack(false))
+	case <-time.After(time.Second):
+		s.Fail("no message received")
+	}
+}
+
+func (s *SubscriberUnitSuite) TestPauseCancelFailed() {
+	assert := s.Assert()
+
+	subscriber, _ := s.subscriber()
+	s.Require().NoError(subscriber.(*sub).Session.Channel.Close())
+
+	paused := make(chan error, 1)
+	go func() { paused <- subscriber.Pause() }()
+	select {
+	case err := <-paused:
+		assert.Error(err)
+	case <-time.After(time.Second):
+		s.Fail("pause waited after the cancel failed")
+	}
+}
+
+func (s *SubscriberUnitSuite) TestPrefetchCount() {
+	assert := s.Assert()
+
+	s.publish(5)
+	_, msgs := s.subscriber(
+		SetSubscriberPrefetchCount(3),
+		SetSubscriberDeliveries(make(chan base.Message, 10)),
+	)
+
+	waitToBeTrue(func() bool { return len(msgs) == 3 }, time.Second)
+	assert.Len(msgs, 3)
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(2, n)
+}
+
+func (s *SubscriberUnitSuite) TestPriority() {
+	assert := s.Assert()
+
+	_, low := s.subscriber(SetSubscriberDeliveries(make(chan base.Message, 10)))
+	_, high := s.subscriber(
+		SetSubscriberPriority(10),
+		SetSubscriberPrefetchCount(10),
+		SetSubscriberDeliveries(make(chan base.Message, 10)),
+	)
+	waitToBeTrue(func() bool { return s.consumers() == 2 }, time.Second)
+
+	s.publish(3)
+	waitToBeTrue(func() bool { return len(high) == 3 }, time.Second)
+	assert.Len(high, 3)
+	assert.Len(low, 0)
+}
+
+func (s *SubscriberUnitSuite) TestAutoAck() {
+	assert := s.Assert()
+
+	s.publish(2)
+	_, msgs := s.subscriber(
+		SetSubscriberAutoAck(true),
+		SetSubscriberDeliveries(make(chan base.Message, 10)),
+	)
+
+	// the prefetch count does not bound the messages of auto-ack subscribers
+	waitToBeTrue(func() bool { return len(msgs) == 2 }, time.Second)
+	assert.Len(msgs, 2)
+}
+
+func (s *SubscriberUnitSuite) cancellation(cancellations <-chan Cancellation) Cancellation {
+	select {
+	case c := <-cancellations:
+		return c
+	case <-time.After(time.Second):
+		s.FailNow("no cancellation received")
+		return Cancellation{}
+	}
+}
+
+func (s *SubscriberUnitSuite) TestQueueDeleted() {
+	assert := s.Assert()
+
+	cancellations := make(chan Cancellation, 1)
+	sub := MustSubscriber(s.session(),
+		SetSubscriberQueue("queue"),
+		SetSubscriberCancellations(cancellations),
+		SetSubscriberClose(s.done),
+	)
+	_, closer, err := sub.Consume()
+	s.Require().NoError(err)
+	waitToBeTrue(func() bool { return s.consumers() == 1 }, time.Second)
+
+	assert.True(s.srv.DeleteQueue("queue"))
+
+	c := s.cancellation(cancellations)
+	assert.Equal("queue", c.Queue)
+	assert.Equal(sub.Consumer(), c.Consumer)
+	assert.Equal(QueueNotFoundError, errors.Cause(c.Err))
+
+	select {
+	case <-closer:
+	case <-time.After(time.Second):
+		s.FailNow("subscriber did not stop")
+	}
+	assert.Equal(QueueNotFoundError, errors.Cause(sub.Err()))
+}
+
+func (s *SubscriberUnitSuite) TestQueueDeletedWithTopology() {
+	assert := s.Assert()
+
+	cancellations := make(chan Cancellation, 1)
+	sub, msgs := s.subscriber(
+		SetSubscriberTopology(func(sess *Session) error {
+			_, err := DeclareQueue(sess, "queue")
+			return err
+		}),
+		SetSubscriberCancellations(cancellations),
+	)
+	waitToBeTrue(func() bool { return s.consumers() == 1 }, time.Second)
+
+	assert.True(s.srv.DeleteQueue("queue"))
+
+	c := s.cancellation(cancellations)
+	assert.NoError(c.Err)
+	assert.NoError(sub.Err())
+
+	waitToBeTrue(func() bool { return s.consumers() == 1 }, time.Second)
+	s.publish(1)
+	select {
+	case msg := <-msgs:
+		assert.Equal("0", string(msg.GetBody()))
+	case <-time.After(time.Second):
+		s.FailNow("no message received")
+	}
+}
+
+func TestSubscriberUnitSuite(t *testing.T) {
+	suite.Run(t, new(SubscriberUnitSuite))
+}
@@ -0,0 +1,87 @@
+package amqp
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// target is a broker or vhost of a bus with connections of its own, the
+// default target is the one of SetBusDSN and has no name.
+type target struct {
+	name string
+	dsn  string
+
+	mu       sync.Mutex
+	pubconns []*Connection
+	subconns []*Connection
+	pools    []*Pool
+	pubnext  int
+	subnext  int
+}
+
+func newTarget(name, dsn string, connections int) *target {
+	return &target{
+		name:     name,
+		dsn:      dsn,
+		pubconns: make([]*Connection, connections),
+		subconns: make([]*Connection, connections),
+		pools:    make([]*Pool, connections),
+	}
+}
+
+// SetBusTarget adds a named target to the bus, a broker or vhost its
+// publishers and subscribers choose with SetPublisherTarget and
+// SetSubscriberTarget. The bus connects to a target once the first of them is
+// created, its targets share its wait group and shutdown.
+func SetBusTarget(name, dsn string) BusOptionsFn {
+	return func(o *BusOptions) {
+		o.targets[name] = dsn
+	}
+}
+
+// SetPublisherTarget sets the target of the bus the publisher publishes to,
+// the default one unless set.
+func SetPublisherTarget(name string) PublisherOptionsFn {
+	return func(o *PublisherOptions) {
+		o.target = name
+	}
+}
+
+// SetSubscriberTarget sets the target of the bus the subscriber consumes
+// from, the default one unless set.
+func SetSubscriberTarget(name string) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.target = name
+	}
+}
+
+func (b *bus) target(name string) (*target, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.targets[name]
+	if !ok {
+		return nil, errors.Errorf("target %s is unknown", name)
+	}
+	return t, nil
+}
+
+// publisherTarget returns the target the options set.
+func publisherTarget(fns []PublisherOptionsFn) string {
+	o := &PublisherOptions{}
+	for _, fn := range fns {
+		fn(o)
+	}
+	return o.target
+}
+
+// subscriberTarget returns the target the options set.
+func subscriberTarget(fns []SubscriberOptionsFn) string {
+	o := &SubscriberOptions{args: amqp.Table{}}
+	for _, fn := range fns {
+		fn(o)
+	}
+	return o.target
+}
@@ -0,0 +1,116 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/stretchr/testify/suite"
+)
+
+type TargetUnitSuite struct {
+	suite.Suite
+	srv   *amqptest.Server
+	other *amqptest.Server
+	bus   Bus
+}
+
+func (s *TargetUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+	s.other = amqptest.NewServer()
+	declareTopic(s.srv.URL, "exchange", "queue")
+	declareTopic(s.other.URL, "exchange", "queue")
+
+	s.bus = MustBus(
+		SetBusDSN(s.srv.URL),
+		SetBusTarget("other", s.other.URL),
+	)
+}
+
+func (s *TargetUnitSuite) TearDownTest() {
+	if s.bus != nil {
+		s.bus.Close()
+	}
+	s.srv.Close()
+	s.other.Close()
+}
+
+func (s *TargetUnitSuite) queued(srv *amqptest.Server) int {
+	n, _ := srv.QueueLength("queue")
+	return n
+}
+
+func (s *TargetUnitSuite) TestPublishToTargets() {
+	assert := s.Assert()
+
+	pub := s.bus.MustPublisher(SetPublisherExchange("exchange"))
+	other := s.bus.MustPublisher(SetPublisherExchange("exchange"), SetPublisherTarget("other"))
+	pooled := s.bus.MustPooledPublisher(SetPublisherExchange("exchange"), SetPublisherTarget("other"))
+
+	for _, p := range []Publisher{pub, other, pooled} {
+		err, ok := p.Publish(&Message{})
+		assert.NoError(err)
+		assert.True(ok)
+	}
+	assert.Equal(1, s.queued(s.srv))
+	assert.Equal(2, s.queued(s.other))
+
+	assert.Equal("", pub.Info().Target)
+	assert.Equal("other", other.Info().Target)
+	assert.Equal("other", pooled.Info().Target)
+}
+
+func (s *TargetUnitSuite) TestBridgeTargets() {
+	assert := s.Assert()
+
+	err, ok := s.bus.MustPublisher(SetPublisherExchange("exchange")).Publish(&Message{Body: []byte("body")})
+	s.Require().NoError(err)
+	s.Require().True(ok)
+
+	sub := s.bus.MustSubscriber(SetSubscriberQueue("queue"))
+	pub := s.bus.MustPublisher(SetPublisherExchange("exchange"), SetPublisherTarget("other"))
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+
+	msg := <-msgs
+	err, ok = pub.Publish(&Message{Body: msg.GetBody()})
+	assert.NoError(err)
+	assert.True(ok)
+	assert.NoError(msg.Ack(false))
+
+	assert.Equal(0, s.queued(s.srv))
+	assert.Equal(1, s.queued(s.other))
+}
+
+func (s *TargetUnitSuite) TestUnknownTarget() {
+	assert := s.Assert()
+
+	_, err := s.bus.NewPublisher(SetPublisherTarget("unknown"))
+	assert.Error(err)
+	_, err = s.bus.NewSubscriber(SetSubscriberTarget("unknown"))
+	assert.Error(err)
+
+	_, err = NewBus(SetBusTarget("other", ""))
+	assert.Error(err)
+}
+
+func (s *TargetUnitSuite) TestShutdownClosesTargets() {
+	assert := s.Assert()
+
+	s.bus.MustPublisher(SetPublisherTarget("other"))
+	s.bus.MustSubscriber(SetSubscriberTarget("other"), SetSubscriberQueue("queue"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(s.bus.Shutdown(ctx))
+
+	t := s.bus.(*bus).targets["other"]
+	s.bus = nil
+	assert.True(t.pubconns[0].IsClosed())
+	assert.True(t.subconns[0].IsClosed())
+}
+
+func TestTargetUnitSuite(t *testing.T) {
+	suite.Run(t, new(TargetUnitSuite))
+}
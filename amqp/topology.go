@@ -0,0 +1,175 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// Overflow is what a queue at its max length does with a new message.
+type Overflow string
+
+const (
+	// OverflowDropHead drops or dead-letters the oldest message, the default.
+	OverflowDropHead Overflow = "drop-head"
+	// OverflowRejectPublish nacks the new message.
+	OverflowRejectPublish Overflow = "reject-publish"
+	// OverflowRejectPublishDLX nacks and dead-letters the new message.
+	OverflowRejectPublishDLX Overflow = "reject-publish-dlx"
+)
+
+// QueueMode is how a classic queue keeps its messages.
+type QueueMode string
+
+const (
+	// QueueModeDefault keeps the messages in memory as long as it can.
+	QueueModeDefault QueueMode = "default"
+	// QueueModeLazy moves the messages to disk as early as it can.
+	QueueModeLazy QueueMode = "lazy"
+)
+
+// QueueType is the kind of queue the server declares.
+type QueueType string
+
+const (
+	// QueueClassic is a queue living on a single node, the default.
+	QueueClassic QueueType = "classic"
+	// QueueQuorum is a replicated queue that counts the deliveries of its
+	// messages. Quorum queues are durable and cannot be exclusive.
+	QueueQuorum QueueType = "quorum"
+	// QueueStream is a replicated append-only log, its messages stay after
+	// being consumed and each consumer reads from an offset of its own. Streams
+	// are durable and cannot be exclusive, their consumers must ack and set a
+	// prefetch count.
+	QueueStream QueueType = "stream"
+)
+
+type QueueOptionsFn func(*QueueOptions)
+
+type QueueOptions struct {
+	durable    bool
+	autoDelete bool
+	exclusive  bool
+
+	args amqp.Table
+}
+
+func SetQueueDurable(durable bool) QueueOptionsFn {
+	return func(o *QueueOptions) {
+		o.durable = durable
+	}
+}
+
+func SetQueueAutoDelete(autoDelete bool) QueueOptionsFn {
+	return func(o *QueueOptions) {
+		o.autoDelete = autoDelete
+	}
+}
+
+func SetQueueExclusive(exclusive bool) QueueOptionsFn {
+	return func(o *QueueOptions) {
+		o.exclusive = exclusive
+	}
+}
+
+// SetQueueArg sets a queue argument the other options do not cover.
+func SetQueueArg(key string, value interface{}) QueueOptionsFn {
+	return func(o *QueueOptions) {
+		o.args[key] = value
+	}
+}
+
+// SetQueueMaxPriority makes the queue deliver the messages with a higher
+// priority first, priorities above max count as max.
+func SetQueueMaxPriority(max uint8) QueueOptionsFn {
+	return SetQueueArg("x-max-priority", int32(max))
+}
+
+// SetQueueMessageTTL sets how long a message waits in the queue before it is
+// dropped or dead-lettered, rounded to milliseconds.
+func SetQueueMessageTTL(ttl time.Duration) QueueOptionsFn {
+	return SetQueueArg("x-message-ttl", ttl.Milliseconds())
+}
+
+// SetQueueMaxLength bounds the number of ready messages of the queue.
+func SetQueueMaxLength(max int) QueueOptionsFn {
+	return SetQueueArg("x-max-length", int64(max))
+}
+
+// SetQueueOverflow sets what the queue does with new messages once it reached
+// its max length.
+func SetQueueOverflow(overflow Overflow) QueueOptionsFn {
+	return SetQueueArg("x-overflow", string(overflow))
+}
+
+// SetQueueType sets the kind of the queue, quorum queues and streams have to be
+// durable.
+func SetQueueType(typ QueueType) QueueOptionsFn {
+	return SetQueueArg("x-queue-type", string(typ))
+}
+
+// SetQueueDeliveryLimit makes a quorum queue drop or dead-letter the messages
+// returned to it more than max times.
+func SetQueueDeliveryLimit(max int) QueueOptionsFn {
+	return SetQueueArg("x-delivery-limit", int64(max))
+}
+
+// SetQueueMaxLengthBytes bounds the size of the messages of the queue, streams
+// discard their oldest segments beyond it.
+func SetQueueMaxLengthBytes(max int64) QueueOptionsFn {
+	return SetQueueArg("x-max-length-bytes", max)
+}
+
+// SetQueueMaxAge makes a stream discard its segments older than the age,
+// rounded up to seconds so a short age never means discard right away.
+func SetQueueMaxAge(age time.Duration) QueueOptionsFn {
+	seconds := (age + time.Second - 1) / time.Second
+	return SetQueueArg("x-max-age", fmt.Sprintf("%ds", int64(seconds)))
+}
+
+func SetQueueMode(mode QueueMode) QueueOptionsFn {
+	return SetQueueArg("x-queue-mode", string(mode))
+}
+
+// SetQueueDeadLetterExchange sets the exchange the expired, rejected and
+// dropped messages are republished to.
+func SetQueueDeadLetterExchange(exchange string) QueueOptionsFn {
+	return SetQueueArg("x-dead-letter-exchange", exchange)
+}
+
+// SetQueueDeadLetterRoutingKey replaces the routing key of the dead-lettered
+// messages, they keep their own by default.
+func SetQueueDeadLetterRoutingKey(key string) QueueOptionsFn {
+	return SetQueueArg("x-dead-letter-routing-key", key)
+}
+
+func queueOptions(fns []QueueOptionsFn) *QueueOptions {
+	o := &QueueOptions{args: amqp.Table{}}
+	SetQueueDurable(true)(o)
+	SetQueueAutoDelete(false)(o)
+	SetQueueExclusive(false)(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+	return o
+}
+
+// QueueArgs returns the arguments the options set, for queues declared by
+// other means.
+func QueueArgs(fns ...QueueOptionsFn) amqp.Table {
+	return queueOptions(fns).args
+}
+
+// DeclareQueue declares a durable queue unless the options say otherwise.
+// Declaring an existing queue with other arguments closes the channel.
+func DeclareQueue(sess *Session, name string, fns ...QueueOptionsFn) (amqp.Queue, error) {
+	o := queueOptions(fns)
+
+	q, err := sess.Channel.QueueDeclare(name, o.durable, o.autoDelete, o.exclusive, false, o.args)
+	if err != nil {
+		return q, errors.Wrap(err, "Could not channel.QueueDeclare()")
+	}
+	return q, nil
+}
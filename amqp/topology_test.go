@@ -0,0 +1,258 @@
+package amqp
+
+import (
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/suite"
+)
+
+type TopologyUnitSuite struct {
+	suite.Suite
+	srv  *amqptest.Server
+	done chan struct{}
+	sess *Session
+}
+
+func (s *TopologyUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+	s.done = make(chan struct{})
+	conn := MustConnection(
+		SetConnectionDSN(s.srv.URL),
+		SetConnectionDone(s.done),
+	)
+	s.sess = MustSession(conn, SetChannelDone(s.done))
+}
+
+func (s *TopologyUnitSuite) TearDownTest() {
+	close(s.done)
+	s.srv.Close()
+}
+
+func (s *TopologyUnitSuite) TestQueueArgs() {
+	assert := s.Assert()
+
+	args := QueueArgs(
+		SetQueueMaxPriority(10),
+		SetQueueMessageTTL(time.Second),
+		SetQueueMaxLength(100),
+		SetQueueOverflow(OverflowRejectPublish),
+		SetQueueMode(QueueModeLazy),
+		SetQueueDeadLetterExchange("dlx"),
+		SetQueueDeadLetterRoutingKey("dead"),
+	)
+	assert.Equal(amqp.Table{
+		"x-max-priority":            int32(10),
+		"x-message-ttl":             int64(1000),
+		"x-max-length":              int64(100),
+		"x-overflow":                "reject-publish",
+		"x-queue-mode":              "lazy",
+		"x-dead-letter-exchange":    "dlx",
+		"x-dead-letter-routing-key": "dead",
+	}, args)
+	assert.NoError(args.Validate())
+}
+
+func (s *TopologyUnitSuite) TestPriority() {
+	assert := s.Assert()
+
+	_, err := DeclareQueue(s.sess, "queue", SetQueueMaxPriority(5))
+	s.Require().NoError(err)
+
+	pub := MustPublisher(s.sess, SetPublisherKey("queue"), SetPublisherClose(s.done))
+	for i, priority := range []uint8{0, 5, 9, 3} {
+		msg := &Message{Body: []byte{byte('a' + i)}}
+		msg.SetPriority(priority)
+		err, ok := pub.Publish(msg)
+		assert.NoError(err)
+		assert.True(ok)
+	}
+
+	deliveries := make(chan base.Message, 4)
+	sub := MustSubscriber(s.sess,
+		SetSubscriberQueue("queue"),
+		SetSubscriberDeliveries(deliveries),
+		SetSubscriberClose(s.done),
+	)
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+
+	// priorities above the max count as the max
+	for _, expected := range []string{"b", "c", "d", "a"} {
+		select {
+		case msg := <-msgs:
+			assert.Equal(expected, string(msg.GetBody()))
+			msg.Ack(false)
+		case <-time.After(time.Second):
+			s.FailNow("no message received")
+		}
+	}
+}
+
+func (s *TopologyUnitSuite) TestExpiration() {
+	assert := s.Assert()
+
+	_, err := DeclareQueue(s.sess, "dead")
+	s.Require().NoError(err)
+	_, err = DeclareQueue(s.sess, "queue",
+		SetQueueMessageTTL(time.Minute),
+		SetQueueDeadLetterExchange(""),
+		SetQueueDeadLetterRoutingKey("dead"),
+	)
+	s.Require().NoError(err)
+
+	pub := MustPublisher(s.sess, SetPublisherKey("queue"), SetPublisherClose(s.done))
+	msg := &Message{}
+	msg.SetExpiration(time.Millisecond * 50)
+	err, ok := pub.Publish(msg)
+	assert.NoError(err)
+	assert.True(ok)
+	err, ok = pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+
+	waitToBeTrue(func() bool {
+		n, _ := s.srv.QueueLength("dead")
+		return n == 1
+	}, time.Second)
+	n, _ := s.srv.QueueLength("dead")
+	assert.Equal(1, n)
+	n, _ = s.srv.QueueLength("queue")
+	assert.Equal(1, n)
+}
+
+func (s *TopologyUnitSuite) TestMaxLength() {
+	assert := s.Assert()
+
+	_, err := DeclareQueue(s.sess, "queue",
+		SetQueueMaxLength(1),
+		SetQueueOverflow(OverflowRejectPublish),
+		SetQueueMode(QueueModeLazy),
+	)
+	s.Require().NoError(err)
+
+	pub := MustPublisher(s.sess, SetPublisherKey("queue"), SetPublisherClose(s.done))
+	err, ok := pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+	err, ok = pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.False(ok)
+}
+
+func (s *TopologyUnitSuite) TestQueueTypeArgs() {
+	assert := s.Assert()
+
+	args := QueueArgs(
+		SetQueueType(QueueStream),
+		SetQueueMaxLengthBytes(1<<30),
+		SetQueueMaxAge(time.Hour*24),
+		SetQueueDeliveryLimit(3),
+	)
+	assert.Equal(amqp.Table{
+		"x-queue-type":       "stream",
+		"x-max-length-bytes": int64(1 << 30),
+		"x-max-age":          "86400s",
+		"x-delivery-limit":   int64(3),
+	}, args)
+	assert.NoError(args.Validate())
+
+	assert.Equal("1s", QueueArgs(SetQueueMaxAge(time.Millisecond * 500))["x-max-age"])
+	assert.Equal("2s", QueueArgs(SetQueueMaxAge(time.Millisecond * 1500))["x-max-age"])
+	assert.Equal("60s", QueueArgs(SetQueueMaxAge(time.Minute))["x-max-age"])
+
+	_, err := DeclareQueue(s.sess, "quorum", SetQueueType(QueueQuorum), SetQueueDurable(false))
+	assert.Error(err)
+}
+
+func (s *TopologyUnitSuite) consume(fns ...SubscriberOptionsFn) <-chan base.Message {
+	conn := MustConnection(SetConnectionDSN(s.srv.URL), SetConnectionDone(s.done))
+	sess := MustSession(conn, SetChannelPrefetchCount(10), SetChannelDone(s.done))
+	sub := MustSubscriber(sess, append(fns,
+		SetSubscriberDeliveries(make(chan base.Message, 10)),
+		SetSubscriberClose(s.done),
+	)...)
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+	return msgs
+}
+
+func (s *TopologyUnitSuite) receive(msgs <-chan base.Message) *Message {
+	select {
+	case msg := <-msgs:
+		return msg.(*Message)
+	case <-time.After(time.Second):
+		s.FailNow("no message received")
+		return nil
+	}
+}
+
+func (s *TopologyUnitSuite) TestDeliveryCount() {
+	assert := s.Assert()
+
+	_, err := DeclareQueue(s.sess, "quorum", SetQueueType(QueueQuorum))
+	s.Require().NoError(err)
+	pub := MustPublisher(s.sess, SetPublisherKey("quorum"), SetPublisherClose(s.done))
+	err, ok := pub.Publish(&Message{})
+	assert.NoError(err)
+	assert.True(ok)
+
+	msgs := s.consume(SetSubscriberQueue("quorum"))
+	for i := int64(0); i < 3; i++ {
+		msg := s.receive(msgs)
+		assert.Equal(i, msg.GetDeliveryCount())
+		assert.NoError(msg.Nack(false, true))
+	}
+}
+
+func (s *TopologyUnitSuite) TestStreamOffset() {
+	assert := s.Assert()
+
+	_, err := DeclareQueue(s.sess, "stream", SetQueueType(QueueStream))
+	s.Require().NoError(err)
+	pub := MustPublisher(s.sess, SetPublisherKey("stream"), SetPublisherClose(s.done))
+	for _, body := range []string{"a", "b", "c"} {
+		err, ok := pub.Publish(&Message{Body: []byte(body)})
+		assert.NoError(err)
+		assert.True(ok)
+	}
+
+	msgs := s.consume(SetSubscriberQueue("stream"), SetSubscriberStreamOffset(StreamOffsetFirst))
+	var last int64
+	var ok bool
+	for _, body := range []string{"a", "b", "c"} {
+		msg := s.receive(msgs)
+		assert.Equal(body, string(msg.GetBody()))
+		assert.NoError(msg.Ack(false))
+		last, ok = msg.GetStreamOffset()
+		assert.True(ok)
+	}
+
+	msgs = s.consume(SetSubscriberQueue("stream"), SetSubscriberStreamOffset(StreamOffsetOf(last)))
+	assert.Equal("c", string(s.receive(msgs).GetBody()))
+
+	msgs = s.consume(SetSubscriberQueue("stream"), SetSubscriberStreamOffset(StreamOffsetAt(time.Now().Add(-time.Minute))))
+	assert.Equal("a", string(s.receive(msgs).GetBody()))
+}
+
+func (s *TopologyUnitSuite) TestDeliveryExpirationAndPriority() {
+	assert := s.Assert()
+
+	msg := newMessage(&amqp.Delivery{Expiration: "1500", Priority: 4})
+	assert.Equal(time.Millisecond*1500, msg.GetExpiration())
+	assert.Equal(uint8(4), msg.GetPriority())
+
+	p := publishing(msg)
+	assert.Equal("1500", p.Expiration)
+	assert.Equal(uint8(4), p.Priority)
+
+	msg.SetExpiration(time.Microsecond)
+	assert.Equal("1", publishing(msg).Expiration)
+}
+
+func TestTopologyUnitSuite(t *testing.T) {
+	suite.Run(t, new(TopologyUnitSuite))
+}
@@ -0,0 +1,217 @@
+// Package batch hands the messages of a subscriber to a handler in batches.
+// A batch is handed over once it holds the configured number of messages or
+// once the first of its messages waited the configured time, whichever comes
+// first, and is settled with a single ack or nack.
+//
+// Settling a batch at once relies on the backend acking with multiple set, as
+// amqp does, and on the batch holding every unacked message of the channel, so
+// the subscriber should not share its channel with other consumers and its
+// prefetch should be at least the batch size.
+package batch
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+)
+
+// Handler processes a batch of messages. The batch is acked when the handler
+// returns nil and nacked otherwise, a handler returning Failures only nacks
+// the messages that failed.
+type Handler func([]base.Message) error
+
+// Failures is returned by a handler when only part of the batch failed, it
+// holds the indexes of the failed messages in the batch. The failed messages
+// are nacked and the others acked, one at a time.
+type Failures []int
+
+func (f Failures) Error() string {
+	return fmt.Sprintf("%d messages of the batch failed", len(f))
+}
+
+type SubscriberOptionsFn func(*SubscriberOptions)
+
+type SubscriberOptions struct {
+	size     int
+	timeout  time.Duration
+	requeue  bool
+	multiple bool
+}
+
+// SetSubscriberSize sets the most messages a batch holds.
+func SetSubscriberSize(size int) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.size = size
+	}
+}
+
+// SetSubscriberTimeout sets how long the first message of a batch waits for
+// the batch to fill up.
+func SetSubscriberTimeout(timeout time.Duration) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.timeout = timeout
+	}
+}
+
+// SetSubscriberRequeue sets whether the messages of a failed batch are
+// requeued, they are by default.
+func SetSubscriberRequeue(requeue bool) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.requeue = requeue
+	}
+}
+
+// SetSubscriberMultiple sets whether a batch is settled with a single ack or
+// nack of its last message with multiple set. Backends that settle messages
+// one at a time, as proc, need it turned off so every message is settled.
+func SetSubscriberMultiple(multiple bool) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.multiple = multiple
+	}
+}
+
+type Subscriber interface {
+	// Handle consumes the messages and calls the handler on each batch until
+	// the bus closes. The messages of a batch not handed over yet are left
+	// unacked.
+	Handle(Handler) error
+
+	Close()
+}
+
+type sub struct {
+	*SubscriberOptions
+
+	base.Subscriber
+}
+
+func MustSubscriber(s base.Subscriber, fns ...SubscriberOptionsFn) Subscriber {
+	sub, err := NewSubscriber(s, fns...)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// NewSubscriber wraps a subscriber of any backend. By default a batch holds up
+// to 100 messages and waits up to a second.
+func NewSubscriber(s base.Subscriber, fns ...SubscriberOptionsFn) (Subscriber, error) {
+	o := &SubscriberOptions{}
+	SetSubscriberSize(100)(o)
+	SetSubscriberTimeout(time.Second)(o)
+	SetSubscriberRequeue(true)(o)
+	SetSubscriberMultiple(true)(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if s == nil {
+		return nil, errors.New("Could not create subscriber, subscriber is required")
+	}
+	if o.size < 1 {
+		return nil, errors.New("Could not create subscriber, size must be at least 1")
+	}
+	if o.timeout <= 0 {
+		return nil, errors.New("Could not create subscriber, timeout must be positive")
+	}
+
+	return &sub{
+		SubscriberOptions: o,
+		Subscriber:        s,
+	}, nil
+}
+
+func (s *sub) Handle(handler Handler) error {
+	msgs, done, err := s.Subscriber.Consume()
+	if err != nil {
+		return err
+	}
+
+	// stop drains a timer that fired meanwhile, so it never flushes the next
+	// batch early
+	timer := time.NewTimer(s.timeout)
+	stop := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+	stop()
+	defer timer.Stop()
+
+	batch := make([]base.Message, 0, s.size)
+	flush := func() {
+		stop()
+		s.settle(batch, handler(batch))
+		batch = make([]base.Message, 0, s.size)
+	}
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				timer.Reset(s.timeout)
+			}
+			if len(batch) == s.size {
+				flush()
+			}
+		case <-timer.C:
+			if len(batch) > 0 {
+				flush()
+			}
+		}
+	}
+}
+
+func (s *sub) settle(batch []base.Message, err error) {
+	if failures, ok := errors.Cause(err).(Failures); ok {
+		failed := make(map[int]bool, len(failures))
+		for _, i := range failures {
+			failed[i] = true
+		}
+		for i, msg := range batch {
+			if failed[i] {
+				s.check(msg.Nack(false, s.requeue))
+			} else {
+				s.check(msg.Ack(false))
+			}
+		}
+		return
+	}
+
+	if s.multiple {
+		last := batch[len(batch)-1]
+		if err != nil {
+			s.check(last.Nack(true, s.requeue))
+		} else {
+			s.check(last.Ack(true))
+		}
+		return
+	}
+
+	for _, msg := range batch {
+		if err != nil {
+			s.check(msg.Nack(false, s.requeue))
+		} else {
+			s.check(msg.Ack(false))
+		}
+	}
+}
+
+func (s *sub) check(err error) {
+	if err != nil {
+		log.Printf("batch settle failed, err: %v\n", err)
+	}
+}
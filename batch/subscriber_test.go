@@ -0,0 +1,233 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/amqp"
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/movidesk/go-bus/bustest"
+	streadway "github.com/streadway/amqp"
+	"github.com/stretchr/testify/suite"
+)
+
+func newMessage(body string) *bustest.RecordingMessage {
+	return bustest.NewRecordingMessage(nil, []byte(body))
+}
+
+type SubscriberUnitSuite struct {
+	suite.Suite
+}
+
+func (s *SubscriberUnitSuite) handle(sub Subscriber, handler Handler) chan error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sub.Handle(handler)
+	}()
+	return errs
+}
+
+func (s *SubscriberUnitSuite) bodies(batch []base.Message) []string {
+	bodies := make([]string, 0, len(batch))
+	for _, msg := range batch {
+		bodies = append(bodies, string(msg.GetBody()))
+	}
+	return bodies
+}
+
+func (s *SubscriberUnitSuite) TestFlushesFullBatches() {
+	assert := s.Assert()
+
+	inner := bustest.NewSubscriber(100)
+	sub := MustSubscriber(inner, SetSubscriberSize(2), SetSubscriberTimeout(time.Hour))
+
+	msgs := []*bustest.RecordingMessage{newMessage("1"), newMessage("2"), newMessage("3"), newMessage("4")}
+	for _, msg := range msgs {
+		inner.Msgs <- msg
+	}
+
+	batches := make(chan []string, 2)
+	errs := s.handle(sub, func(batch []base.Message) error {
+		batches <- s.bodies(batch)
+		return nil
+	})
+
+	assert.Equal([]string{"1", "2"}, <-batches)
+	assert.Equal([]string{"3", "4"}, <-batches)
+	close(inner.Done)
+	assert.NoError(<-errs)
+
+	assert.Empty(msgs[0].Calls())
+	assert.Equal([]string{"ack true"}, msgs[1].Calls())
+	assert.Empty(msgs[2].Calls())
+	assert.Equal([]string{"ack true"}, msgs[3].Calls())
+}
+
+func (s *SubscriberUnitSuite) TestFlushesOnTimeout() {
+	assert := s.Assert()
+
+	inner := bustest.NewSubscriber(100)
+	sub := MustSubscriber(inner, SetSubscriberSize(10), SetSubscriberTimeout(time.Millisecond*50))
+
+	inner.Msgs <- newMessage("1")
+	inner.Msgs <- newMessage("2")
+
+	start := time.Now()
+	batches := make(chan []string, 1)
+	errs := s.handle(sub, func(batch []base.Message) error {
+		batches <- s.bodies(batch)
+		return nil
+	})
+
+	assert.Equal([]string{"1", "2"}, <-batches)
+	assert.True(time.Since(start) >= time.Millisecond*50)
+	close(inner.Done)
+	assert.NoError(<-errs)
+}
+
+func (s *SubscriberUnitSuite) TestNacksFailedBatch() {
+	assert := s.Assert()
+
+	inner := bustest.NewSubscriber(100)
+	sub := MustSubscriber(inner, SetSubscriberSize(2), SetSubscriberRequeue(false))
+
+	first, last := newMessage("1"), newMessage("2")
+	inner.Msgs <- first
+	inner.Msgs <- last
+
+	handled := make(chan struct{})
+	errs := s.handle(sub, func(batch []base.Message) error {
+		defer close(handled)
+		return errors.New("failed")
+	})
+
+	<-handled
+	close(inner.Done)
+	assert.NoError(<-errs)
+
+	assert.Empty(first.Calls())
+	assert.Equal([]string{"nack true false"}, last.Calls())
+}
+
+func (s *SubscriberUnitSuite) TestNacksIndividualFailures() {
+	assert := s.Assert()
+
+	inner := bustest.NewSubscriber(100)
+	sub := MustSubscriber(inner, SetSubscriberSize(3))
+
+	msgs := []*bustest.RecordingMessage{newMessage("1"), newMessage("2"), newMessage("3")}
+	for _, msg := range msgs {
+		inner.Msgs <- msg
+	}
+
+	handled := make(chan struct{})
+	errs := s.handle(sub, func(batch []base.Message) error {
+		defer close(handled)
+		return Failures{1}
+	})
+
+	<-handled
+	close(inner.Done)
+	assert.NoError(<-errs)
+
+	assert.Equal([]string{"ack false"}, msgs[0].Calls())
+	assert.Equal([]string{"nack false true"}, msgs[1].Calls())
+	assert.Equal([]string{"ack false"}, msgs[2].Calls())
+}
+
+func (s *SubscriberUnitSuite) TestSettlesEachWithoutMultiple() {
+	assert := s.Assert()
+
+	inner := bustest.NewSubscriber(100)
+	sub := MustSubscriber(inner, SetSubscriberSize(2), SetSubscriberMultiple(false))
+
+	msgs := []*bustest.RecordingMessage{newMessage("1"), newMessage("2")}
+	for _, msg := range msgs {
+		inner.Msgs <- msg
+	}
+
+	handled := make(chan struct{})
+	errs := s.handle(sub, func(batch []base.Message) error {
+		defer close(handled)
+		return nil
+	})
+
+	<-handled
+	close(inner.Done)
+	assert.NoError(<-errs)
+
+	assert.Equal([]string{"ack false"}, msgs[0].Calls())
+	assert.Equal([]string{"ack false"}, msgs[1].Calls())
+}
+
+func (s *SubscriberUnitSuite) TestAcksBatchOnAMQP() {
+	assert := s.Assert()
+
+	srv := amqptest.NewServer()
+	defer srv.Close()
+
+	conn, err := streadway.Dial(srv.URL)
+	s.Require().NoError(err)
+	ch, err := conn.Channel()
+	s.Require().NoError(err)
+	_, err = ch.QueueDeclare("queue", true, false, false, false, nil)
+	s.Require().NoError(err)
+	for i := 0; i < 3; i++ {
+		err = ch.Publish("", "queue", false, false, streadway.Publishing{Body: []byte(fmt.Sprint(i))})
+		s.Require().NoError(err)
+	}
+	conn.Close()
+
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	sess := amqp.MustSession(
+		amqp.MustConnection(
+			amqp.SetConnectionDSN(srv.URL),
+			amqp.SetConnectionDone(done),
+			amqp.SetConnectionWaitGroup(wg),
+		),
+		amqp.SetChannelPrefetchCount(3),
+		amqp.SetChannelDone(done),
+		amqp.SetChannelWaitGroup(wg),
+	)
+	sub := MustSubscriber(amqp.MustSubscriber(sess,
+		amqp.SetSubscriberQueue("queue"),
+		amqp.SetSubscriberClose(done),
+		amqp.SetSubscriberWaitGroup(wg),
+	), SetSubscriberSize(3))
+
+	batches := make(chan []string, 1)
+	errs := s.handle(sub, func(batch []base.Message) error {
+		batches <- s.bodies(batch)
+		return nil
+	})
+
+	assert.Equal([]string{"0", "1", "2"}, <-batches)
+	time.Sleep(time.Millisecond * 50)
+	close(done)
+	assert.NoError(<-errs)
+	wg.Wait()
+
+	// unacked messages would be requeued once the connection closed
+	n, _ := srv.QueueLength("queue")
+	assert.Equal(0, n)
+}
+
+func (s *SubscriberUnitSuite) TestInvalidOptions() {
+	assert := s.Assert()
+
+	_, err := NewSubscriber(bustest.NewSubscriber(100), SetSubscriberSize(0))
+	assert.Error(err)
+	_, err = NewSubscriber(bustest.NewSubscriber(100), SetSubscriberTimeout(0))
+	assert.Error(err)
+	_, err = NewSubscriber(nil)
+	assert.Error(err)
+}
+
+func TestSubscriberUnitSuite(t *testing.T) {
+	suite.Run(t, new(SubscriberUnitSuite))
+}
@@ -0,0 +1,313 @@
+// Package bridge forwards the messages of a subscriber of one backend to a
+// publisher of another, to mirror an amqp queue into a proc bus for a local
+// replay or to forward the messages of a file or proc bus to RabbitMQ.
+//
+// A message is acked once the publisher accepted its copy, as confirmed by the
+// server for the publishers that wait for confirmations, and nacked otherwise.
+// A failure may so forward a message twice but never loses it.
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+)
+
+type OptionsFn func(*Options)
+
+type Options struct {
+	filter  func(base.Message) bool
+	rewrite func(map[string]interface{})
+
+	interval   time.Duration
+	requeue    bool
+	retryDelay time.Duration
+}
+
+// SetFilter forwards only the messages the filter accepts, the others are
+// acked and dropped.
+func SetFilter(filter func(base.Message) bool) OptionsFn {
+	return func(o *Options) {
+		o.filter = filter
+	}
+}
+
+// SetRewrite rewrites the headers of the messages forwarded, the function
+// receives a copy of the headers of each message it can change in place.
+func SetRewrite(rewrite func(headers map[string]interface{})) OptionsFn {
+	return func(o *Options) {
+		o.rewrite = rewrite
+	}
+}
+
+// SetRate forwards at most n messages per period, evenly spaced. Zero does not
+// limit the rate.
+func SetRate(n int, per time.Duration) OptionsFn {
+	return func(o *Options) {
+		if n <= 0 {
+			o.interval = 0
+			return
+		}
+		o.interval = per / time.Duration(n)
+	}
+}
+
+// SetRequeue sets whether the messages that could not be forwarded are
+// requeued, they are by default.
+func SetRequeue(requeue bool) OptionsFn {
+	return func(o *Options) {
+		o.requeue = requeue
+	}
+}
+
+// SetRetryDelay sets how long the bridge waits after a message could not be
+// forwarded before it takes the next one, one second by default.
+func SetRetryDelay(delay time.Duration) OptionsFn {
+	return func(o *Options) {
+		o.retryDelay = delay
+	}
+}
+
+// MatchHeaders is a filter accepting the messages that have every header of
+// the map. Values are compared by their printed form, so 1 matches "1".
+func MatchHeaders(headers map[string]interface{}) func(base.Message) bool {
+	return func(msg base.Message) bool {
+		for k, v := range headers {
+			hv, ok := msg.GetHeaders()[k]
+			if !ok || fmt.Sprint(hv) != fmt.Sprint(v) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+type Bridge interface {
+	// Run forwards the messages until the subscriber closes or the context is
+	// done. A message taken but not forwarded yet is nacked when it stops.
+	Run(ctx context.Context) error
+}
+
+type bridge struct {
+	*Options
+
+	sub base.Subscriber
+	pub base.Publisher
+
+	next time.Time
+}
+
+func MustBridge(sub base.Subscriber, pub base.Publisher, fns ...OptionsFn) Bridge {
+	b, err := NewBridge(sub, pub, fns...)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// confirmer is implemented by the publishers that may not wait for their
+// publishings to be confirmed, as amqp publishers.
+type confirmer interface {
+	Confirms() bool
+}
+
+// NewBridge creates a bridge forwarding every message of the subscriber to the
+// publisher, unchanged and as fast as the publisher accepts them.
+//
+// The publisher must tell whether it accepted each message. An amqp publisher
+// that does not wait for confirmations never does, its messages would be
+// forwarded again and again, so it is rejected.
+func NewBridge(sub base.Subscriber, pub base.Publisher, fns ...OptionsFn) (Bridge, error) {
+	o := &Options{}
+	SetRate(0, 0)(o)
+	SetRequeue(true)(o)
+	SetRetryDelay(time.Second)(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if sub == nil {
+		return nil, errors.New("Could not create bridge, subscriber is required")
+	}
+	if pub == nil {
+		return nil, errors.New("Could not create bridge, publisher is required")
+	}
+	if p, ok := pub.(confirmer); ok && !p.Confirms() {
+		return nil, errors.New("Could not create bridge, publisher must wait for confirmations")
+	}
+	if o.interval < 0 || o.retryDelay < 0 {
+		return nil, errors.New("Could not create bridge, rate and retry delay must not be negative")
+	}
+
+	return &bridge{
+		Options: o,
+		sub:     sub,
+		pub:     pub,
+	}, nil
+}
+
+func (b *bridge) Run(ctx context.Context) error {
+	msgs, done, err := b.sub.Consume()
+	if err != nil {
+		return errors.Wrap(err, "Could not consume")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			if !b.forward(ctx, msg) {
+				return nil
+			}
+		}
+	}
+}
+
+// forward publishes the copy of the message and settles the message, it
+// returns false once the context is done.
+func (b *bridge) forward(ctx context.Context, msg base.Message) bool {
+	if b.filter != nil && !b.filter(msg) {
+		if err := msg.Ack(false); err != nil {
+			log.Printf("bridge ack failed, err: %v\n", err)
+		}
+		return true
+	}
+
+	if !b.wait(ctx) {
+		b.nack(msg, true)
+		return false
+	}
+
+	err, ok := b.pub.Publish(b.copy(msg))
+	if err != nil || !ok {
+		log.Printf("bridge publish failed, ok: %t, err: %v\n", ok, err)
+		b.nack(msg, b.requeue)
+		return sleep(ctx, b.retryDelay)
+	}
+
+	if err := msg.Ack(false); err != nil {
+		log.Printf("bridge ack failed, err: %v\n", err)
+	}
+	return true
+}
+
+func (b *bridge) nack(msg base.Message, requeue bool) {
+	if err := msg.Nack(false, requeue); err != nil {
+		log.Printf("bridge nack failed, err: %v\n", err)
+	}
+}
+
+// wait holds the message back until the rate allows it.
+func (b *bridge) wait(ctx context.Context) bool {
+	if b.interval == 0 {
+		return true
+	}
+
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	d := b.next.Sub(now)
+	b.next = b.next.Add(b.interval)
+	return sleep(ctx, d)
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// copy returns the message to publish, with the rewritten headers.
+func (b *bridge) copy(msg base.Message) base.Message {
+	headers := make(map[string]interface{}, len(msg.GetHeaders()))
+	for k, v := range msg.GetHeaders() {
+		headers[k] = v
+	}
+	if b.rewrite != nil {
+		b.rewrite(headers)
+	}
+
+	m := &message{
+		id:      msg.DeliveryInfo().MessageId,
+		headers: headers,
+		body:    msg.GetBody(),
+	}
+	if e, ok := msg.(base.Expirable); ok {
+		m.expiration = e.GetExpiration()
+	}
+	if p, ok := msg.(base.Prioritizable); ok {
+		m.priority = p.GetPriority()
+	}
+	return m
+}
+
+// message is the copy of a message the bridge publishes. It keeps what the
+// publishers of every backend read but not the delivery of the original, the
+// bridge settles the original itself.
+type message struct {
+	id         string
+	headers    map[string]interface{}
+	body       []byte
+	priority   uint8
+	expiration time.Duration
+}
+
+func (m *message) Ack(multiple bool) error {
+	return nil
+}
+
+func (m *message) Nack(multiple bool, requeue bool) error {
+	return nil
+}
+
+func (m *message) Reject(requeue bool) error {
+	return nil
+}
+
+func (m *message) GetHeaders() map[string]interface{} {
+	return m.headers
+}
+
+func (m *message) SetHeaders(h map[string]interface{}) {
+	m.headers = h
+}
+
+func (m *message) GetBody() []byte {
+	return m.body
+}
+
+func (m *message) SetBody(b []byte) {
+	m.body = b
+}
+
+func (m *message) DeliveryInfo() base.DeliveryInfo {
+	return base.DeliveryInfo{MessageId: m.id}
+}
+
+func (m *message) GetPriority() uint8 {
+	return m.priority
+}
+
+func (m *message) GetExpiration() time.Duration {
+	return m.expiration
+}
@@ -0,0 +1,229 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/amqp"
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/movidesk/go-bus/bustest"
+	"github.com/movidesk/go-bus/proc"
+	"github.com/stretchr/testify/suite"
+)
+
+func newDelivery(headers map[string]interface{}, body string) *bustest.RecordingMessage {
+	return bustest.NewRecordingMessage(headers, []byte(body))
+}
+
+// publisher nacks every publishing.
+type publisher struct{}
+
+func (p *publisher) Publish(base.Message) (error, bool) {
+	return nil, false
+}
+
+type BridgeUnitSuite struct {
+	suite.Suite
+}
+
+func (s *BridgeUnitSuite) run(b Bridge) (context.CancelFunc, <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- b.Run(ctx)
+	}()
+	return cancel, errs
+}
+
+func (s *BridgeUnitSuite) stop(cancel context.CancelFunc, errs <-chan error) {
+	cancel()
+	select {
+	case err := <-errs:
+		s.Assert().NoError(err)
+	case <-time.After(time.Second):
+		s.Fail("bridge did not stop")
+	}
+}
+
+// target returns a proc bus publisher and the messages it delivers.
+func (s *BridgeUnitSuite) target() (base.Publisher, <-chan base.Message) {
+	broker := make(chan base.Message, 10)
+	bus, _ := proc.NewBus(proc.SetIn(broker), proc.SetOut(broker))
+	s.T().Cleanup(bus.Close)
+
+	pub, _ := bus.NewPublisher()
+	sub, _ := bus.NewSubscriber()
+	msgs, _, _ := sub.Consume()
+	return pub, msgs
+}
+
+func (s *BridgeUnitSuite) receive(msgs <-chan base.Message) base.Message {
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(time.Second):
+		s.FailNow("no message received")
+		return nil
+	}
+}
+
+func (s *BridgeUnitSuite) TestForward() {
+	assert := s.Assert()
+
+	src := bustest.NewSubscriber(10)
+	pub, msgs := s.target()
+	b := MustBridge(src, pub, SetRewrite(func(headers map[string]interface{}) {
+		headers["bridged"] = true
+		delete(headers, "drop")
+	}))
+	cancel, errs := s.run(b)
+	defer s.stop(cancel, errs)
+
+	msg := newDelivery(map[string]interface{}{"key": "a", "drop": 1}, "body")
+	src.Msgs <- msg
+
+	copy := s.receive(msgs)
+	assert.Equal("body", string(copy.GetBody()))
+	assert.Equal(map[string]interface{}{"key": "a", "bridged": true}, copy.GetHeaders())
+	assert.Equal(map[string]interface{}{"key": "a", "drop": 1}, msg.GetHeaders())
+
+	waitToBeTrue(func() bool { return len(msg.Calls()) == 1 })
+	assert.Equal([]string{"ack false"}, msg.Calls())
+}
+
+func (s *BridgeUnitSuite) TestFilter() {
+	assert := s.Assert()
+
+	src := bustest.NewSubscriber(10)
+	pub, msgs := s.target()
+	b := MustBridge(src, pub, SetFilter(MatchHeaders(map[string]interface{}{"kind": "order"})))
+	cancel, errs := s.run(b)
+	defer s.stop(cancel, errs)
+
+	skipped := newDelivery(map[string]interface{}{"kind": "invoice"}, "skipped")
+	src.Msgs <- skipped
+	src.Msgs <- newDelivery(map[string]interface{}{"kind": "order"}, "forwarded")
+
+	assert.Equal("forwarded", string(s.receive(msgs).GetBody()))
+	assert.Equal([]string{"ack false"}, skipped.Calls())
+}
+
+func (s *BridgeUnitSuite) TestPublishFailed() {
+	assert := s.Assert()
+
+	src := bustest.NewSubscriber(10)
+	b := MustBridge(src, &publisher{}, SetRetryDelay(time.Millisecond))
+	cancel, errs := s.run(b)
+	defer s.stop(cancel, errs)
+
+	requeued := newDelivery(nil, "")
+	src.Msgs <- requeued
+	waitToBeTrue(func() bool { return len(requeued.Calls()) == 1 })
+	assert.Equal([]string{"nack false true"}, requeued.Calls())
+
+	other := bustest.NewSubscriber(10)
+	b = MustBridge(other, &publisher{}, SetRetryDelay(time.Millisecond), SetRequeue(false))
+	cancel, errs = s.run(b)
+	defer s.stop(cancel, errs)
+
+	dropped := newDelivery(nil, "")
+	other.Msgs <- dropped
+	waitToBeTrue(func() bool { return len(dropped.Calls()) == 1 })
+	assert.Equal([]string{"nack false false"}, dropped.Calls())
+}
+
+func (s *BridgeUnitSuite) TestRate() {
+	assert := s.Assert()
+
+	src := bustest.NewSubscriber(10)
+	pub, msgs := s.target()
+	b := MustBridge(src, pub, SetRate(10, time.Millisecond*500))
+	cancel, errs := s.run(b)
+	defer s.stop(cancel, errs)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		src.Msgs <- newDelivery(nil, fmt.Sprint(i))
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(fmt.Sprint(i), string(s.receive(msgs).GetBody()))
+	}
+	assert.True(time.Since(start) >= time.Millisecond*100)
+}
+
+func (s *BridgeUnitSuite) TestStopsWhenSubscriberCloses() {
+	src := bustest.NewSubscriber(10)
+	pub, _ := s.target()
+	_, errs := s.run(MustBridge(src, pub))
+
+	close(src.Done)
+	select {
+	case err := <-errs:
+		s.Assert().NoError(err)
+	case <-time.After(time.Second):
+		s.Fail("bridge did not stop")
+	}
+}
+
+func (s *BridgeUnitSuite) TestMirrorAmqpToProc() {
+	assert := s.Assert()
+
+	srv := amqptest.NewServer()
+	defer srv.Close()
+	bus := amqp.MustBus(amqp.SetBusDSN(srv.URL))
+	defer bus.Close()
+
+	pub := bus.MustPublisher(amqp.SetPublisherKey("queue"))
+	sess := amqp.MustSession(amqp.MustConnection(amqp.SetConnectionDSN(srv.URL)))
+	_, err := amqp.DeclareQueue(sess, "queue")
+	s.Require().NoError(err)
+	for i := 0; i < 2; i++ {
+		err, ok := pub.Publish(&amqp.Message{Body: []byte(fmt.Sprint(i)), Priority: 3})
+		s.Require().NoError(err)
+		s.Require().True(ok)
+	}
+
+	sub := bus.MustSubscriber(amqp.SetSubscriberQueue("queue"))
+	target, msgs := s.target()
+	cancel, errs := s.run(MustBridge(sub, target))
+	defer s.stop(cancel, errs)
+
+	for i := 0; i < 2; i++ {
+		msg := s.receive(msgs)
+		assert.Equal(fmt.Sprint(i), string(msg.GetBody()))
+		assert.Equal(uint8(3), msg.(base.Prioritizable).GetPriority())
+	}
+	waitToBeTrue(func() bool { return sub.Info().Acked == 2 })
+	assert.Equal(uint64(2), sub.Info().Acked)
+	n, _ := srv.QueueLength("queue")
+	assert.Equal(0, n)
+}
+
+func (s *BridgeUnitSuite) TestRejectsUnconfirmedTarget() {
+	assert := s.Assert()
+
+	srv := amqptest.NewServer()
+	defer srv.Close()
+	bus := amqp.MustBus(amqp.SetBusDSN(srv.URL))
+	defer bus.Close()
+
+	_, err := NewBridge(bustest.NewSubscriber(10), bus.MustPublisher(amqp.SetPublisherConfirm(false)))
+	assert.Error(err)
+
+	_, err = NewBridge(bustest.NewSubscriber(10), bus.MustPublisher())
+	assert.NoError(err)
+}
+
+func waitToBeTrue(check func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !check() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBridgeUnitSuite(t *testing.T) {
+	suite.Run(t, new(BridgeUnitSuite))
+}
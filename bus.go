@@ -1,6 +1,9 @@
 package bus
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type Bus interface {
 	Wait()
@@ -12,12 +15,31 @@ type Publisher interface {
 	Publish(Message) (error, bool)
 }
 
+// DelayedPublisher is implemented by the publishers able to hold a message
+// back, the message is delivered to the subscribers once the delay elapsed or
+// the time came. A delay that already elapsed publishes right away.
+type DelayedPublisher interface {
+	Publisher
+
+	PublishAfter(Message, time.Duration) (error, bool)
+	PublishAt(Message, time.Time) (error, bool)
+}
+
 type Subscriber interface {
 	Consume() (<-chan Message, <-chan struct{}, error)
 
 	Close()
 }
 
+// PausableSubscriber is implemented by the subscribers able to stop taking
+// messages for a while, the channel of Consume stays open until they resume.
+type PausableSubscriber interface {
+	Subscriber
+
+	Pause() error
+	Resume() error
+}
+
 type Message interface {
 	Ack(multiple bool) error
 	Nack(multiple bool, requeue bool) error
@@ -27,4 +49,32 @@ type Message interface {
 	SetHeaders(map[string]interface{})
 	GetBody() []byte
 	SetBody([]byte)
+
+	DeliveryInfo() DeliveryInfo
+}
+
+// DeliveryInfo describes how a message was delivered to its subscriber. Each
+// backend fills what it knows and leaves the rest zero, a message that was not
+// consumed tells at most its id.
+type DeliveryInfo struct {
+	MessageId   string
+	Exchange    string
+	RoutingKey  string
+	ConsumerTag string
+	Timestamp   time.Time
+	Redelivered bool
+}
+
+// Expirable is implemented by the messages that expire, a message still
+// waiting to be delivered once its expiration elapsed is dropped. Zero never
+// expires.
+type Expirable interface {
+	GetExpiration() time.Duration
+}
+
+// Prioritizable is implemented by the messages with a priority, the messages
+// waiting with a higher priority are delivered first where the backend
+// supports it.
+type Prioritizable interface {
+	GetPriority() uint8
 }
@@ -0,0 +1,69 @@
+package bustest
+
+import (
+	"fmt"
+	"sync"
+
+	base "github.com/movidesk/go-bus"
+)
+
+// RecordingMessage is a message recording how it was settled, for the tests of
+// the packages wrapping a subscriber.
+type RecordingMessage struct {
+	base.Message
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func NewRecordingMessage(headers map[string]interface{}, body []byte) *RecordingMessage {
+	return &RecordingMessage{Message: NewMessage(headers, body)}
+}
+
+func (m *RecordingMessage) record(call string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, call)
+	return nil
+}
+
+func (m *RecordingMessage) Ack(multiple bool) error {
+	return m.record(fmt.Sprintf("ack %t", multiple))
+}
+
+func (m *RecordingMessage) Nack(multiple bool, requeue bool) error {
+	return m.record(fmt.Sprintf("nack %t %t", multiple, requeue))
+}
+
+func (m *RecordingMessage) Reject(requeue bool) error {
+	return m.record(fmt.Sprintf("reject %t", requeue))
+}
+
+// Calls returns the settlements of the message in order, as "ack false" or
+// "nack false true".
+func (m *RecordingMessage) Calls() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.calls...)
+}
+
+// Subscriber hands over the messages sent on Msgs, closing Done tells the
+// consumer it closed.
+type Subscriber struct {
+	Msgs chan base.Message
+	Done chan struct{}
+}
+
+// NewSubscriber creates a subscriber holding up to size messages.
+func NewSubscriber(size int) *Subscriber {
+	return &Subscriber{
+		Msgs: make(chan base.Message, size),
+		Done: make(chan struct{}),
+	}
+}
+
+func (s *Subscriber) Consume() (<-chan base.Message, <-chan struct{}, error) {
+	return s.Msgs, s.Done, nil
+}
+
+func (s *Subscriber) Close() {}
@@ -0,0 +1,48 @@
+package bustest
+
+import base "github.com/movidesk/go-bus"
+
+// Message is a backend neutral message used to publish on the bus under test.
+type Message struct {
+	Headers map[string]interface{}
+	Body    []byte
+}
+
+func NewMessage(headers map[string]interface{}, body []byte) base.Message {
+	return &Message{
+		Headers: headers,
+		Body:    body,
+	}
+}
+
+func (m *Message) Ack(multiple bool) error {
+	return nil
+}
+
+func (m *Message) Nack(multiple bool, requeue bool) error {
+	return nil
+}
+
+func (m *Message) Reject(requeue bool) error {
+	return nil
+}
+
+func (m *Message) GetHeaders() map[string]interface{} {
+	return m.Headers
+}
+
+func (m *Message) SetHeaders(h map[string]interface{}) {
+	m.Headers = h
+}
+
+func (m *Message) GetBody() []byte {
+	return m.Body
+}
+
+func (m *Message) SetBody(b []byte) {
+	m.Body = b
+}
+
+func (m *Message) DeliveryInfo() base.DeliveryInfo {
+	return base.DeliveryInfo{}
+}
@@ -0,0 +1,286 @@
+// Package bustest provides a conformance suite every bus backend is expected
+// to pass, so backends can be swapped without changing consumer code.
+package bustest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/stretchr/testify/suite"
+)
+
+// Bus is the shape of a bus exercised by the suite. Backends whose factory
+// methods take options are adapted by the Factory.
+type Bus interface {
+	base.Bus
+
+	NewPublisher() (base.Publisher, error)
+	NewSubscriber() (base.Subscriber, error)
+}
+
+// Factory creates a bus whose publishers and subscribers share a single empty
+// queue. It is called once per test, resources held by the bus can be released
+// with t.Cleanup.
+type Factory func(t *testing.T) Bus
+
+// Suite is a testify suite holding a backend to the bus contract:
+//
+//	func TestBusConformanceUnitSuite(t *testing.T) {
+//		suite.Run(t, &bustest.Suite{NewBus: factory})
+//	}
+type Suite struct {
+	suite.Suite
+
+	// NewBus creates the bus under test.
+	NewBus Factory
+	// Timeout bounds every wait of the suite, defaults to five seconds.
+	Timeout time.Duration
+
+	bus    Bus
+	closed bool
+}
+
+func (s *Suite) SetupTest() {
+	s.Require().NotNil(s.NewBus, "bustest: Suite.NewBus is not set")
+	if s.Timeout == 0 {
+		s.Timeout = time.Second * 5
+	}
+	s.bus = s.NewBus(s.T())
+	s.closed = false
+}
+
+func (s *Suite) TearDownTest() {
+	if !s.closed {
+		s.bus.Close()
+	}
+}
+
+func (s *Suite) publisher() base.Publisher {
+	pub, err := s.bus.NewPublisher()
+	s.Require().NoError(err)
+	s.Require().NotNil(pub)
+	return pub
+}
+
+func (s *Suite) subscriber() base.Subscriber {
+	sub, err := s.bus.NewSubscriber()
+	s.Require().NoError(err)
+	s.Require().NotNil(sub)
+	return sub
+}
+
+func (s *Suite) publish(pub base.Publisher, body string) {
+	err, ok := pub.Publish(NewMessage(map[string]interface{}{"key": "value"}, []byte(body)))
+	s.Require().NoError(err)
+	s.Require().True(ok, "publish of %q was not confirmed", body)
+}
+
+func (s *Suite) consume(sub base.Subscriber) <-chan base.Message {
+	msgs, closer, err := sub.Consume()
+	s.Require().NoError(err)
+	s.Require().NotNil(msgs)
+	s.Require().NotNil(closer)
+	return msgs
+}
+
+func (s *Suite) receive(msgs <-chan base.Message) base.Message {
+	select {
+	case msg, ok := <-msgs:
+		s.Require().True(ok, "deliveries channel closed")
+		return msg
+	case <-time.After(s.Timeout):
+		s.FailNow("timed out waiting for a message")
+		return nil
+	}
+}
+
+func (s *Suite) shutdown() error {
+	s.closed = true
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	return s.bus.Shutdown(ctx)
+}
+
+func (s *Suite) TestPublishAndConsume() {
+	assert := s.Assert()
+
+	pub := s.publisher()
+	sub := s.subscriber()
+	msgs := s.consume(sub)
+
+	s.publish(pub, "body")
+
+	msg := s.receive(msgs)
+	assert.Equal("body", string(msg.GetBody()))
+	assert.Equal("value", msg.GetHeaders()["key"])
+	assert.NoError(msg.Ack(false))
+}
+
+func (s *Suite) TestConsumeInPublishOrder() {
+	assert := s.Assert()
+
+	pub := s.publisher()
+	sub := s.subscriber()
+	msgs := s.consume(sub)
+
+	for i := 0; i < 10; i++ {
+		s.publish(pub, fmt.Sprint(i))
+	}
+
+	for i := 0; i < 10; i++ {
+		msg := s.receive(msgs)
+		assert.Equal(fmt.Sprint(i), string(msg.GetBody()))
+		assert.NoError(msg.Ack(false))
+	}
+}
+
+func (s *Suite) TestNackWithRequeue() {
+	assert := s.Assert()
+
+	pub := s.publisher()
+	sub := s.subscriber()
+	msgs := s.consume(sub)
+
+	s.publish(pub, "body")
+
+	msg := s.receive(msgs)
+	assert.NoError(msg.Nack(false, true))
+
+	msg = s.receive(msgs)
+	assert.Equal("body", string(msg.GetBody()))
+	assert.NoError(msg.Ack(false))
+}
+
+func (s *Suite) TestRejectWithRequeue() {
+	assert := s.Assert()
+
+	pub := s.publisher()
+	sub := s.subscriber()
+	msgs := s.consume(sub)
+
+	s.publish(pub, "body")
+
+	msg := s.receive(msgs)
+	assert.NoError(msg.Reject(true))
+
+	msg = s.receive(msgs)
+	assert.Equal("body", string(msg.GetBody()))
+	assert.NoError(msg.Ack(false))
+}
+
+func (s *Suite) TestNackWithoutRequeue() {
+	assert := s.Assert()
+
+	pub := s.publisher()
+	sub := s.subscriber()
+	msgs := s.consume(sub)
+
+	s.publish(pub, "dropped")
+	msg := s.receive(msgs)
+	assert.NoError(msg.Nack(false, false))
+
+	s.publish(pub, "kept")
+	msg = s.receive(msgs)
+	assert.Equal("kept", string(msg.GetBody()))
+	assert.NoError(msg.Ack(false))
+}
+
+func (s *Suite) TestShutdownDrainsSubscribers() {
+	assert := s.Assert()
+
+	pub := s.publisher()
+	sub := s.subscriber()
+	msgs, closer, err := sub.Consume()
+	s.Require().NoError(err)
+
+	s.publish(pub, "body")
+
+	acked := make(chan struct{})
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-closer:
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				msg.Ack(false)
+				close(acked)
+			}
+		}
+	}()
+
+	select {
+	case <-acked:
+	case <-time.After(s.Timeout):
+		s.FailNow("timed out waiting for the message to be handled")
+	}
+
+	assert.NoError(s.shutdown())
+}
+
+func (s *Suite) TestCloseSignalsSubscribers() {
+	sub := s.subscriber()
+	_, closer, err := sub.Consume()
+	s.Require().NoError(err)
+
+	s.closed = true
+	s.bus.Close()
+
+	select {
+	case <-closer:
+	case <-time.After(s.Timeout):
+		s.Fail("closer was not signaled on bus close")
+	}
+	sub.Close()
+}
+
+func (s *Suite) TestPublishAfterShutdown() {
+	assert := s.Assert()
+
+	pub := s.publisher()
+	assert.NoError(s.shutdown())
+
+	err, ok := pub.Publish(NewMessage(nil, []byte("body")))
+	assert.Error(err)
+	assert.False(ok)
+}
+
+func (s *Suite) TestConcurrentPublishers() {
+	assert := s.Assert()
+
+	const publishers, messages = 4, 25
+
+	sub := s.subscriber()
+	msgs := s.consume(sub)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < publishers; i++ {
+		pub := s.publisher()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				err, ok := pub.Publish(NewMessage(nil, []byte(fmt.Sprintf("%d-%d", i, j))))
+				assert.NoError(err)
+				assert.True(ok)
+			}
+		}(i)
+	}
+
+	received := make(map[string]bool)
+	for len(received) < publishers*messages {
+		msg := s.receive(msgs)
+		received[string(msg.GetBody())] = true
+		assert.NoError(msg.Ack(false))
+	}
+
+	wg.Wait()
+	assert.Len(received, publishers*messages)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/movidesk/go-bus/amqp"
+	streadway "github.com/streadway/amqp"
+)
+
+func parseFormat(format string) (amqp.ArchiveFormat, error) {
+	switch format {
+	case "json":
+		return amqp.ArchiveJSON, nil
+	case "binary":
+		return amqp.ArchiveBinary, nil
+	}
+	return 0, fmt.Errorf("unknown archive format %q, expected json or binary", format)
+}
+
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// dump writes the messages of a queue to an archive on stdout or a file.
+func dump(e *env, args []string) error {
+	fs := e.flags("dump")
+	queue := fs.String("queue", getenv("GOBUS_QUEUE", ""), "queue to dump")
+	format := fs.String("format", "json", "archive format, json or binary")
+	limit := fs.Int("n", 0, "dump at most n messages, 0 dumps the whole queue")
+	remove := fs.Bool("remove", false, "remove the dumped messages from the queue")
+	output := fs.String("o", "", "file the archive is written to instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *queue == "" {
+		return errors.New("a queue is required")
+	}
+	f, err := parseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	w := e.stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	sess, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer sess.stop()
+
+	n, err := amqp.Dump(sess.Session, *queue, w,
+		amqp.SetDumpFormat(f),
+		amqp.SetDumpLimit(*limit),
+		amqp.SetDumpRemove(*remove),
+	)
+	fmt.Fprintf(e.stderr, "%d messages dumped\n", n)
+	return err
+}
+
+// restore publishes the messages of an archive read from a file or stdin.
+func restore(e *env, args []string) error {
+	fs := e.flags("restore")
+	exchange := fs.String("exchange", getenv("GOBUS_EXCHANGE", ""), "exchange to publish to instead of the archived one")
+	key := fs.String("key", "", "routing key to publish with instead of the archived one")
+	format := fs.String("format", "json", "archive format, json or binary")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	f, err := parseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	fns := []amqp.RestoreOptionsFn{amqp.SetRestoreFormat(f)}
+	if isSet(fs, "exchange") || os.Getenv("GOBUS_EXCHANGE") != "" {
+		fns = append(fns, amqp.SetRestoreExchange(*exchange))
+	}
+	if isSet(fs, "key") {
+		fns = append(fns, amqp.SetRestoreKey(*key))
+	}
+
+	var r io.Reader = e.stdin
+	if fs.NArg() > 0 {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	sess, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer sess.stop()
+
+	n, err := amqp.Restore(sess.Session, r, fns...)
+	fmt.Fprintf(e.stderr, "%d messages restored\n", n)
+	return err
+}
+
+// move shovels the messages of a queue into another one.
+func move(e *env, args []string) error {
+	fs := e.flags("move")
+	from := fs.String("from", getenv("GOBUS_QUEUE", ""), "queue to move the messages from")
+	to := fs.String("to", "", "queue to move the messages to")
+	limit := fs.Int("n", 0, "move at most n messages, 0 moves every message")
+	headers := headersFlag{}
+	fs.Var(headers, "H", "move only the messages with the header key=value, may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *from == "" || *to == "" {
+		return errors.New("both -from and -to queues are required")
+	}
+
+	sess, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer sess.stop()
+
+	n, err := amqp.Move(sess.Session, *from, *to,
+		amqp.SetMoveLimit(*limit),
+		amqp.SetMoveFilter(amqp.MatchHeaders(streadway.Table(headers))),
+	)
+	fmt.Fprintf(e.stderr, "%d messages moved\n", n)
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/movidesk/go-bus/amqp"
+)
+
+// tail prints the messages of a queue and leaves them unacked, they go back to
+// the queue once gobus exits.
+func tail(e *env, args []string) error {
+	return receive(e, "tail", args, false)
+}
+
+// consume prints and acks the messages of a queue.
+func consume(e *env, args []string) error {
+	return receive(e, "consume", args, true)
+}
+
+func receive(e *env, name string, args []string, ack bool) error {
+	fs := e.flags(name)
+	queue := fs.String("queue", getenv("GOBUS_QUEUE", ""), "queue to read from")
+	limit := fs.Int("n", 0, "stop after n messages, 0 reads until interrupted")
+	asJSON := fs.Bool("json", false, "print the messages as json lines")
+	prefetch := 0
+	if ack {
+		fs.IntVar(&prefetch, "prefetch", 1, "number of unacked messages the server delivers ahead")
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	// nothing is acked while tailing, the limit is what bounds the number of
+	// messages the server hands over
+	if !ack {
+		prefetch = *limit
+	}
+
+	if *queue == "" {
+		return errors.New("a queue is required")
+	}
+
+	sess, err := e.connect(amqp.SetChannelPrefetchCount(prefetch))
+	if err != nil {
+		return err
+	}
+	defer sess.stop()
+
+	if _, err := sess.Channel.QueueInspect(*queue); err != nil {
+		return err
+	}
+
+	sub, err := amqp.NewSubscriber(sess.Session,
+		amqp.SetSubscriberQueue(*queue),
+		amqp.SetSubscriberClose(sess.done),
+		amqp.SetSubscriberWaitGroup(sess.wg),
+	)
+	if err != nil {
+		return err
+	}
+
+	msgs, closed, err := sub.Consume()
+	if err != nil {
+		return err
+	}
+
+	for n := 0; *limit == 0 || n < *limit; n++ {
+		select {
+		case <-e.interrupt:
+			return nil
+		case <-closed:
+			return nil
+		case msg := <-msgs:
+			m := msg.(*amqp.Message)
+			if err := printMessage(e.stdout, m, *asJSON); err != nil {
+				return err
+			}
+			if !ack {
+				continue
+			}
+			if err := m.Ack(false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// count prints the ready messages and the consumers of each queue, one queue
+// per line.
+func count(e *env, args []string) error {
+	fs := e.flags("count")
+	queue := fs.String("queue", getenv("GOBUS_QUEUE", ""), "queue to count, used when no queue is given as argument")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	queues := fs.Args()
+	if len(queues) == 0 && *queue != "" {
+		queues = []string{*queue}
+	}
+	if len(queues) == 0 {
+		return errors.New("a queue is required")
+	}
+
+	sess, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer sess.stop()
+
+	for _, name := range queues {
+		q, err := sess.Channel.QueueInspect(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "%s\t%d\t%d\n", q.Name, q.Messages, q.Consumers)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/suite"
+)
+
+type GobusUnitSuite struct {
+	suite.Suite
+	srv *amqptest.Server
+}
+
+func (s *GobusUnitSuite) SetupTest() {
+	s.srv = amqptest.NewServer()
+
+	conn, err := amqp.Dial(s.srv.URL)
+	s.Require().NoError(err)
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	s.Require().NoError(err)
+	s.Require().NoError(ch.ExchangeDeclare("exchange", "topic", false, false, false, false, nil))
+	_, err = ch.QueueDeclare("queue", true, false, false, false, nil)
+	s.Require().NoError(err)
+	s.Require().NoError(ch.QueueBind("queue", "#", "exchange", false, nil))
+	_, err = ch.QueueDeclare("dead", true, false, false, false, nil)
+	s.Require().NoError(err)
+}
+
+func (s *GobusUnitSuite) TearDownTest() {
+	s.srv.Close()
+}
+
+func (s *GobusUnitSuite) run(stdin string, args ...string) (int, string, string) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := run(append([]string{"-dsn", s.srv.URL}, args...), &env{
+		stdin:     strings.NewReader(stdin),
+		stdout:    stdout,
+		stderr:    stderr,
+		interrupt: make(chan struct{}),
+	})
+	return code, stdout.String(), stderr.String()
+}
+
+func (s *GobusUnitSuite) TestPublishAndCount() {
+	assert := s.Assert()
+
+	code, _, stderr := s.run("", "publish", "-exchange", "exchange", "body 1", "body 2")
+	assert.Equal(0, code, stderr)
+
+	code, stdout, stderr := s.run("", "count", "queue")
+	assert.Equal(0, code, stderr)
+	assert.Equal("queue\t2\t0\n", stdout)
+}
+
+func (s *GobusUnitSuite) TestPublishLinesAndConsumeJSON() {
+	assert := s.Assert()
+
+	code, _, stderr := s.run("body 1\nbody 2\n", "publish", "-exchange", "exchange", "-key", "some.key", "-H", "key=value", "-lines")
+	assert.Equal(0, code, stderr)
+
+	code, stdout, stderr := s.run("", "consume", "-queue", "queue", "-n", "2", "-json")
+	assert.Equal(0, code, stderr)
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.Len(lines, 2)
+	for i, line := range lines {
+		d := delivery{}
+		assert.NoError(json.Unmarshal([]byte(line), &d))
+		assert.Equal("exchange", d.Exchange)
+		assert.Equal("some.key", d.RoutingKey)
+		assert.Equal("value", d.Headers["key"])
+		assert.Equal([]string{"body 1", "body 2"}[i], d.Body)
+	}
+
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(0, n)
+}
+
+func (s *GobusUnitSuite) TestTailDoesNotAck() {
+	assert := s.Assert()
+
+	code, _, stderr := s.run("", "publish", "-exchange", "exchange", "body 1", "body 2")
+	assert.Equal(0, code, stderr)
+
+	code, stdout, stderr := s.run("", "tail", "-queue", "queue", "-n", "2")
+	assert.Equal(0, code, stderr)
+	assert.Contains(stdout, "body 1")
+	assert.Contains(stdout, "body 2")
+
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(2, n)
+}
+
+func (s *GobusUnitSuite) TestConsumeUntilInterrupted() {
+	assert := s.Assert()
+
+	interrupt := make(chan struct{})
+	time.AfterFunc(time.Millisecond*100, func() { close(interrupt) })
+	code := run([]string{"-dsn", s.srv.URL, "consume", "-queue", "queue"}, &env{
+		stdout:    &bytes.Buffer{},
+		stderr:    &bytes.Buffer{},
+		interrupt: interrupt,
+	})
+	assert.Equal(0, code)
+}
+
+func (s *GobusUnitSuite) TestPublishNotConfirmed() {
+	assert := s.Assert()
+
+	code, _, stderr := s.run("", "publish", "-exchange", "unknown", "body")
+	assert.Equal(1, code)
+	assert.Contains(stderr, "not confirmed")
+}
+
+func (s *GobusUnitSuite) TestUnknownQueue() {
+	assert := s.Assert()
+
+	code, _, stderr := s.run("", "count", "unknown")
+	assert.Equal(1, code)
+	assert.Contains(stderr, "NOT_FOUND")
+}
+
+func (s *GobusUnitSuite) TestDumpAndRestore() {
+	assert := s.Assert()
+
+	code, _, stderr := s.run("", "publish", "-exchange", "exchange", "-H", "key=value", "body 1", "body 2")
+	assert.Equal(0, code, stderr)
+
+	code, archive, stderr := s.run("", "dump", "-queue", "queue", "-remove")
+	assert.Equal(0, code, stderr)
+	assert.Contains(stderr, "2 messages dumped")
+	assert.Len(strings.Split(strings.TrimSpace(archive), "\n"), 2)
+
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(0, n)
+
+	code, _, stderr = s.run(archive, "restore")
+	assert.Equal(0, code, stderr)
+	assert.Contains(stderr, "2 messages restored")
+
+	n, _ = s.srv.QueueLength("queue")
+	assert.Equal(2, n)
+}
+
+func (s *GobusUnitSuite) TestMove() {
+	assert := s.Assert()
+
+	code, _, stderr := s.run("", "publish", "-exchange", "exchange", "-H", "kind=a", "body 1")
+	assert.Equal(0, code, stderr)
+	code, _, stderr = s.run("", "publish", "-exchange", "exchange", "-H", "kind=b", "body 2")
+	assert.Equal(0, code, stderr)
+
+	code, _, stderr = s.run("", "move", "-from", "queue", "-to", "dead", "-H", "kind=b")
+	assert.Equal(0, code, stderr)
+	assert.Contains(stderr, "1 messages moved")
+
+	n, _ := s.srv.QueueLength("queue")
+	assert.Equal(1, n)
+	n, _ = s.srv.QueueLength("dead")
+	assert.Equal(1, n)
+}
+
+func (s *GobusUnitSuite) TestUsage() {
+	assert := s.Assert()
+
+	code, _, _ := s.run("")
+	assert.Equal(2, code)
+
+	code, _, stderr := s.run("", "unknown")
+	assert.Equal(2, code)
+	assert.Contains(stderr, "unknown command")
+
+	code, _, _ = s.run("", "publish", "-H", "invalid")
+	assert.Equal(2, code)
+}
+
+func TestGobusUnitSuite(t *testing.T) {
+	suite.Run(t, new(GobusUnitSuite))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/movidesk/go-bus/amqp"
+)
+
+// delivery is the json line printed for each message.
+type delivery struct {
+	Exchange    string                 `json:"exchange"`
+	RoutingKey  string                 `json:"routing_key"`
+	Redelivered bool                   `json:"redelivered"`
+	MessageID   string                 `json:"message_id,omitempty"`
+	Timestamp   string                 `json:"timestamp,omitempty"`
+	Headers     map[string]interface{} `json:"headers,omitempty"`
+	// Body is set when the body is valid utf-8, BodyBase64 otherwise.
+	Body       string `json:"body,omitempty"`
+	BodyBase64 []byte `json:"body_base64,omitempty"`
+}
+
+func printMessage(w io.Writer, m *amqp.Message, asJSON bool) error {
+	if asJSON {
+		return printJSON(w, m)
+	}
+
+	fmt.Fprintf(w, "exchange: %q routing_key: %q redelivered: %t\n", m.Exchange, m.RoutingKey, m.Redelivered)
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s: %v\n", k, m.Headers[k])
+	}
+	_, err := fmt.Fprintf(w, "\n%s\n\n", m.Body)
+	return err
+}
+
+func printJSON(w io.Writer, m *amqp.Message) error {
+	d := delivery{
+		Exchange:    m.Exchange,
+		RoutingKey:  m.RoutingKey,
+		Redelivered: m.Redelivered,
+		MessageID:   m.MessageId,
+		Headers:     m.Headers,
+	}
+	if !m.Timestamp.IsZero() {
+		d.Timestamp = m.Timestamp.Format(time.RFC3339)
+	}
+	if utf8.Valid(m.Body) {
+		d.Body = string(m.Body)
+	} else {
+		d.BodyBase64 = m.Body
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/movidesk/go-bus/amqp"
+)
+
+// headersFlag collects repeated key=value flags into message headers.
+type headersFlag map[string]interface{}
+
+func (h headersFlag) String() string {
+	pairs := make([]string, 0, len(h))
+	for k, v := range h {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h headersFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("header %q is not in the key=value form", v)
+	}
+	h[kv[0]] = kv[1]
+	return nil
+}
+
+// filesFlag collects repeated file flags.
+type filesFlag []string
+
+func (f *filesFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *filesFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func publish(e *env, args []string) error {
+	fs := e.flags("publish")
+	exchange := fs.String("exchange", getenv("GOBUS_EXCHANGE", ""), "exchange to publish to, the default exchange when empty")
+	key := fs.String("key", "", "routing key of the messages")
+	lines := fs.Bool("lines", false, "publish each line read from stdin as a message")
+	headers := headersFlag{}
+	fs.Var(headers, "H", "header as key=value added to every message, may be repeated")
+	files := filesFlag{}
+	fs.Var(&files, "f", "file published as a message, may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	bodies, err := e.bodies(fs.Args(), files, *lines)
+	if err != nil {
+		return err
+	}
+
+	sess, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer sess.stop()
+
+	pub, err := amqp.NewPublisher(sess.Session,
+		amqp.SetPublisherExchange(*exchange),
+		amqp.SetPublisherKey(*key),
+		amqp.SetPublisherClose(sess.done),
+		amqp.SetPublisherWaitGroup(sess.wg),
+	)
+	if err != nil {
+		return err
+	}
+
+	for i, body := range bodies {
+		err, ok := pub.Publish(&amqp.Message{
+			Headers: headers,
+			Body:    body,
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("message %d was not confirmed", i+1)
+		}
+	}
+
+	return nil
+}
+
+// bodies reads the messages to publish, the args come first, then the files.
+// Stdin is read only when neither is given.
+func (e *env) bodies(args, files []string, lines bool) ([][]byte, error) {
+	bodies := make([][]byte, 0, len(args)+len(files))
+	for _, arg := range args {
+		bodies = append(bodies, []byte(arg))
+	}
+
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+
+	if len(bodies) > 0 {
+		return bodies, nil
+	}
+
+	if !lines {
+		body, err := ioutil.ReadAll(e.stdin)
+		if err != nil {
+			return nil, err
+		}
+		return append(bodies, body), nil
+	}
+
+	scanner := bufio.NewScanner(e.stdin)
+	scanner.Buffer(make([]byte, 64*1024), 128*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		bodies = append(bodies, append([]byte(nil), scanner.Bytes()...))
+	}
+	return bodies, scanner.Err()
+}
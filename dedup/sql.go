@@ -0,0 +1,176 @@
+package dedup
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BindVar is the placeholder style of the sql driver.
+type BindVar int
+
+const (
+	// BindQuestion uses ? placeholders, as MySQL and SQLite do.
+	BindQuestion BindVar = iota
+	// BindDollar uses $1 placeholders, as PostgreSQL does.
+	BindDollar
+)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+type SQLStoreOptionsFn func(*SQLStoreOptions)
+
+type SQLStoreOptions struct {
+	table   string
+	ttl     time.Duration
+	bindVar BindVar
+}
+
+// SetSQLStoreTable sets the table keeping the keys, it defaults to bus_dedup.
+func SetSQLStoreTable(table string) SQLStoreOptionsFn {
+	return func(o *SQLStoreOptions) {
+		o.table = table
+	}
+}
+
+// SetSQLStoreTTL sets how long a key is remembered.
+func SetSQLStoreTTL(ttl time.Duration) SQLStoreOptionsFn {
+	return func(o *SQLStoreOptions) {
+		o.ttl = ttl
+	}
+}
+
+func SetSQLStoreBindVar(bindVar BindVar) SQLStoreOptionsFn {
+	return func(o *SQLStoreOptions) {
+		o.bindVar = bindVar
+	}
+}
+
+// SQLStore is a Store kept in a sql table, so every process consuming a queue
+// shares it. The table has the schema created by CreateTable:
+//
+//	CREATE TABLE bus_dedup (
+//		id VARCHAR(255) NOT NULL PRIMARY KEY,
+//		expires_at BIGINT NOT NULL
+//	)
+//
+// where expires_at is a unix time in nanoseconds. Expired keys are ignored
+// and removed by Purge.
+type SQLStore struct {
+	*SQLStoreOptions
+
+	db  *sql.DB
+	now func() time.Time
+}
+
+func MustSQLStore(db *sql.DB, fns ...SQLStoreOptionsFn) *SQLStore {
+	s, err := NewSQLStore(db, fns...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func NewSQLStore(db *sql.DB, fns ...SQLStoreOptionsFn) (*SQLStore, error) {
+	o := &SQLStoreOptions{}
+	SetSQLStoreTable("bus_dedup")(o)
+	SetSQLStoreTTL(24 * time.Hour)(o)
+	SetSQLStoreBindVar(BindQuestion)(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if db == nil {
+		return nil, errors.New("Could not create sql store, db is required")
+	}
+	if !tableName.MatchString(o.table) {
+		return nil, errors.Errorf("Could not create sql store, invalid table name %q", o.table)
+	}
+	if o.ttl <= 0 {
+		return nil, errors.New("Could not create sql store, ttl must be positive")
+	}
+
+	return &SQLStore{
+		SQLStoreOptions: o,
+		db:              db,
+		now:             time.Now,
+	}, nil
+}
+
+// CreateTable creates the table of the store unless it exists.
+func (s *SQLStore) CreateTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (id VARCHAR(255) NOT NULL PRIMARY KEY, expires_at BIGINT NOT NULL)",
+		s.table,
+	))
+	return err
+}
+
+func (s *SQLStore) Seen(key string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(
+		s.query("SELECT COUNT(*) FROM %s WHERE id = ? AND expires_at > ?"),
+		key, s.now().UnixNano(),
+	).Scan(&n)
+	if err != nil {
+		return false, errors.Wrap(err, "Could not look up key")
+	}
+	return n > 0, nil
+}
+
+// Mark refreshes the expiration of a known key or inserts it. When another
+// process inserts the same key concurrently the insert fails and the key is
+// looked up again.
+func (s *SQLStore) Mark(key string) error {
+	expires := s.now().Add(s.ttl).UnixNano()
+
+	res, err := s.db.Exec(s.query("UPDATE %s SET expires_at = ? WHERE id = ?"), expires, key)
+	if err != nil {
+		return errors.Wrap(err, "Could not mark key")
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+
+	_, err = s.db.Exec(s.query("INSERT INTO %s (id, expires_at) VALUES (?, ?)"), key, expires)
+	if err != nil {
+		if seen, serr := s.Seen(key); serr == nil && seen {
+			return nil
+		}
+		return errors.Wrap(err, "Could not mark key")
+	}
+	return nil
+}
+
+// Purge deletes the expired keys and returns how many were deleted.
+func (s *SQLStore) Purge() (int64, error) {
+	res, err := s.db.Exec(s.query("DELETE FROM %s WHERE expires_at <= ?"), s.now().UnixNano())
+	if err != nil {
+		return 0, errors.Wrap(err, "Could not purge keys")
+	}
+	return res.RowsAffected()
+}
+
+// query sets the table name and rewrites the placeholders for the bind var.
+func (s *SQLStore) query(q string) string {
+	q = fmt.Sprintf(q, s.table)
+	if s.bindVar != BindDollar {
+		return q
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,203 @@
+package dedup
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeDriver understands the few statements of SQLStore, it keeps a single
+// table per dsn.
+type fakeDriver struct {
+	mu     sync.Mutex
+	tables map[string]map[string]int64
+}
+
+func (d *fakeDriver) Open(dsn string) (driver.Conn, error) {
+	return &fakeConn{d: d, dsn: dsn}, nil
+}
+
+type fakeConn struct {
+	d   *fakeDriver
+	dsn string
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error { return nil }
+
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.c.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows := d.tables[s.c.dsn]
+	switch {
+	case strings.HasPrefix(s.query, "CREATE"):
+		if rows == nil {
+			d.tables[s.c.dsn] = make(map[string]int64)
+		}
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "UPDATE"):
+		key := args[1].(string)
+		if _, ok := rows[key]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		rows[key] = args[0].(int64)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "INSERT"):
+		key := args[0].(string)
+		if _, ok := rows[key]; ok {
+			return nil, errors.New("duplicate key")
+		}
+		rows[key] = args[1].(int64)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "DELETE"):
+		n := int64(0)
+		for key, expires := range rows {
+			if expires <= args[0].(int64) {
+				delete(rows, key)
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, errors.New("unexpected statement " + s.query)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	d := s.c.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := int64(0)
+	if expires, ok := d.tables[s.c.dsn][args[0].(string)]; ok && expires > args[1].(int64) {
+		n = 1
+	}
+	return &fakeRows{values: []driver.Value{n}}, nil
+}
+
+type fakeRows struct {
+	values []driver.Value
+	read   bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"count"} }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	copy(dest, r.values)
+	return nil
+}
+
+var fake = &fakeDriver{tables: make(map[string]map[string]int64)}
+
+func init() {
+	sql.Register("dedupfake", fake)
+}
+
+type SQLStoreUnitSuite struct {
+	suite.Suite
+
+	db    *sql.DB
+	store *SQLStore
+	now   time.Time
+}
+
+func (s *SQLStoreUnitSuite) SetupTest() {
+	fake.mu.Lock()
+	delete(fake.tables, s.T().Name())
+	fake.mu.Unlock()
+
+	db, err := sql.Open("dedupfake", s.T().Name())
+	s.Require().NoError(err)
+	s.db = db
+
+	s.now = time.Now()
+	s.store = MustSQLStore(db, SetSQLStoreTTL(time.Minute))
+	s.store.now = func() time.Time { return s.now }
+	s.Require().NoError(s.store.CreateTable())
+}
+
+func (s *SQLStoreUnitSuite) TearDownTest() {
+	s.db.Close()
+}
+
+func (s *SQLStoreUnitSuite) TestMarkAndSeen() {
+	assert := s.Assert()
+
+	seen, err := s.store.Seen("a")
+	assert.NoError(err)
+	assert.False(seen)
+
+	assert.NoError(s.store.Mark("a"))
+	assert.NoError(s.store.Mark("a"))
+	seen, err = s.store.Seen("a")
+	assert.NoError(err)
+	assert.True(seen)
+}
+
+func (s *SQLStoreUnitSuite) TestExpirationAndPurge() {
+	assert := s.Assert()
+
+	assert.NoError(s.store.Mark("a"))
+	s.now = s.now.Add(time.Second * 30)
+	assert.NoError(s.store.Mark("b"))
+
+	s.now = s.now.Add(time.Second * 30)
+	seen, _ := s.store.Seen("a")
+	assert.False(seen)
+	seen, _ = s.store.Seen("b")
+	assert.True(seen)
+
+	n, err := s.store.Purge()
+	assert.NoError(err)
+	assert.Equal(int64(1), n)
+}
+
+func (s *SQLStoreUnitSuite) TestBindDollar() {
+	assert := s.Assert()
+
+	store := MustSQLStore(s.db, SetSQLStoreBindVar(BindDollar), SetSQLStoreTable("dedup"))
+	assert.Equal("UPDATE dedup SET expires_at = $1 WHERE id = $2", store.query("UPDATE %s SET expires_at = ? WHERE id = ?"))
+}
+
+func (s *SQLStoreUnitSuite) TestInvalidOptions() {
+	assert := s.Assert()
+
+	_, err := NewSQLStore(nil)
+	assert.Error(err)
+	_, err = NewSQLStore(s.db, SetSQLStoreTable("dedup; DROP TABLE users"))
+	assert.Error(err)
+	_, err = NewSQLStore(s.db, SetSQLStoreTTL(0))
+	assert.Error(err)
+}
+
+func TestSQLStoreUnitSuite(t *testing.T) {
+	suite.Run(t, new(SQLStoreUnitSuite))
+}
@@ -0,0 +1,121 @@
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Store remembers the keys of the messages already processed.
+type Store interface {
+	// Seen reports whether the key was marked and has not expired yet.
+	Seen(key string) (bool, error)
+	// Mark records the key as processed.
+	Mark(key string) error
+}
+
+type MemoryStoreOptionsFn func(*MemoryStoreOptions)
+
+type MemoryStoreOptions struct {
+	size int
+	ttl  time.Duration
+}
+
+// SetMemoryStoreSize bounds the number of keys kept, the least recently
+// marked keys are evicted first.
+func SetMemoryStoreSize(size int) MemoryStoreOptionsFn {
+	return func(o *MemoryStoreOptions) {
+		o.size = size
+	}
+}
+
+// SetMemoryStoreTTL sets how long a key is remembered, zero keeps the keys
+// until they are evicted.
+func SetMemoryStoreTTL(ttl time.Duration) MemoryStoreOptionsFn {
+	return func(o *MemoryStoreOptions) {
+		o.ttl = ttl
+	}
+}
+
+// MemoryStore is a Store kept in memory with LRU eviction and expiration. It
+// only deduplicates the deliveries of one process.
+type MemoryStore struct {
+	*MemoryStoreOptions
+
+	mu    sync.Mutex
+	keys  map[string]*list.Element
+	order *list.List
+	now   func() time.Time
+}
+
+type entry struct {
+	key     string
+	expires time.Time
+}
+
+func NewMemoryStore(fns ...MemoryStoreOptionsFn) *MemoryStore {
+	o := &MemoryStoreOptions{}
+	SetMemoryStoreSize(10000)(o)
+	SetMemoryStoreTTL(time.Hour)(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	return &MemoryStore{
+		MemoryStoreOptions: o,
+		keys:               make(map[string]*list.Element),
+		order:              list.New(),
+		now:                time.Now,
+	}
+}
+
+func (s *MemoryStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.keys[key]
+	if !ok {
+		return false, nil
+	}
+
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && !s.now().Before(e.expires) {
+		s.order.Remove(el)
+		delete(s.keys, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) Mark(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expires time.Time
+	if s.ttl > 0 {
+		expires = s.now().Add(s.ttl)
+	}
+
+	if el, ok := s.keys[key]; ok {
+		el.Value.(*entry).expires = expires
+		s.order.MoveToFront(el)
+		return nil
+	}
+
+	s.keys[key] = s.order.PushFront(&entry{key: key, expires: expires})
+	for s.size > 0 && s.order.Len() > s.size {
+		el := s.order.Back()
+		s.order.Remove(el)
+		delete(s.keys, el.Value.(*entry).key)
+	}
+	return nil
+}
+
+// Len returns the number of keys kept, expired keys included until they are
+// looked up or evicted.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.order.Len()
+}
@@ -0,0 +1,66 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MemoryStoreUnitSuite struct {
+	suite.Suite
+}
+
+func (s *MemoryStoreUnitSuite) TestMarkAndSeen() {
+	assert := s.Assert()
+
+	store := NewMemoryStore()
+	seen, err := store.Seen("a")
+	assert.NoError(err)
+	assert.False(seen)
+
+	assert.NoError(store.Mark("a"))
+	seen, err = store.Seen("a")
+	assert.NoError(err)
+	assert.True(seen)
+}
+
+func (s *MemoryStoreUnitSuite) TestExpiration() {
+	assert := s.Assert()
+
+	now := time.Now()
+	store := NewMemoryStore(SetMemoryStoreTTL(time.Minute))
+	store.now = func() time.Time { return now }
+
+	assert.NoError(store.Mark("a"))
+	now = now.Add(time.Second * 59)
+	seen, _ := store.Seen("a")
+	assert.True(seen)
+
+	now = now.Add(time.Second)
+	seen, _ = store.Seen("a")
+	assert.False(seen)
+	assert.Equal(0, store.Len())
+}
+
+func (s *MemoryStoreUnitSuite) TestEvictsLeastRecentlyMarked() {
+	assert := s.Assert()
+
+	store := NewMemoryStore(SetMemoryStoreSize(2))
+	assert.NoError(store.Mark("a"))
+	assert.NoError(store.Mark("b"))
+	assert.NoError(store.Mark("a"))
+	assert.NoError(store.Mark("c"))
+
+	assert.Equal(2, store.Len())
+	seen, _ := store.Seen("a")
+	assert.True(seen)
+	seen, _ = store.Seen("b")
+	assert.False(seen)
+	seen, _ = store.Seen("c")
+	assert.True(seen)
+}
+
+func TestMemoryStoreUnitSuite(t *testing.T) {
+	suite.Run(t, new(MemoryStoreUnitSuite))
+}
@@ -0,0 +1,210 @@
+// Package dedup drops the messages a subscriber already processed. The broker
+// delivers at least once, so a message whose ack was lost, for instance on a
+// reconnection, is delivered again; the subscriber of this package acks such
+// duplicates itself and never hands them to the handler.
+package dedup
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+)
+
+type SubscriberOptionsFn func(*SubscriberOptions)
+
+type SubscriberOptions struct {
+	key        func(base.Message) string
+	deliveries chan base.Message
+}
+
+// SetSubscriberKey sets how the key of a message is found, messages with an
+// empty key are never deduplicated. By default the key is the message id of
+// the delivery.
+func SetSubscriberKey(key func(base.Message) string) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.key = key
+	}
+}
+
+// SetSubscriberHeader keys the messages on the value of a header.
+func SetSubscriberHeader(header string) SubscriberOptionsFn {
+	return SetSubscriberKey(func(msg base.Message) string {
+		v, ok := msg.GetHeaders()[header]
+		if !ok || v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	})
+}
+
+func SetSubscriberDeliveries(deliveries chan base.Message) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.deliveries = deliveries
+	}
+}
+
+func messageId(msg base.Message) string {
+	return msg.DeliveryInfo().MessageId
+}
+
+type Subscriber interface {
+	base.Subscriber
+}
+
+type sub struct {
+	*SubscriberOptions
+
+	base.Subscriber
+	store Store
+
+	mu        sync.Mutex
+	consuming bool
+	closed    bool
+	done      <-chan struct{}
+}
+
+func MustSubscriber(s base.Subscriber, store Store, fns ...SubscriberOptionsFn) Subscriber {
+	sub, err := NewSubscriber(s, store, fns...)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// NewSubscriber wraps a subscriber of any backend so it skips the messages
+// whose key the store has seen.
+func NewSubscriber(s base.Subscriber, store Store, fns ...SubscriberOptionsFn) (Subscriber, error) {
+	o := &SubscriberOptions{}
+	SetSubscriberKey(messageId)(o)
+	SetSubscriberDeliveries(make(chan base.Message, 1))(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if s == nil {
+		return nil, errors.New("Could not create subscriber, subscriber is required")
+	}
+	if store == nil {
+		return nil, errors.New("Could not create subscriber, store is required")
+	}
+
+	return &sub{
+		SubscriberOptions: o,
+		Subscriber:        s,
+		store:             store,
+	}, nil
+}
+
+func (s *sub) Consume() (<-chan base.Message, <-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		done := make(chan struct{})
+		close(done)
+		return s.deliveries, done, errors.New("Could not consume, subscriber is closed")
+	}
+	if s.consuming {
+		return s.deliveries, s.done, nil
+	}
+
+	msgs, done, err := s.Subscriber.Consume()
+	if err != nil {
+		return s.deliveries, done, err
+	}
+	s.consuming = true
+	s.done = done
+
+	go s.loop(msgs, done)
+
+	return s.deliveries, done, nil
+}
+
+func (s *sub) loop(msgs <-chan base.Message, done <-chan struct{}) {
+	defer close(s.deliveries)
+
+	for {
+		select {
+		case <-done:
+			return
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+
+			msg, duplicate := s.filter(msg)
+			if duplicate {
+				continue
+			}
+
+			select {
+			case s.deliveries <- msg:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// filter acks the message when it is a duplicate, otherwise it returns the
+// message wrapped so its key is marked once it is acked. A failing store lets
+// the message through, delivering twice is safer than dropping.
+func (s *sub) filter(msg base.Message) (base.Message, bool) {
+	key := s.key(msg)
+	if key == "" {
+		return msg, false
+	}
+
+	seen, err := s.store.Seen(key)
+	if err != nil {
+		log.Printf("dedup lookup failed, err: %v\n", err)
+		return msg, false
+	}
+
+	if seen {
+		if err := msg.Ack(false); err != nil {
+			log.Printf("dedup ack of duplicate failed, err: %v\n", err)
+		}
+		return nil, true
+	}
+
+	return &Message{Message: msg, key: key, store: s.store}, false
+}
+
+func (s *sub) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Subscriber.Close()
+	if !s.consuming && !s.closed {
+		close(s.deliveries)
+	}
+	s.closed = true
+}
+
+// Message is a message handed by a dedup subscriber. It marks its key in the
+// store when it is acked, before acking it on the backend, so a message
+// processed whose ack is lost is still recognized when it comes back.
+type Message struct {
+	base.Message
+
+	key   string
+	store Store
+}
+
+// Ack marks the key and acks the message. Acking with multiple set only marks
+// this message, the messages it acks along with it are not marked.
+func (m *Message) Ack(multiple bool) error {
+	if err := m.store.Mark(m.key); err != nil {
+		return errors.Wrap(err, "Could not ack message, marking it failed")
+	}
+	return m.Message.Ack(multiple)
+}
+
+// Key returns the key the message was deduplicated on.
+func (m *Message) Key() string {
+	return m.key
+}
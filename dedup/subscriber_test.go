@@ -0,0 +1,173 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/amqp"
+	"github.com/movidesk/go-bus/amqp/amqptest"
+	"github.com/movidesk/go-bus/bustest"
+	"github.com/movidesk/go-bus/proc"
+	streadway "github.com/streadway/amqp"
+	"github.com/stretchr/testify/suite"
+)
+
+type SubscriberUnitSuite struct {
+	suite.Suite
+}
+
+// countingSubscriber counts the calls to Consume.
+type countingSubscriber struct {
+	*bustest.Subscriber
+	consumed int
+}
+
+func (s *countingSubscriber) Consume() (<-chan base.Message, <-chan struct{}, error) {
+	s.consumed++
+	return s.Subscriber.Consume()
+}
+
+func (s *SubscriberUnitSuite) receive(msgs <-chan base.Message) base.Message {
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(time.Second):
+		s.FailNow("no message received")
+		return nil
+	}
+}
+
+func (s *SubscriberUnitSuite) nothing(msgs <-chan base.Message) {
+	select {
+	case msg := <-msgs:
+		s.Failf("unexpected message", "%s", msg.GetBody())
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func (s *SubscriberUnitSuite) TestSkipsDuplicatedMessageId() {
+	assert := s.Assert()
+
+	srv := amqptest.NewServer()
+	defer srv.Close()
+
+	conn, err := streadway.Dial(srv.URL)
+	s.Require().NoError(err)
+	ch, err := conn.Channel()
+	s.Require().NoError(err)
+	_, err = ch.QueueDeclare("queue", true, false, false, false, nil)
+	s.Require().NoError(err)
+	conn.Close()
+
+	bus := amqp.MustBus(amqp.SetBusDSN(srv.URL))
+	defer bus.Close()
+
+	pub := bus.MustPublisher(amqp.SetPublisherKey("queue"))
+	for _, id := range []string{"1", "1", "2"} {
+		err, ok := pub.Publish(&amqp.Message{MessageId: id, Body: []byte(id)})
+		assert.NoError(err)
+		assert.True(ok)
+	}
+
+	sub := MustSubscriber(bus.MustSubscriber(amqp.SetSubscriberQueue("queue")), NewMemoryStore())
+	msgs, _, err := sub.Consume()
+	assert.NoError(err)
+
+	msg := s.receive(msgs)
+	assert.Equal("1", string(msg.GetBody()))
+	assert.Equal("1", msg.(*Message).Key())
+	assert.NoError(msg.Ack(false))
+
+	msg = s.receive(msgs)
+	assert.Equal("2", string(msg.GetBody()))
+	assert.NoError(msg.Ack(false))
+
+	s.nothing(msgs)
+	n, _ := srv.QueueLength("queue")
+	assert.Equal(0, n)
+}
+
+func (s *SubscriberUnitSuite) TestNackedMessageIsNotMarked() {
+	assert := s.Assert()
+
+	c := make(chan base.Message, 10)
+	bus, _ := proc.NewBus(proc.SetIn(c), proc.SetOut(c))
+	defer bus.Close()
+
+	pub, _ := bus.NewPublisher()
+	inner, _ := bus.NewSubscriber()
+	sub := MustSubscriber(inner, NewMemoryStore(), SetSubscriberHeader("id"))
+	msgs, _, _ := sub.Consume()
+
+	err, _ := pub.Publish(&proc.Message{})
+	assert.NoError(err)
+	msg := &proc.Message{}
+	msg.SetHeaders(map[string]interface{}{"id": 1})
+	err, _ = pub.Publish(msg)
+	assert.NoError(err)
+
+	received := s.receive(msgs)
+	assert.Nil(received.GetHeaders())
+	assert.NoError(received.Ack(false))
+
+	received = s.receive(msgs)
+	assert.NoError(received.Nack(false, true))
+
+	received = s.receive(msgs)
+	assert.Equal(1, received.GetHeaders()["id"])
+	assert.NoError(received.Ack(false))
+
+	err, _ = pub.Publish(msg)
+	assert.NoError(err)
+	s.nothing(msgs)
+}
+
+func (s *SubscriberUnitSuite) TestCloseBeforeConsume() {
+	assert := s.Assert()
+
+	c := make(chan base.Message, 1)
+	bus, _ := proc.NewBus(proc.SetIn(c), proc.SetOut(c))
+	defer bus.Close()
+
+	inner, _ := bus.NewSubscriber()
+	sub := MustSubscriber(inner, NewMemoryStore())
+	sub.Close()
+
+	msgs, done, err := sub.Consume()
+	assert.Error(err)
+	_, ok := <-msgs
+	assert.False(ok)
+	<-done
+}
+
+func (s *SubscriberUnitSuite) TestConsumeTwice() {
+	assert := s.Assert()
+
+	inner := &countingSubscriber{Subscriber: bustest.NewSubscriber(1)}
+	sub := MustSubscriber(inner, NewMemoryStore())
+
+	msgs, done, err := sub.Consume()
+	assert.NoError(err)
+	again, doneAgain, err := sub.Consume()
+	assert.NoError(err)
+	assert.Equal(msgs, again)
+	assert.Equal(done, doneAgain)
+	assert.Equal(1, inner.consumed)
+}
+
+func (s *SubscriberUnitSuite) TestRequiresStore() {
+	assert := s.Assert()
+
+	c := make(chan base.Message, 1)
+	bus, _ := proc.NewBus(proc.SetIn(c), proc.SetOut(c))
+	defer bus.Close()
+
+	inner, _ := bus.NewSubscriber()
+	_, err := NewSubscriber(inner, nil)
+	assert.Error(err)
+}
+
+func TestSubscriberUnitSuite(t *testing.T) {
+	suite.Run(t, new(SubscriberUnitSuite))
+}
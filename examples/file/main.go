@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/file"
+)
+
+func main() {
+	bus, err := file.NewBus(
+		file.SetBusDir("/tmp/go-bus"),
+		file.SetBusSyncPolicy(file.SyncInterval),
+	)
+	if err != nil {
+		log.Fatal("Unable to create file bus")
+	}
+
+	pub, err := bus.NewPublisher(file.SetPublisherTopic("events"))
+	if err != nil {
+		log.Fatal("unable to create publisher")
+	}
+
+	sub, err := bus.NewSubscriber(
+		file.SetSubscriberTopic("events"),
+		file.SetSubscriberGroup("example"),
+	)
+	if err != nil {
+		log.Fatal("unable to create subscriber")
+	}
+
+	go publish(pub, time.Second)
+	go subscribe(sub)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := bus.Shutdown(ctx); err != nil {
+		log.Printf("shutdown failed: %v\n", err)
+	}
+}
+
+func publish(pub base.Publisher, d time.Duration) {
+	c := 0
+	for {
+		c++
+		time.Sleep(d)
+		err, ok := pub.Publish(&file.Message{
+			Headers: map[string]interface{}{
+				"counter": c,
+			},
+		})
+		if err != nil {
+			log.Printf("publish failed: %v\n", err)
+			return
+		}
+		if ok {
+			log.Println("publish has been confirmed")
+		}
+	}
+}
+
+func subscribe(sub base.Subscriber) {
+	msgs, done, err := sub.Consume()
+	defer sub.Close()
+	if err != nil {
+		log.Printf("unable to subscribe: %v\n", err)
+		return
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case msg := <-msgs:
+			msg.Ack(false)
+			log.Printf("acked message %+v\n", msg.GetHeaders())
+		}
+	}
+}
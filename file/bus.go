@@ -0,0 +1,363 @@
+package file
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+)
+
+// SyncPolicy tells when appended records are flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs the segment before a publish is confirmed.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs dirty segments every sync interval.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+type BusOptionsFn func(*BusOptions)
+
+type BusOptions struct {
+	dir string
+
+	policy   SyncPolicy
+	interval time.Duration
+
+	segmentSize int64
+
+	wg *sync.WaitGroup
+}
+
+// SetBusDir specifies the directory where topics, segments and consumer
+// offsets are stored. A directory must be used by a single process at a time.
+func SetBusDir(dir string) BusOptionsFn {
+	return func(o *BusOptions) {
+		o.dir = dir
+	}
+}
+
+func SetBusSyncPolicy(policy SyncPolicy) BusOptionsFn {
+	return func(o *BusOptions) {
+		o.policy = policy
+	}
+}
+
+// SetBusSyncInterval specifies how often dirty segments are flushed when the
+// sync policy is SyncInterval.
+func SetBusSyncInterval(interval time.Duration) BusOptionsFn {
+	return func(o *BusOptions) {
+		o.interval = interval
+	}
+}
+
+// SetBusSegmentSize specifies the size in bytes after which a new segment file
+// is started.
+func SetBusSegmentSize(size int64) BusOptionsFn {
+	return func(o *BusOptions) {
+		o.segmentSize = size
+	}
+}
+
+func SetBusWaitGroup(wg *sync.WaitGroup) BusOptionsFn {
+	return func(o *BusOptions) {
+		o.wg = wg
+	}
+}
+
+type Bus interface {
+	base.Bus
+
+	NewPublisher(fns ...PublisherOptionsFn) (base.Publisher, error)
+	MustPublisher(fns ...PublisherOptionsFn) base.Publisher
+	NewSubscriber(fns ...SubscriberOptionsFn) (base.Subscriber, error)
+	MustSubscriber(fns ...SubscriberOptionsFn) base.Subscriber
+}
+
+type bus struct {
+	_ struct{}
+	*BusOptions
+
+	mu     sync.Mutex
+	logs   map[string]*Log
+	groups map[string]bool
+
+	users   sync.WaitGroup
+	close   chan struct{}
+	closing sync.Once
+	wg      *sync.WaitGroup
+}
+
+func MustBus(fns ...BusOptionsFn) Bus {
+	bus, err := NewBus(fns...)
+	if err != nil {
+		panic(err)
+	}
+	return bus
+}
+
+func NewBus(fns ...BusOptionsFn) (Bus, error) {
+	o := &BusOptions{}
+	SetBusDir("bus")(o)
+	SetBusSyncPolicy(SyncAlways)(o)
+	SetBusSyncInterval(time.Second)(o)
+	SetBusSegmentSize(64 << 20)(o)
+	SetBusWaitGroup(&sync.WaitGroup{})(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if o.segmentSize <= 0 {
+		return nil, errors.New("Could not create bus, segment size must be positive")
+	}
+
+	b := &bus{
+		BusOptions: o,
+		logs:       make(map[string]*Log),
+		groups:     make(map[string]bool),
+		wg:         o.wg,
+		close:      make(chan struct{}),
+	}
+
+	b.wg.Add(1)
+	go b.loop()
+
+	return b, nil
+}
+
+func (b *bus) MustPublisher(fns ...PublisherOptionsFn) base.Publisher {
+	pub, err := b.NewPublisher(fns...)
+	if err != nil {
+		panic(err)
+	}
+	return pub
+}
+
+func (b *bus) NewPublisher(fns ...PublisherOptionsFn) (base.Publisher, error) {
+	o := &PublisherOptions{}
+	SetPublisherTopic("default")(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if !validName(o.topic) {
+		return nil, errors.Errorf("Could not create publisher, topic %q is not a valid name", o.topic)
+	}
+
+	l, err := b.log(o.topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pub{
+		PublisherOptions: o,
+		log:              l,
+		close:            b.close,
+	}, nil
+}
+
+func (b *bus) MustSubscriber(fns ...SubscriberOptionsFn) base.Subscriber {
+	sub, err := b.NewSubscriber(fns...)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+func (b *bus) NewSubscriber(fns ...SubscriberOptionsFn) (base.Subscriber, error) {
+	o := &SubscriberOptions{}
+	SetSubscriberTopic("default")(o)
+	SetSubscriberGroup("default")(o)
+	SetSubscriberPrefetch(1)(o)
+	SetSubscriberDeliveries(make(chan base.Message, 1))(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if o.prefetch < 1 {
+		return nil, errors.New("Could not create subscriber, prefetch must be at least 1")
+	}
+	if !validName(o.topic) {
+		return nil, errors.Errorf("Could not create subscriber, topic %q is not a valid name", o.topic)
+	}
+	if !validName(o.group) {
+		return nil, errors.Errorf("Could not create subscriber, group %q is not a valid name", o.group)
+	}
+
+	l, err := b.log(o.topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.join(o.topic, o.group); err != nil {
+		return nil, err
+	}
+
+	offsets, err := newOffsetStore(filepath.Join(b.dir, o.topic), o.group, b.policy == SyncAlways)
+	if err != nil {
+		return nil, err
+	}
+
+	committed, err := offsets.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sub{
+		SubscriberOptions: o,
+		log:               l,
+		offsets:           offsets,
+		committed:         committed,
+		next:              committed,
+		unacked:           make(map[uint64]struct{}),
+		requeued:          make([]uint64, 0),
+		settled:           make(chan struct{}, 1),
+		stopped:           make(chan struct{}),
+		bus:               b,
+		close:             b.close,
+		quit:              make(chan struct{}),
+	}, nil
+}
+
+func (b *bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closing.Do(func() { close(b.close) })
+}
+
+func (b *bus) Wait() {
+	b.wg.Wait()
+}
+
+func (b *bus) Shutdown(timeout context.Context) error {
+	b.Close()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		b.Wait()
+	}()
+
+	select {
+	case <-timeout.Done():
+		return errors.New("closed by timeout")
+	case <-closed:
+		return nil
+	}
+}
+
+// validName tells whether a topic or group name can be used as a file name
+// within the bus directory.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+func (b *bus) log(topic string) (*Log, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.close:
+		return nil, ErrClosed
+	default:
+	}
+
+	if l, ok := b.logs[topic]; ok {
+		return l, nil
+	}
+
+	l, err := openLog(filepath.Join(b.dir, topic), b.segmentSize, b.policy)
+	if err != nil {
+		return nil, err
+	}
+	b.logs[topic] = l
+	return l, nil
+}
+
+// join registers the consumer group of a subscriber, a group tracks a single
+// set of offsets so it can only be consumed by one subscriber at a time.
+func (b *bus) join(topic, group string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := filepath.Join(topic, group)
+	if b.groups[key] {
+		return errors.Errorf("Could not create subscriber, group %s of topic %s is already consumed", group, topic)
+	}
+	b.groups[key] = true
+	return nil
+}
+
+func (b *bus) leave(topic, group string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.groups, filepath.Join(topic, group))
+}
+
+// enter tracks a subscriber goroutine so logs are only closed after it exits.
+func (b *bus) enter() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.close:
+		return false
+	default:
+	}
+
+	b.users.Add(1)
+	return true
+}
+
+func (b *bus) sync() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for topic, l := range b.logs {
+		if err := l.Sync(); err != nil {
+			log.Printf("sync of topic %s failed, err: %v\n", topic, err)
+		}
+	}
+}
+
+func (b *bus) loop() {
+	defer b.wg.Done()
+
+	var tick <-chan time.Time
+	if b.policy == SyncInterval {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	running := true
+out:
+	for running {
+		select {
+		case <-b.close:
+			running = false
+			break out
+		case <-tick:
+			b.sync()
+		}
+	}
+
+	b.users.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for topic, l := range b.logs {
+		if err := l.Close(); err != nil {
+			log.Printf("close of topic %s failed, err: %v\n", topic, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package file
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/bustest"
+	"github.com/stretchr/testify/suite"
+)
+
+type BusUnitSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (s *BusUnitSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+}
+
+func (s *BusUnitSuite) receive(msgs <-chan base.Message) base.Message {
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(time.Second):
+		s.FailNow("no message received")
+		return nil
+	}
+}
+
+func (s *BusUnitSuite) TestNewBus() {
+	assert := s.Assert()
+
+	bus, err := NewBus(SetBusDir(s.dir))
+	assert.NoError(err)
+	assert.NotNil(bus)
+	bus.Close()
+}
+
+func (s *BusUnitSuite) TestMustBus() {
+	assert := s.Assert()
+
+	assert.NotPanics(func() {
+		bus := MustBus(SetBusDir(s.dir))
+		assert.NotNil(bus)
+		bus.Close()
+	})
+}
+
+func (s *BusUnitSuite) TestPublishAndConsume() {
+	assert := s.Assert()
+
+	bus := MustBus(SetBusDir(s.dir))
+	defer bus.Close()
+
+	pub := bus.MustPublisher()
+	sub := bus.MustSubscriber()
+
+	err, ok := pub.Publish(&Message{
+		Headers: map[string]interface{}{"key": "value"},
+		Body:    []byte("body"),
+	})
+	assert.NoError(err)
+	assert.True(ok)
+
+	msgs, _, err := sub.Consume()
+	assert.NoError(err)
+
+	msg := s.receive(msgs)
+	assert.Equal("body", string(msg.GetBody()))
+	assert.Equal("value", msg.GetHeaders()["key"])
+	assert.NoError(msg.Ack(false))
+}
+
+func (s *BusUnitSuite) TestDeliveryInfo() {
+	assert := s.Assert()
+
+	bus := MustBus(SetBusDir(s.dir))
+	defer bus.Close()
+
+	pub := bus.MustPublisher(SetPublisherTopic("orders"))
+	sub := bus.MustSubscriber(SetSubscriberTopic("orders"))
+
+	before := time.Now()
+	err, ok := pub.Publish(&Message{MessageId: "id"})
+	assert.NoError(err)
+	assert.True(ok)
+
+	msgs, _, err := sub.Consume()
+	assert.NoError(err)
+
+	info := s.receive(msgs).DeliveryInfo()
+	assert.Equal("id", info.MessageId)
+	assert.Equal("orders", info.RoutingKey)
+	assert.False(info.Timestamp.Before(before))
+	assert.False(info.Redelivered)
+}
+
+func (s *BusUnitSuite) TestResumeFromCommittedOffset() {
+	assert := s.Assert()
+
+	bus := MustBus(SetBusDir(s.dir))
+	pub := bus.MustPublisher(SetPublisherTopic("events"))
+	for _, body := range []string{"a", "b", "c"} {
+		err, ok := pub.Publish(&Message{Body: []byte(body)})
+		assert.NoError(err)
+		assert.True(ok)
+	}
+
+	sub := bus.MustSubscriber(SetSubscriberTopic("events"))
+	msgs, _, _ := sub.Consume()
+	msg := s.receive(msgs)
+	assert.Equal("a", string(msg.GetBody()))
+	assert.NoError(msg.Ack(false))
+
+	msg = s.receive(msgs)
+	assert.Equal("b", string(msg.GetBody()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go sub.Close()
+	assert.NoError(bus.Shutdown(ctx))
+
+	bus = MustBus(SetBusDir(s.dir))
+	defer bus.Close()
+
+	sub = bus.MustSubscriber(SetSubscriberTopic("events"))
+	msgs, _, _ = sub.Consume()
+	msg = s.receive(msgs)
+	assert.Equal("b", string(msg.GetBody()))
+	assert.NoError(msg.Ack(false))
+	msg = s.receive(msgs)
+	assert.Equal("c", string(msg.GetBody()))
+	assert.NoError(msg.Ack(false))
+}
+
+func (s *BusUnitSuite) TestGroupsConsumeIndependently() {
+	assert := s.Assert()
+
+	bus := MustBus(SetBusDir(s.dir))
+	defer bus.Close()
+
+	pub := bus.MustPublisher()
+	err, _ := pub.Publish(&Message{Body: []byte("body")})
+	assert.NoError(err)
+
+	for _, group := range []string{"a", "b"} {
+		sub := bus.MustSubscriber(SetSubscriberGroup(group))
+		msgs, _, _ := sub.Consume()
+		msg := s.receive(msgs)
+		assert.Equal("body", string(msg.GetBody()))
+		assert.NoError(msg.Ack(false))
+	}
+
+	_, err = bus.NewSubscriber(SetSubscriberGroup("a"))
+	assert.Error(err)
+}
+
+func (s *BusUnitSuite) TestPublishOnClosed() {
+	assert := s.Assert()
+
+	bus := MustBus(SetBusDir(s.dir))
+	pub := bus.MustPublisher()
+	bus.Close()
+
+	err, ok := pub.Publish(&Message{})
+	assert.Error(err)
+	assert.False(ok)
+}
+
+func (s *BusUnitSuite) TestCloseTwice() {
+	bus := MustBus(SetBusDir(s.dir))
+	bus.Close()
+	s.NotPanics(bus.Close)
+}
+
+func (s *BusUnitSuite) TestShutdownWhenGraced() {
+	assert := s.Assert()
+
+	bus := MustBus(SetBusDir(s.dir), SetBusSyncPolicy(SyncInterval))
+	sub := bus.MustSubscriber()
+	_, closer, err := sub.Consume()
+	assert.NoError(err)
+
+	go func() {
+		<-closer
+		sub.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(bus.Shutdown(ctx))
+}
+
+func TestBusUnitSuite(t *testing.T) {
+	suite.Run(t, new(BusUnitSuite))
+}
+
+type conformanceBus struct {
+	Bus
+}
+
+func (b *conformanceBus) NewPublisher() (base.Publisher, error) {
+	return b.Bus.NewPublisher()
+}
+
+func (b *conformanceBus) NewSubscriber() (base.Subscriber, error) {
+	return b.Bus.NewSubscriber()
+}
+
+func TestBusConformanceUnitSuite(t *testing.T) {
+	suite.Run(t, &bustest.Suite{
+		NewBus: func(t *testing.T) bustest.Bus {
+			return &conformanceBus{MustBus(SetBusDir(t.TempDir()))}
+		},
+	})
+}
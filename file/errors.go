@@ -0,0 +1,8 @@
+package file
+
+import "errors"
+
+var (
+	ErrClosed     = errors.New("file bus is closed")
+	ErrOutOfRange = errors.New("offset is out of the log range")
+)
@@ -0,0 +1,151 @@
+package file
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// headers are the headers of a record, each value is stored tagged with its
+// type since plain JSON would read every number back as a float64 and bytes
+// as a string.
+type headers map[string]interface{}
+
+// values are the items of a header list, tagged as headers.
+type values []interface{}
+
+type tagged struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+var headerTypes = map[string]reflect.Type{
+	"string":  reflect.TypeOf(""),
+	"bool":    reflect.TypeOf(false),
+	"int":     reflect.TypeOf(int(0)),
+	"int8":    reflect.TypeOf(int8(0)),
+	"int16":   reflect.TypeOf(int16(0)),
+	"int32":   reflect.TypeOf(int32(0)),
+	"int64":   reflect.TypeOf(int64(0)),
+	"uint":    reflect.TypeOf(uint(0)),
+	"uint8":   reflect.TypeOf(uint8(0)),
+	"uint16":  reflect.TypeOf(uint16(0)),
+	"uint32":  reflect.TypeOf(uint32(0)),
+	"uint64":  reflect.TypeOf(uint64(0)),
+	"float32": reflect.TypeOf(float32(0)),
+	"float64": reflect.TypeOf(float64(0)),
+	"bytes":   reflect.TypeOf([]byte(nil)),
+	"time":    reflect.TypeOf(time.Time{}),
+	"table":   reflect.TypeOf(headers(nil)),
+	"array":   reflect.TypeOf(values(nil)),
+}
+
+var headerTypeNames = func() map[reflect.Type]string {
+	names := make(map[reflect.Type]string, len(headerTypes))
+	for name, t := range headerTypes {
+		names[t] = name
+	}
+	return names
+}()
+
+func (h headers) MarshalJSON() ([]byte, error) {
+	tags := make(map[string]tagged, len(h))
+	for k, v := range h {
+		t, err := tag(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not encode header %s", k)
+		}
+		tags[k] = t
+	}
+	return json.Marshal(tags)
+}
+
+func (h *headers) UnmarshalJSON(b []byte) error {
+	tags := make(map[string]tagged)
+	if err := json.Unmarshal(b, &tags); err != nil {
+		return err
+	}
+	*h = make(headers, len(tags))
+	for k, t := range tags {
+		v, err := t.untag()
+		if err != nil {
+			return errors.Wrapf(err, "Could not decode header %s", k)
+		}
+		(*h)[k] = v
+	}
+	return nil
+}
+
+func (l values) MarshalJSON() ([]byte, error) {
+	tags := make([]tagged, len(l))
+	for i, v := range l {
+		t, err := tag(v)
+		if err != nil {
+			return nil, err
+		}
+		tags[i] = t
+	}
+	return json.Marshal(tags)
+}
+
+func (l *values) UnmarshalJSON(b []byte) error {
+	tags := make([]tagged, 0)
+	if err := json.Unmarshal(b, &tags); err != nil {
+		return err
+	}
+	*l = make(values, len(tags))
+	for i, t := range tags {
+		v, err := t.untag()
+		if err != nil {
+			return err
+		}
+		(*l)[i] = v
+	}
+	return nil
+}
+
+func tag(v interface{}) (tagged, error) {
+	switch x := v.(type) {
+	case nil:
+		return tagged{Type: "nil", Value: json.RawMessage("null")}, nil
+	case map[string]interface{}:
+		v = headers(x)
+	case []interface{}:
+		v = values(x)
+	}
+
+	name, ok := headerTypeNames[reflect.TypeOf(v)]
+	if !ok {
+		return tagged{}, errors.Errorf("unsupported type %T", v)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return tagged{}, err
+	}
+	return tagged{Type: name, Value: raw}, nil
+}
+
+func (t tagged) untag() (interface{}, error) {
+	if t.Type == "nil" {
+		return nil, nil
+	}
+	typ, ok := headerTypes[t.Type]
+	if !ok {
+		return nil, errors.Errorf("unknown type %s", t.Type)
+	}
+	ptr := reflect.New(typ)
+	if err := json.Unmarshal(t.Value, ptr.Interface()); err != nil {
+		return nil, err
+	}
+
+	switch v := ptr.Elem().Interface().(type) {
+	case headers:
+		return map[string]interface{}(v), nil
+	case values:
+		return []interface{}(v), nil
+	default:
+		return v, nil
+	}
+}
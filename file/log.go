@@ -0,0 +1,338 @@
+package file
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	segmentExt   = ".log"
+	recordHeader = 8
+)
+
+type record struct {
+	MessageId string    `json:"id,omitempty"`
+	Topic     string    `json:"topic,omitempty"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	Headers   headers   `json:"headers,omitempty"`
+	Body      []byte    `json:"body,omitempty"`
+}
+
+type segment struct {
+	base      uint64
+	path      string
+	file      *os.File
+	size      int64
+	positions []int64
+}
+
+// Log is an append-only sequence of records split into segment files. Each
+// record is addressed by its offset, starting at zero for the first record
+// ever appended to the log.
+type Log struct {
+	mu sync.RWMutex
+
+	dir         string
+	segmentSize int64
+	policy      SyncPolicy
+
+	segments []*segment
+	dirty    bool
+	closed   bool
+
+	appended chan struct{}
+}
+
+func openLog(dir string, segmentSize int64, policy SyncPolicy) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "Could not create log directory")
+	}
+
+	l := &Log{
+		dir:         dir,
+		segmentSize: segmentSize,
+		policy:      policy,
+		appended:    make(chan struct{}),
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not read log directory")
+	}
+
+	bases := make([]uint64, 0)
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+
+	for _, base := range bases {
+		seg, err := openSegment(dir, base)
+		if err != nil {
+			l.closeSegments()
+			return nil, err
+		}
+		l.segments = append(l.segments, seg)
+	}
+
+	if len(l.segments) == 0 {
+		seg, err := openSegment(dir, 0)
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, seg)
+	}
+
+	return l, nil
+}
+
+func openSegment(dir string, base uint64) (*segment, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%020d%s", base, segmentExt))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not open segment")
+	}
+
+	seg := &segment{
+		base:      base,
+		path:      path,
+		file:      f,
+		positions: make([]int64, 0),
+	}
+
+	if err := seg.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return seg, nil
+}
+
+// recover indexes every complete record of the segment and truncates a torn
+// or corrupted tail left behind by a crash in the middle of an append.
+func (s *segment) recover() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "Could not stat segment")
+	}
+
+	var pos int64
+	header := make([]byte, recordHeader)
+	for pos+recordHeader <= info.Size() {
+		if _, err := s.file.ReadAt(header, pos); err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		sum := binary.BigEndian.Uint32(header[4:8])
+		if pos+recordHeader+length > info.Size() {
+			break
+		}
+
+		payload := make([]byte, length)
+		if _, err := s.file.ReadAt(payload, pos+recordHeader); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			break
+		}
+
+		s.positions = append(s.positions, pos)
+		pos += recordHeader + length
+	}
+
+	if pos != info.Size() {
+		if err := s.file.Truncate(pos); err != nil {
+			return errors.Wrap(err, "Could not truncate torn segment")
+		}
+	}
+	s.size = pos
+
+	return nil
+}
+
+func (s *segment) read(index int) (*record, error) {
+	pos := s.positions[index]
+	header := make([]byte, recordHeader)
+	if _, err := s.file.ReadAt(header, pos); err != nil {
+		return nil, errors.Wrap(err, "Could not read record header")
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := s.file.ReadAt(payload, pos+recordHeader); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "Could not read record payload")
+	}
+
+	rec := &record{}
+	if err := json.Unmarshal(payload, rec); err != nil {
+		return nil, errors.Wrap(err, "Could not decode record")
+	}
+	return rec, nil
+}
+
+// Append writes the record at the end of the log and returns its offset.
+func (l *Log) Append(rec *record) (uint64, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return 0, errors.Wrap(err, "Could not encode record")
+	}
+
+	buf := make([]byte, recordHeader+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[recordHeader:], payload)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, ErrClosed
+	}
+
+	seg := l.segments[len(l.segments)-1]
+	if seg.size > 0 && seg.size+int64(len(buf)) > l.segmentSize {
+		if seg, err = l.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := seg.file.WriteAt(buf, seg.size); err != nil {
+		return 0, errors.Wrap(err, "Could not append record")
+	}
+
+	if l.policy == SyncAlways {
+		if err := seg.file.Sync(); err != nil {
+			return 0, errors.Wrap(err, "Could not sync segment")
+		}
+	} else {
+		l.dirty = true
+	}
+
+	offset := seg.base + uint64(len(seg.positions))
+	seg.positions = append(seg.positions, seg.size)
+	seg.size += int64(len(buf))
+
+	close(l.appended)
+	l.appended = make(chan struct{})
+
+	return offset, nil
+}
+
+func (l *Log) roll() (*segment, error) {
+	last := l.segments[len(l.segments)-1]
+	if err := last.file.Sync(); err != nil {
+		return nil, errors.Wrap(err, "Could not sync segment")
+	}
+
+	seg, err := openSegment(l.dir, last.base+uint64(len(last.positions)))
+	if err != nil {
+		return nil, err
+	}
+	l.segments = append(l.segments, seg)
+	return seg, nil
+}
+
+// Read returns the record stored at the given offset.
+func (l *Log) Read(offset uint64) (*record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return nil, ErrClosed
+	}
+
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].base > offset
+	}) - 1
+	if i < 0 {
+		return nil, ErrOutOfRange
+	}
+
+	seg := l.segments[i]
+	index := int(offset - seg.base)
+	if index >= len(seg.positions) {
+		return nil, ErrOutOfRange
+	}
+
+	return seg.read(index)
+}
+
+// End returns the offset the next appended record will get.
+func (l *Log) End() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	seg := l.segments[len(l.segments)-1]
+	return seg.base + uint64(len(seg.positions))
+}
+
+// Appended returns a channel closed on the next append to the log.
+func (l *Log) Appended() <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.appended
+}
+
+// Sync flushes written records to stable storage.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed || !l.dirty {
+		return nil
+	}
+
+	l.dirty = false
+	return l.segments[len(l.segments)-1].file.Sync()
+}
+
+// Close syncs and closes every segment of the log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	l.closed = true
+
+	var err error
+	if l.dirty && l.policy != SyncNever {
+		err = l.segments[len(l.segments)-1].file.Sync()
+	}
+	if cerr := l.closeSegments(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (l *Log) closeSegments() error {
+	var err error
+	for _, seg := range l.segments {
+		if cerr := seg.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LogUnitSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (s *LogUnitSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+}
+
+func (s *LogUnitSuite) TestAppendAndRead() {
+	assert := s.Assert()
+
+	l, err := openLog(s.dir, 1024, SyncAlways)
+	assert.NoError(err)
+	defer l.Close()
+
+	offset, err := l.Append(&record{Body: []byte("a")})
+	assert.NoError(err)
+	assert.Equal(uint64(0), offset)
+
+	offset, err = l.Append(&record{
+		Headers: map[string]interface{}{"key": "value"},
+		Body:    []byte("b"),
+	})
+	assert.NoError(err)
+	assert.Equal(uint64(1), offset)
+	assert.Equal(uint64(2), l.End())
+
+	rec, err := l.Read(1)
+	assert.NoError(err)
+	assert.Equal("b", string(rec.Body))
+	assert.Equal("value", rec.Headers["key"])
+
+	_, err = l.Read(2)
+	assert.Equal(ErrOutOfRange, err)
+}
+
+func (s *LogUnitSuite) TestHeadersKeepTheirTypes() {
+	assert := s.Assert()
+
+	l, err := openLog(s.dir, 1024, SyncAlways)
+	assert.NoError(err)
+	defer l.Close()
+
+	at := time.Unix(1600000000, 0).UTC()
+	h := map[string]interface{}{
+		"int":    1,
+		"int64":  int64(2),
+		"uint8":  uint8(3),
+		"float":  1.5,
+		"bool":   true,
+		"bytes":  []byte("bytes"),
+		"time":   at,
+		"nil":    nil,
+		"table":  map[string]interface{}{"int32": int32(4)},
+		"array":  []interface{}{"a", 5},
+		"string": "value",
+	}
+	_, err = l.Append(&record{Headers: h})
+	assert.NoError(err)
+
+	rec, err := l.Read(0)
+	assert.NoError(err)
+	assert.Equal(h, map[string]interface{}(rec.Headers))
+
+	_, err = l.Append(&record{Headers: map[string]interface{}{"key": struct{}{}}})
+	assert.Error(err)
+}
+
+func (s *LogUnitSuite) TestRollSegments() {
+	assert := s.Assert()
+
+	l, err := openLog(s.dir, 32, SyncNever)
+	assert.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append(&record{Body: []byte("some body")})
+		assert.NoError(err)
+	}
+	assert.NoError(l.Close())
+
+	matches, _ := filepath.Glob(filepath.Join(s.dir, "*"+segmentExt))
+	assert.Len(matches, 5)
+
+	l, err = openLog(s.dir, 32, SyncNever)
+	assert.NoError(err)
+	defer l.Close()
+
+	assert.Equal(uint64(5), l.End())
+	rec, err := l.Read(3)
+	assert.NoError(err)
+	assert.Equal("some body", string(rec.Body))
+}
+
+func (s *LogUnitSuite) TestRecoverTornTail() {
+	assert := s.Assert()
+
+	l, err := openLog(s.dir, 1024, SyncAlways)
+	assert.NoError(err)
+	_, err = l.Append(&record{Body: []byte("a")})
+	assert.NoError(err)
+	_, err = l.Append(&record{Body: []byte("b")})
+	assert.NoError(err)
+	assert.NoError(l.Close())
+
+	path := filepath.Join(s.dir, "00000000000000000000"+segmentExt)
+	info, err := os.Stat(path)
+	assert.NoError(err)
+	assert.NoError(os.Truncate(path, info.Size()-3))
+
+	l, err = openLog(s.dir, 1024, SyncAlways)
+	assert.NoError(err)
+	defer l.Close()
+
+	assert.Equal(uint64(1), l.End())
+	offset, err := l.Append(&record{Body: []byte("c")})
+	assert.NoError(err)
+	assert.Equal(uint64(1), offset)
+
+	rec, err := l.Read(1)
+	assert.NoError(err)
+	assert.Equal("c", string(rec.Body))
+}
+
+func (s *LogUnitSuite) TestAppendOnClosed() {
+	assert := s.Assert()
+
+	l, err := openLog(s.dir, 1024, SyncAlways)
+	assert.NoError(err)
+	assert.NoError(l.Close())
+
+	_, err = l.Append(&record{})
+	assert.Equal(ErrClosed, err)
+}
+
+func TestLogUnitSuite(t *testing.T) {
+	suite.Run(t, new(LogUnitSuite))
+}
@@ -0,0 +1,74 @@
+package file
+
+import (
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+)
+
+type Message struct {
+	MessageId string
+	Topic     string
+	Timestamp time.Time
+
+	Headers map[string]interface{}
+	Body    []byte
+
+	Offset      uint64
+	Redelivered bool
+
+	sub *sub
+}
+
+func (m *Message) Ack(multiple bool) error {
+	if m.sub == nil {
+		return errors.New("Unable to ack message, it was not consumed")
+	}
+
+	return m.sub.settle(m.Offset, multiple, false)
+}
+
+func (m *Message) Nack(multiple bool, requeue bool) error {
+	if m.sub == nil {
+		return errors.New("Unable to nack message, it was not consumed")
+	}
+
+	return m.sub.settle(m.Offset, multiple, requeue)
+}
+
+func (m *Message) Reject(requeue bool) error {
+	if m.sub == nil {
+		return errors.New("Unable to reject message, it was not consumed")
+	}
+
+	return m.sub.settle(m.Offset, false, requeue)
+}
+
+// DeliveryInfo returns the id the message was published with, the topic as its
+// routing key, the time it was appended to the log and whether it was delivered
+// before. The log has no exchanges nor consumer tags, they are left empty.
+func (m *Message) DeliveryInfo() base.DeliveryInfo {
+	return base.DeliveryInfo{
+		MessageId:   m.MessageId,
+		RoutingKey:  m.Topic,
+		Timestamp:   m.Timestamp,
+		Redelivered: m.Redelivered,
+	}
+}
+
+func (m *Message) GetHeaders() map[string]interface{} {
+	return m.Headers
+}
+
+func (m *Message) SetHeaders(h map[string]interface{}) {
+	m.Headers = h
+}
+
+func (m *Message) GetBody() []byte {
+	return m.Body
+}
+
+func (m *Message) SetBody(b []byte) {
+	m.Body = b
+}
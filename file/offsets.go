@@ -0,0 +1,75 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const offsetsDir = "offsets"
+
+// offsetStore persists the committed offset of a consumer group, that is the
+// offset of the first record the group has not settled yet.
+type offsetStore struct {
+	path string
+	sync bool
+}
+
+func newOffsetStore(dir, group string, sync bool) (*offsetStore, error) {
+	dir = filepath.Join(dir, offsetsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "Could not create offsets directory")
+	}
+	return &offsetStore{
+		path: filepath.Join(dir, group),
+		sync: sync,
+	}, nil
+}
+
+func (o *offsetStore) load() (uint64, error) {
+	b, err := ioutil.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "Could not read committed offset")
+	}
+
+	offset, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "Could not parse committed offset")
+	}
+	return offset, nil
+}
+
+// store replaces the committed offset atomically, a crash leaves either the
+// previous or the new offset on disk.
+func (o *offsetStore) store(offset uint64) error {
+	tmp := o.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "Could not create offset file")
+	}
+
+	if _, err := f.WriteString(strconv.FormatUint(offset, 10)); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Could not write offset file")
+	}
+
+	if o.sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return errors.Wrap(err, "Could not sync offset file")
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "Could not close offset file")
+	}
+
+	return os.Rename(tmp, o.path)
+}
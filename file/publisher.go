@@ -0,0 +1,51 @@
+package file
+
+import (
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+)
+
+type PublisherOptionsFn func(*PublisherOptions)
+
+type PublisherOptions struct {
+	topic string
+}
+
+// SetPublisherTopic specifies the topic log messages are appended to.
+func SetPublisherTopic(topic string) PublisherOptionsFn {
+	return func(o *PublisherOptions) {
+		o.topic = topic
+	}
+}
+
+type pub struct {
+	*PublisherOptions
+
+	log   *Log
+	close <-chan struct{}
+}
+
+// Publish appends the message to the topic log, the publish is confirmed once
+// the record is written according to the bus sync policy.
+func (p *pub) Publish(msg base.Message) (error, bool) {
+	select {
+	case <-p.close:
+		return errors.New("Could not Publish, bus is closed"), false
+	default:
+	}
+
+	_, err := p.log.Append(&record{
+		MessageId: msg.DeliveryInfo().MessageId,
+		Topic:     p.topic,
+		Timestamp: time.Now(),
+		Headers:   msg.GetHeaders(),
+		Body:      msg.GetBody(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not Publish, log.Append() failed"), false
+	}
+
+	return nil, true
+}
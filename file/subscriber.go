@@ -0,0 +1,287 @@
+package file
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+)
+
+type SubscriberOptionsFn func(*SubscriberOptions)
+
+type SubscriberOptions struct {
+	deliveries chan base.Message
+	errs       chan<- error
+
+	topic string
+	group string
+
+	prefetch int
+}
+
+// SetSubscriberTopic specifies the topic log messages are consumed from.
+func SetSubscriberTopic(topic string) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.topic = topic
+	}
+}
+
+// SetSubscriberGroup specifies the consumer group name the committed offset is
+// stored under. Subscribers of different groups consume every message of the
+// topic independently.
+func SetSubscriberGroup(group string) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.group = group
+	}
+}
+
+// SetSubscriberPrefetch specifies how many messages may be delivered without
+// being acked.
+func SetSubscriberPrefetch(prefetch int) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.prefetch = prefetch
+	}
+}
+
+// SetSubscriberErrors sets a channel receiving the errors of the records the
+// subscriber could not read and skipped, they are dropped when it is full.
+func SetSubscriberErrors(errs chan<- error) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.errs = errs
+	}
+}
+
+func SetSubscriberDeliveries(deliveries chan base.Message) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.deliveries = deliveries
+	}
+}
+
+type sub struct {
+	*SubscriberOptions
+
+	log     *Log
+	offsets *offsetStore
+	bus     *bus
+
+	mu        sync.Mutex
+	committed uint64
+	next      uint64
+	unacked   map[uint64]struct{}
+	requeued  []uint64
+	settled   chan struct{}
+	consuming bool
+
+	close   <-chan struct{}
+	quit    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func (s *sub) Consume() (<-chan base.Message, <-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.consuming {
+		return s.deliveries, s.close, nil
+	}
+
+	select {
+	case <-s.quit:
+		return s.deliveries, s.close, ErrClosed
+	default:
+	}
+
+	if !s.bus.enter() {
+		return s.deliveries, s.close, ErrClosed
+	}
+	s.consuming = true
+
+	go s.loop()
+
+	return s.deliveries, s.close, nil
+}
+
+func (s *sub) Close() {
+	s.once.Do(func() {
+		close(s.quit)
+
+		s.mu.Lock()
+		consuming := s.consuming
+		s.mu.Unlock()
+		if consuming {
+			<-s.stopped
+		}
+
+		close(s.deliveries)
+		s.bus.leave(s.topic, s.group)
+	})
+}
+
+func (s *sub) loop() {
+	defer s.bus.users.Done()
+	defer close(s.stopped)
+
+	for {
+		appended := s.log.Appended()
+		offset, redelivered, ok := s.reserve()
+		if !ok {
+			select {
+			case <-s.close:
+				return
+			case <-s.quit:
+				return
+			case <-s.settled:
+			case <-appended:
+			}
+			continue
+		}
+
+		rec, err := s.log.Read(offset)
+		if err == ErrClosed {
+			s.release(offset)
+			return
+		}
+		if err != nil {
+			s.skip(offset, err)
+			continue
+		}
+
+		msg := &Message{
+			MessageId:   rec.MessageId,
+			Topic:       rec.Topic,
+			Timestamp:   rec.Timestamp,
+			Headers:     rec.Headers,
+			Body:        rec.Body,
+			Offset:      offset,
+			Redelivered: redelivered,
+			sub:         s,
+		}
+
+		select {
+		case s.deliveries <- msg:
+		case <-s.close:
+			s.release(offset)
+			return
+		case <-s.quit:
+			s.release(offset)
+			return
+		}
+	}
+}
+
+// skip settles a record that could not be read so the group moves past it
+// instead of retrying it forever, and reports the error.
+func (s *sub) skip(offset uint64, err error) {
+	err = errors.Wrapf(err, "Could not read offset %d of topic %s, record skipped", offset, s.topic)
+	log.Printf("read failed, err: %v\n", err)
+	if s.errs != nil {
+		select {
+		case s.errs <- err:
+		default:
+		}
+	}
+
+	if err := s.settle(offset, false, false); err != nil {
+		log.Printf("settle of offset %d failed, err: %v\n", offset, err)
+	}
+}
+
+// reserve picks the next offset to deliver, requeued messages go first so
+// they are retried in their original order.
+func (s *sub) reserve() (uint64, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.unacked) >= s.prefetch {
+		return 0, false, false
+	}
+
+	if len(s.requeued) > 0 {
+		offset := s.requeued[0]
+		s.requeued = s.requeued[1:]
+		s.unacked[offset] = struct{}{}
+		return offset, true, true
+	}
+
+	if s.next < s.log.End() {
+		offset := s.next
+		s.next++
+		s.unacked[offset] = struct{}{}
+		return offset, false, true
+	}
+
+	return 0, false, false
+}
+
+// release puts back an offset that was reserved but never delivered.
+func (s *sub) release(offset uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.unacked, offset)
+	s.requeue(offset)
+}
+
+func (s *sub) requeue(offset uint64) {
+	s.requeued = append(s.requeued, offset)
+	sort.Slice(s.requeued, func(i, j int) bool { return s.requeued[i] < s.requeued[j] })
+}
+
+func (s *sub) settle(offset uint64, multiple, requeue bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.unacked[offset]; !ok {
+		return errors.Errorf("Unable to settle message, offset %d is not awaiting acknowledgement", offset)
+	}
+
+	targets := []uint64{offset}
+	if multiple {
+		for o := range s.unacked {
+			if o < offset {
+				targets = append(targets, o)
+			}
+		}
+	}
+
+	for _, o := range targets {
+		delete(s.unacked, o)
+		if requeue {
+			s.requeue(o)
+		}
+	}
+
+	select {
+	case s.settled <- struct{}{}:
+	default:
+	}
+
+	return s.commit()
+}
+
+// commit advances the committed offset up to the first message that is still
+// awaiting acknowledgement or redelivery.
+func (s *sub) commit() error {
+	low := s.next
+	for o := range s.unacked {
+		if o < low {
+			low = o
+		}
+	}
+	if len(s.requeued) > 0 && s.requeued[0] < low {
+		low = s.requeued[0]
+	}
+
+	if low <= s.committed {
+		return nil
+	}
+
+	if err := s.offsets.store(low); err != nil {
+		return err
+	}
+	s.committed = low
+	return nil
+}
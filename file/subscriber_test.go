@@ -0,0 +1,181 @@
+package file
+
+import (
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/stretchr/testify/suite"
+)
+
+type SubscriberUnitSuite struct {
+	suite.Suite
+
+	bus Bus
+	pub base.Publisher
+}
+
+func (s *SubscriberUnitSuite) SetupTest() {
+	s.bus = MustBus(SetBusDir(s.T().TempDir()))
+	s.pub = s.bus.MustPublisher()
+	for _, body := range []string{"a", "b", "c"} {
+		err, _ := s.pub.Publish(&Message{Body: []byte(body)})
+		s.Require().NoError(err)
+	}
+}
+
+func (s *SubscriberUnitSuite) TearDownTest() {
+	s.bus.Close()
+}
+
+func (s *SubscriberUnitSuite) receive(msgs <-chan base.Message) *Message {
+	select {
+	case msg := <-msgs:
+		return msg.(*Message)
+	case <-time.After(time.Second):
+		s.FailNow("no message received")
+		return nil
+	}
+}
+
+func (s *SubscriberUnitSuite) TestNackWithRequeue() {
+	assert := s.Assert()
+
+	sub := s.bus.MustSubscriber()
+	msgs, _, _ := sub.Consume()
+
+	msg := s.receive(msgs)
+	assert.Equal("a", string(msg.Body))
+	assert.False(msg.Redelivered)
+	assert.NoError(msg.Nack(false, true))
+
+	msg = s.receive(msgs)
+	assert.Equal("a", string(msg.Body))
+	assert.True(msg.Redelivered)
+	assert.NoError(msg.Ack(false))
+
+	msg = s.receive(msgs)
+	assert.Equal("b", string(msg.Body))
+}
+
+func (s *SubscriberUnitSuite) TestSkipCorruptRecord() {
+	assert := s.Assert()
+
+	// the first byte of the payload of the first record no longer decodes
+	seg := s.bus.(*bus).logs["default"].segments[0]
+	_, err := seg.file.WriteAt([]byte("!"), seg.positions[0]+recordHeader)
+	s.Require().NoError(err)
+
+	errs := make(chan error, 1)
+	sub := s.bus.MustSubscriber(SetSubscriberErrors(errs))
+	msgs, _, _ := sub.Consume()
+
+	msg := s.receive(msgs)
+	assert.Equal("b", string(msg.Body))
+	assert.Equal(uint64(1), msg.Offset)
+	assert.Error(<-errs)
+	assert.NoError(msg.Ack(false))
+}
+
+func (s *SubscriberUnitSuite) TestInvalidNames() {
+	assert := s.Assert()
+
+	for _, name := range []string{"", ".", "..", "../escape", "a/b", `a\b`} {
+		_, err := s.bus.NewSubscriber(SetSubscriberGroup(name))
+		assert.Error(err, name)
+		_, err = s.bus.NewSubscriber(SetSubscriberTopic(name))
+		assert.Error(err, name)
+		_, err = s.bus.NewPublisher(SetPublisherTopic(name))
+		assert.Error(err, name)
+	}
+}
+
+func (s *SubscriberUnitSuite) TestConsumeAfterClose() {
+	assert := s.Assert()
+
+	sub := s.bus.MustSubscriber()
+	sub.Close()
+
+	msgs, _, err := sub.Consume()
+	assert.Equal(ErrClosed, err)
+	_, ok := <-msgs
+	assert.False(ok)
+}
+
+func (s *SubscriberUnitSuite) TestRejectWithoutRequeue() {
+	assert := s.Assert()
+
+	sub := s.bus.MustSubscriber()
+	msgs, _, _ := sub.Consume()
+
+	msg := s.receive(msgs)
+	assert.NoError(msg.Reject(false))
+
+	msg = s.receive(msgs)
+	assert.Equal("b", string(msg.Body))
+	assert.False(msg.Redelivered)
+}
+
+func (s *SubscriberUnitSuite) TestPrefetch() {
+	assert := s.Assert()
+
+	sub := s.bus.MustSubscriber(
+		SetSubscriberPrefetch(2),
+		SetSubscriberDeliveries(make(chan base.Message, 3)),
+	)
+	msgs, _, _ := sub.Consume()
+
+	a := s.receive(msgs)
+	b := s.receive(msgs)
+	select {
+	case <-msgs:
+		s.Fail("prefetch exceeded")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	assert.NoError(b.Ack(true))
+	assert.Error(a.Ack(false))
+
+	c := s.receive(msgs)
+	assert.Equal("c", string(c.Body))
+}
+
+func (s *SubscriberUnitSuite) TestAckTwice() {
+	assert := s.Assert()
+
+	sub := s.bus.MustSubscriber()
+	msgs, _, _ := sub.Consume()
+
+	msg := s.receive(msgs)
+	assert.NoError(msg.Ack(false))
+	assert.Error(msg.Ack(false))
+}
+
+func (s *SubscriberUnitSuite) TestAckNotConsumed() {
+	assert := s.Assert()
+
+	msg := &Message{}
+	assert.Error(msg.Ack(false))
+	assert.Error(msg.Nack(false, false))
+	assert.Error(msg.Reject(false))
+}
+
+func (s *SubscriberUnitSuite) TestConsumeAfterPublish() {
+	assert := s.Assert()
+
+	sub := s.bus.MustSubscriber()
+	msgs, _, _ := sub.Consume()
+	for i := 0; i < 3; i++ {
+		assert.NoError(s.receive(msgs).Ack(false))
+	}
+
+	err, ok := s.pub.Publish(&Message{Body: []byte("d")})
+	assert.NoError(err)
+	assert.True(ok)
+
+	assert.Equal("d", string(s.receive(msgs).Body))
+}
+
+func TestSubscriberUnitSuite(t *testing.T) {
+	suite.Run(t, new(SubscriberUnitSuite))
+}
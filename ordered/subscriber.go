@@ -0,0 +1,245 @@
+// Package ordered processes the messages of a subscriber concurrently while
+// keeping the messages that share a key in order. Each key is hashed to one of
+// a fixed set of lanes, a lane runs its messages one at a time and the lanes
+// run in parallel.
+//
+// A lane only has work while the backend hands over more than one unacked
+// message, so the prefetch of the subscriber should be at least the number of
+// lanes. Nacking a message with requeue sends it back to the broker and the
+// messages behind it on its lane may be processed first.
+package ordered
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/pkg/errors"
+)
+
+// Handler processes a message. The lane acks the message when the handler
+// returns nil and nacks it otherwise, unless the handler settled it already.
+type Handler func(base.Message) error
+
+type SubscriberOptionsFn func(*SubscriberOptions)
+
+type SubscriberOptions struct {
+	lanes    int
+	laneSize int
+	key      func(base.Message) string
+	requeue  bool
+}
+
+// SetSubscriberLanes sets the number of lanes, that is how many messages are
+// processed at the same time.
+func SetSubscriberLanes(lanes int) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.lanes = lanes
+	}
+}
+
+// SetSubscriberLaneSize sets how many messages wait on each lane, a full lane
+// holds the messages of every other lane back.
+func SetSubscriberLaneSize(size int) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.laneSize = size
+	}
+}
+
+// SetSubscriberKey sets how the key of a message is found. Messages with an
+// empty key have no order to keep and are spread over the lanes.
+func SetSubscriberKey(key func(base.Message) string) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.key = key
+	}
+}
+
+// SetSubscriberHeader keys the messages on the value of a header.
+func SetSubscriberHeader(header string) SubscriberOptionsFn {
+	return SetSubscriberKey(func(msg base.Message) string {
+		v, ok := msg.GetHeaders()[header]
+		if !ok || v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	})
+}
+
+// SetSubscriberRoutingKey keys the messages on the routing key of their
+// delivery, for backends that route messages by key.
+func SetSubscriberRoutingKey() SubscriberOptionsFn {
+	return SetSubscriberKey(func(msg base.Message) string {
+		return msg.DeliveryInfo().RoutingKey
+	})
+}
+
+// SetSubscriberRequeue sets whether the messages a handler failed are
+// requeued, they are by default.
+func SetSubscriberRequeue(requeue bool) SubscriberOptionsFn {
+	return func(o *SubscriberOptions) {
+		o.requeue = requeue
+	}
+}
+
+type Subscriber interface {
+	// Handle consumes the messages and calls the handler on their lane until
+	// the bus closes. It returns once the running handlers returned, the
+	// messages still waiting on the lanes are left unacked.
+	Handle(Handler) error
+
+	Close()
+}
+
+type sub struct {
+	*SubscriberOptions
+
+	base.Subscriber
+}
+
+func MustSubscriber(s base.Subscriber, fns ...SubscriberOptionsFn) Subscriber {
+	sub, err := NewSubscriber(s, fns...)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// NewSubscriber wraps a subscriber of any backend. By default there are 8
+// lanes and the messages have no key, so they are only spread over the lanes.
+func NewSubscriber(s base.Subscriber, fns ...SubscriberOptionsFn) (Subscriber, error) {
+	o := &SubscriberOptions{}
+	SetSubscriberLanes(8)(o)
+	SetSubscriberLaneSize(1)(o)
+	SetSubscriberKey(func(base.Message) string { return "" })(o)
+	SetSubscriberRequeue(true)(o)
+	for _, fn := range fns {
+		fn(o)
+	}
+
+	if s == nil {
+		return nil, errors.New("Could not create subscriber, subscriber is required")
+	}
+	if o.lanes < 1 {
+		return nil, errors.New("Could not create subscriber, lanes must be at least 1")
+	}
+	if o.laneSize < 0 {
+		return nil, errors.New("Could not create subscriber, lane size must not be negative")
+	}
+
+	return &sub{
+		SubscriberOptions: o,
+		Subscriber:        s,
+	}, nil
+}
+
+func (s *sub) Handle(handler Handler) error {
+	msgs, done, err := s.Subscriber.Consume()
+	if err != nil {
+		return err
+	}
+
+	wg := &sync.WaitGroup{}
+	lanes := make([]chan *Message, s.lanes)
+	for i := range lanes {
+		lanes[i] = make(chan *Message, s.laneSize)
+		wg.Add(1)
+		go s.lane(lanes[i], done, handler, wg)
+	}
+	defer func() {
+		for _, lane := range lanes {
+			close(lane)
+		}
+		wg.Wait()
+	}()
+
+	next := 0
+	for {
+		select {
+		case <-done:
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+
+			i := next
+			if key := s.key(msg); key != "" {
+				i = laneOf(key, s.lanes)
+			} else {
+				next = (next + 1) % s.lanes
+			}
+
+			select {
+			case lanes[i] <- &Message{Message: msg}:
+			case <-done:
+				return nil
+			}
+		}
+	}
+}
+
+func (s *sub) lane(msgs <-chan *Message, done <-chan struct{}, handler Handler, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for msg := range msgs {
+		select {
+		case <-done:
+			continue
+		default:
+		}
+
+		err := handler(msg)
+		if err != nil {
+			err = msg.settle(func() error { return msg.Message.Nack(false, s.requeue) })
+		} else {
+			err = msg.settle(func() error { return msg.Message.Ack(false) })
+		}
+		if err != nil && err != errSettled {
+			log.Printf("lane settle failed, err: %v\n", err)
+		}
+	}
+}
+
+func laneOf(key string, lanes int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(lanes))
+}
+
+var errSettled = errors.New("Message already settled")
+
+// Message is a message handed to a handler. It may be settled by the handler
+// or by its lane, whichever comes first; settling it again fails. Acks and
+// nacks never cover the messages before it, those belong to other lanes, so
+// multiple is ignored.
+type Message struct {
+	base.Message
+
+	mu      sync.Mutex
+	settled bool
+}
+
+func (m *Message) settle(fn func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.settled {
+		return errSettled
+	}
+	m.settled = true
+	return fn()
+}
+
+func (m *Message) Ack(multiple bool) error {
+	return m.settle(func() error { return m.Message.Ack(false) })
+}
+
+func (m *Message) Nack(multiple bool, requeue bool) error {
+	return m.settle(func() error { return m.Message.Nack(false, requeue) })
+}
+
+func (m *Message) Reject(requeue bool) error {
+	return m.settle(func() error { return m.Message.Reject(requeue) })
+}
@@ -0,0 +1,181 @@
+package ordered
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/bustest"
+	"github.com/stretchr/testify/suite"
+)
+
+func newMessage(key string, body string) *bustest.RecordingMessage {
+	return bustest.NewRecordingMessage(map[string]interface{}{"key": key}, []byte(body))
+}
+
+type SubscriberUnitSuite struct {
+	suite.Suite
+}
+
+func (s *SubscriberUnitSuite) handle(sub Subscriber, handler Handler) chan error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sub.Handle(handler)
+	}()
+	return errs
+}
+
+func (s *SubscriberUnitSuite) TestKeepsOrderPerKey() {
+	assert := s.Assert()
+
+	inner := bustest.NewSubscriber(200)
+	sub := MustSubscriber(inner, SetSubscriberLanes(4), SetSubscriberHeader("key"))
+
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			inner.Msgs <- newMessage(key, fmt.Sprint(i))
+		}
+	}
+
+	mu := sync.Mutex{}
+	received := map[string][]string{}
+	wg := sync.WaitGroup{}
+	wg.Add(150)
+	errs := s.handle(sub, func(msg base.Message) error {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		key := msg.GetHeaders()["key"].(string)
+		received[key] = append(received[key], string(msg.GetBody()))
+		return nil
+	})
+
+	wg.Wait()
+	close(inner.Done)
+	assert.NoError(<-errs)
+
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Len(received[key], 50)
+		for i, body := range received[key] {
+			assert.Equal(fmt.Sprint(i), body)
+		}
+	}
+}
+
+func (s *SubscriberUnitSuite) TestRunsKeysInParallel() {
+	assert := s.Assert()
+
+	inner := bustest.NewSubscriber(200)
+	sub := MustSubscriber(inner, SetSubscriberLanes(2), SetSubscriberKey(func(msg base.Message) string {
+		return msg.GetHeaders()["key"].(string)
+	}))
+
+	// a and b fall on different lanes of two
+	assert.NotEqual(laneOf("a", 2), laneOf("b", 2))
+
+	inner.Msgs <- newMessage("a", "")
+	inner.Msgs <- newMessage("b", "")
+
+	b := make(chan struct{})
+	handled := make(chan string, 2)
+	errs := s.handle(sub, func(msg base.Message) error {
+		key := msg.GetHeaders()["key"].(string)
+		if key == "a" {
+			select {
+			case <-b:
+			case <-time.After(time.Second):
+				return errors.New("b was not processed in parallel")
+			}
+		} else {
+			close(b)
+		}
+		handled <- key
+		return nil
+	})
+
+	assert.Equal("b", <-handled)
+	assert.Equal("a", <-handled)
+	close(inner.Done)
+	assert.NoError(<-errs)
+}
+
+func (s *SubscriberUnitSuite) TestAckBookkeeping() {
+	assert := s.Assert()
+
+	inner := bustest.NewSubscriber(200)
+	sub := MustSubscriber(inner, SetSubscriberRequeue(false))
+
+	acked := newMessage("", "acked")
+	failed := newMessage("", "failed")
+	settled := newMessage("", "settled")
+	inner.Msgs <- acked
+	inner.Msgs <- failed
+	inner.Msgs <- settled
+
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	errs := s.handle(sub, func(msg base.Message) error {
+		defer wg.Done()
+		switch string(msg.GetBody()) {
+		case "failed":
+			return errors.New("failed")
+		case "settled":
+			assert.NoError(msg.Ack(true))
+			assert.Error(msg.Ack(false))
+		}
+		return nil
+	})
+
+	wg.Wait()
+	close(inner.Done)
+	assert.NoError(<-errs)
+
+	assert.Equal([]string{"ack false"}, acked.Calls())
+	assert.Equal([]string{"nack false false"}, failed.Calls())
+	assert.Equal([]string{"ack false"}, settled.Calls())
+}
+
+func (s *SubscriberUnitSuite) TestLeavesWaitingMessagesOnClose() {
+	assert := s.Assert()
+
+	inner := bustest.NewSubscriber(200)
+	sub := MustSubscriber(inner, SetSubscriberLanes(1), SetSubscriberLaneSize(5))
+
+	first := newMessage("", "first")
+	waiting := newMessage("", "waiting")
+	inner.Msgs <- first
+	inner.Msgs <- waiting
+
+	started := make(chan struct{})
+	errs := s.handle(sub, func(msg base.Message) error {
+		if string(msg.GetBody()) == "first" {
+			close(started)
+			time.Sleep(time.Millisecond * 100)
+		}
+		return nil
+	})
+
+	<-started
+	time.Sleep(time.Millisecond * 10)
+	close(inner.Done)
+	assert.NoError(<-errs)
+
+	assert.Equal([]string{"ack false"}, first.Calls())
+	assert.Empty(waiting.Calls())
+}
+
+func (s *SubscriberUnitSuite) TestInvalidOptions() {
+	assert := s.Assert()
+
+	_, err := NewSubscriber(bustest.NewSubscriber(200), SetSubscriberLanes(0))
+	assert.Error(err)
+	_, err = NewSubscriber(nil)
+	assert.Error(err)
+}
+
+func TestSubscriberUnitSuite(t *testing.T) {
+	suite.Run(t, new(SubscriberUnitSuite))
+}
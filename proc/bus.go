@@ -19,6 +19,9 @@ type bus struct {
 	in  chan<- base.Message
 	out <-chan base.Message
 
+	maxPriority uint8
+	window      int
+
 	closed bool
 	closer chan struct{}
 	wg     *sync.WaitGroup
@@ -29,6 +32,7 @@ func NewBus(fns ...OptionsFn) (Bus, error) {
 	var wg sync.WaitGroup
 	o.closer = make(chan struct{})
 	o.wg = &wg
+	SetPriorityWindow(10)(&o)
 
 	for _, fn := range fns {
 		fn(&o)
@@ -37,10 +41,12 @@ func NewBus(fns ...OptionsFn) (Bus, error) {
 	o.wg.Add(1)
 
 	return &bus{
-		in:     o.in,
-		out:    o.out,
-		closer: o.closer,
-		wg:     o.wg,
+		in:          o.in,
+		out:         o.out,
+		maxPriority: o.maxPriority,
+		window:      o.window,
+		closer:      o.closer,
+		wg:          o.wg,
 	}, nil
 }
 
@@ -49,12 +55,16 @@ func (b *bus) NewPublisher() (base.Publisher, error) {
 		SetIn(b.in),
 
 		SetCloser(b.closer),
+		SetWaitGroup(b.wg),
 	)
 }
 
 func (b *bus) NewSubscriber() (base.Subscriber, error) {
 	return NewSubscriber(
+		SetIn(b.in),
 		SetOut(b.out),
+		SetMaxPriority(b.maxPriority),
+		SetPriorityWindow(b.window),
 
 		SetCloser(b.closer),
 		SetWaitGroup(b.wg),
@@ -0,0 +1,22 @@
+package proc
+
+import (
+	"testing"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/bustest"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBusConformanceUnitSuite(t *testing.T) {
+	suite.Run(t, &bustest.Suite{
+		NewBus: func(t *testing.T) bustest.Bus {
+			broker := make(chan base.Message, 10)
+			bus, err := NewBus(SetIn(broker), SetOut(broker))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return bus
+		},
+	})
+}
@@ -1,8 +1,25 @@
 package proc
 
+import (
+	"time"
+
+	base "github.com/movidesk/go-bus"
+)
+
 type Message struct {
 	body    []byte
 	headers map[string]interface{}
+
+	priority   uint8
+	expiration time.Duration
+	expires    time.Time
+
+	id          string
+	published   time.Time
+	redelivered bool
+
+	in     chan<- base.Message
+	closer <-chan struct{}
 }
 
 func (m *Message) Ack(multiple bool) error {
@@ -10,13 +27,46 @@ func (m *Message) Ack(multiple bool) error {
 }
 
 func (m *Message) Nack(multiple bool, requeue bool) error {
+	if requeue {
+		m.requeue()
+	}
 	return nil
 }
 
 func (m *Message) Reject(requeue bool) error {
+	if requeue {
+		m.requeue()
+	}
 	return nil
 }
 
+// requeue sends the message back to the bus without blocking the caller, the
+// consumer nacking it may be the only one draining the bus.
+func (m *Message) requeue() {
+	if m.in == nil {
+		return
+	}
+
+	msg := &Message{
+		body:        m.body,
+		headers:     m.headers,
+		priority:    m.priority,
+		expiration:  m.expiration,
+		expires:     m.expires,
+		id:          m.id,
+		published:   m.published,
+		redelivered: true,
+		in:          m.in,
+		closer:      m.closer,
+	}
+	go func() {
+		select {
+		case m.in <- msg:
+		case <-m.closer:
+		}
+	}()
+}
+
 func (m *Message) SetHeaders(h map[string]interface{}) {
 	m.headers = h
 }
@@ -32,3 +82,22 @@ func (m *Message) SetBody(h []byte) {
 func (m *Message) GetBody() []byte {
 	return m.body
 }
+
+func (m *Message) GetPriority() uint8 {
+	return m.priority
+}
+
+func (m *Message) GetExpiration() time.Duration {
+	return m.expiration
+}
+
+// DeliveryInfo returns the id the message was published with, when it had one,
+// the time it was published and whether it was requeued before. The proc bus
+// has no exchanges, routing keys nor consumer tags, they are left empty.
+func (m *Message) DeliveryInfo() base.DeliveryInfo {
+	return base.DeliveryInfo{
+		MessageId:   m.id,
+		Timestamp:   m.published,
+		Redelivered: m.redelivered,
+	}
+}
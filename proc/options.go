@@ -12,6 +12,9 @@ type Options struct {
 	in  chan<- base.Message
 	out <-chan base.Message
 
+	maxPriority uint8
+	window      int
+
 	closer chan struct{}
 	wg     *sync.WaitGroup
 }
@@ -39,3 +42,22 @@ func SetWaitGroup(wg *sync.WaitGroup) OptionsFn {
 		o.wg = wg
 	}
 }
+
+// SetMaxPriority makes the subscribers deliver the waiting messages with a
+// higher priority first, as an amqp queue declared with a max priority does.
+// Priorities above max count as max. Without it messages are delivered in the
+// order they were published.
+func SetMaxPriority(max uint8) OptionsFn {
+	return func(o *Options) {
+		o.maxPriority = max
+	}
+}
+
+// SetPriorityWindow sets how many messages a subscriber with a max priority
+// takes off the bus to order them, 10 by default. The other subscribers of the
+// bus compete for the rest.
+func SetPriorityWindow(window int) OptionsFn {
+	return func(o *Options) {
+		o.window = window
+	}
+}
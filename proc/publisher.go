@@ -2,6 +2,9 @@ package proc
 
 import (
 	"errors"
+	"log"
+	"sync"
+	"time"
 
 	base "github.com/movidesk/go-bus"
 )
@@ -11,9 +14,16 @@ type pub struct {
 	in chan<- base.Message
 
 	closer <-chan struct{}
-	closed bool
+	wg     *sync.WaitGroup
+
+	mu    sync.Mutex
+	wheel *wheel
 }
 
+// NewPublisher creates a publisher that is also a base.DelayedPublisher,
+// delayed messages are held back by a timing wheel ticking every 10ms. The
+// wheel starts with the first delayed message and stops once the closer is
+// closed.
 func NewPublisher(fns ...OptionsFn) (base.Publisher, error) {
 	var o Options
 	for _, fn := range fns {
@@ -22,20 +32,76 @@ func NewPublisher(fns ...OptionsFn) (base.Publisher, error) {
 	return &pub{
 		in:     o.in,
 		closer: o.closer,
+		wg:     o.wg,
 	}, nil
 }
 
 func (p *pub) Publish(msg base.Message) (error, bool) {
-	if p.closed {
+	select {
+	case <-p.closer:
+		return errors.New("closed"), false
+	default:
+	}
+
+	m := &Message{
+		body:      msg.GetBody(),
+		headers:   msg.GetHeaders(),
+		id:        msg.DeliveryInfo().MessageId,
+		published: time.Now(),
+		in:        p.in,
+		closer:    p.closer,
+	}
+	if e, ok := msg.(base.Expirable); ok && e.GetExpiration() > 0 {
+		m.expiration = e.GetExpiration()
+		m.expires = time.Now().Add(m.expiration)
+	}
+	if pr, ok := msg.(base.Prioritizable); ok {
+		m.priority = pr.GetPriority()
+	}
+
+	select {
+	case p.in <- m:
+		return nil, true
+	case <-p.closer:
 		return errors.New("closed"), false
 	}
-	p.in <- msg
+}
+
+func (p *pub) PublishAfter(msg base.Message, d time.Duration) (error, bool) {
+	if d <= 0 {
+		return p.Publish(msg)
+	}
+
+	select {
+	case <-p.closer:
+		return errors.New("closed"), false
+	default:
+	}
+
+	// published from a goroutine of its own so a full bus does not hold the
+	// wheel back
+	p.delays().schedule(d, func() {
+		go func() {
+			if err, ok := p.Publish(msg); err != nil || !ok {
+				log.Printf("delayed publish failed, ok: %t, err: %v\n", ok, err)
+			}
+		}()
+	})
 	return nil, true
 }
 
-func (p *pub) Close() {
-	go func() {
-		<-p.closer
-		p.closed = true
-	}()
+// delays returns the wheel of the publisher, started on the first call so the
+// publishers that never delay run no goroutine.
+func (p *pub) delays() *wheel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.wheel == nil {
+		p.wheel = newWheel(10*time.Millisecond, 512, p.closer, p.wg)
+	}
+	return p.wheel
+}
+
+func (p *pub) PublishAt(msg base.Message, t time.Time) (error, bool) {
+	return p.PublishAfter(msg, time.Until(t))
 }
@@ -0,0 +1,167 @@
+package proc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/bustest"
+	"github.com/stretchr/testify/suite"
+)
+
+type PublisherUnitSuite struct {
+	suite.Suite
+}
+
+func (s *PublisherUnitSuite) receive(msgs <-chan base.Message) string {
+	select {
+	case msg := <-msgs:
+		return string(msg.GetBody())
+	case <-time.After(time.Second):
+		s.FailNow("no message received")
+		return ""
+	}
+}
+
+func (s *PublisherUnitSuite) TestPublishAfter() {
+	assert := s.Assert()
+
+	broker := make(chan base.Message, 10)
+	bus, _ := NewBus(SetIn(broker), SetOut(broker))
+	defer bus.Close()
+
+	pub, _ := bus.NewPublisher()
+	delayed, ok := pub.(base.DelayedPublisher)
+	s.Require().True(ok)
+
+	start := time.Now()
+	err, ok := delayed.PublishAfter(bustest.NewMessage(nil, []byte("second")), time.Millisecond*100)
+	assert.NoError(err)
+	assert.True(ok)
+	err, ok = delayed.PublishAfter(bustest.NewMessage(nil, []byte("first")), time.Millisecond*50)
+	assert.NoError(err)
+	assert.True(ok)
+	err, ok = delayed.PublishAt(bustest.NewMessage(nil, []byte("now")), time.Now().Add(-time.Second))
+	assert.NoError(err)
+	assert.True(ok)
+
+	assert.Equal("now", s.receive(broker))
+	assert.Equal("first", s.receive(broker))
+	assert.True(time.Since(start) >= time.Millisecond*50)
+	assert.Equal("second", s.receive(broker))
+	assert.True(time.Since(start) >= time.Millisecond*100)
+}
+
+func (s *PublisherUnitSuite) TestPublishAfterOnClosedBus() {
+	assert := s.Assert()
+
+	broker := make(chan base.Message, 10)
+	bus, _ := NewBus(SetIn(broker), SetOut(broker))
+	pub, _ := bus.NewPublisher()
+
+	err, ok := pub.(base.DelayedPublisher).PublishAfter(bustest.NewMessage(nil, nil), time.Millisecond*50)
+	assert.NoError(err)
+	assert.True(ok)
+
+	bus.Close()
+	bus.Wait()
+
+	err, ok = pub.(base.DelayedPublisher).PublishAfter(bustest.NewMessage(nil, nil), time.Millisecond*50)
+	assert.Error(err)
+	assert.False(ok)
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Len(broker, 0)
+}
+
+func (s *PublisherUnitSuite) TestWheelRounds() {
+	assert := s.Assert()
+
+	closer := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	w := newWheel(time.Millisecond*5, 4, closer, wg)
+
+	fired := make(chan time.Duration, 3)
+	start := time.Now()
+	for _, d := range []time.Duration{time.Millisecond * 70, time.Millisecond * 5, time.Millisecond * 30} {
+		d := d
+		w.schedule(d, func() { fired <- d })
+	}
+
+	for _, d := range []time.Duration{time.Millisecond * 5, time.Millisecond * 30, time.Millisecond * 70} {
+		assert.Equal(d, <-fired)
+		assert.True(time.Since(start) >= d)
+	}
+
+	close(closer)
+	wg.Wait()
+}
+
+func (s *PublisherUnitSuite) TestWheelCountedFromCreation() {
+	closer := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	newWheel(time.Millisecond*5, 4, closer, wg)
+
+	waited := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		s.Fail("wheel not counted before its first timer")
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	close(closer)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		s.Fail("wheel did not stop")
+	}
+}
+
+func (s *PublisherUnitSuite) TestWheelStartsOnFirstDelay() {
+	assert := s.Assert()
+
+	closer := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	broker := make(chan base.Message, 1)
+	publisher, _ := NewPublisher(SetIn(broker), SetCloser(closer), SetWaitGroup(wg))
+
+	err, ok := publisher.Publish(bustest.NewMessage(nil, nil))
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Nil(publisher.(*pub).wheel)
+	<-broker
+
+	err, ok = publisher.(base.DelayedPublisher).PublishAfter(bustest.NewMessage(nil, nil), time.Millisecond*10)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.NotNil(publisher.(*pub).wheel)
+
+	close(closer)
+	wg.Wait()
+}
+
+func (s *PublisherUnitSuite) TestWheelNeverFiresEarly() {
+	assert := s.Assert()
+
+	closer := make(chan struct{})
+	defer close(closer)
+	w := newWheel(time.Millisecond*10, 4, closer, nil)
+
+	// scheduled between two ticks, the next tick comes sooner than the delay
+	time.Sleep(time.Millisecond * 15)
+	start := time.Now()
+	fired := make(chan struct{})
+	w.schedule(time.Millisecond*10, func() { close(fired) })
+
+	<-fired
+	assert.True(time.Since(start) >= time.Millisecond*10)
+}
+
+func TestPublisherUnitSuite(t *testing.T) {
+	suite.Run(t, new(PublisherUnitSuite))
+}
@@ -1,21 +1,37 @@
 package proc
 
 import (
+	"container/heap"
 	"sync"
+	"time"
 
 	base "github.com/movidesk/go-bus"
 )
 
 type sub struct {
 	_   struct{}
+	in  chan<- base.Message
 	out <-chan base.Message
 
+	maxPriority uint8
+	window      int
+	deliveries  chan base.Message
+	once        sync.Once
+
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	paused bool
+	wake   chan struct{}
+
 	closer chan struct{}
 	wg     *sync.WaitGroup
 }
 
 func NewSubscriber(fns ...OptionsFn) (base.Subscriber, error) {
 	var o Options
+	SetPriorityWindow(10)(&o)
 	for _, fn := range fns {
 		fn(&o)
 	}
@@ -23,17 +39,184 @@ func NewSubscriber(fns ...OptionsFn) (base.Subscriber, error) {
 	o.wg.Add(1)
 
 	return &sub{
+		in:  o.in,
 		out: o.out,
 
+		maxPriority: o.maxPriority,
+		window:      o.window,
+		deliveries:  make(chan base.Message),
+		quit:        make(chan struct{}),
+		wake:        make(chan struct{}, 1),
+
 		closer: o.closer,
 		wg:     o.wg,
 	}, nil
 }
 
 func (s *sub) Consume() (<-chan base.Message, <-chan struct{}, error) {
-	return s.out, s.closer, nil
+	s.once.Do(func() {
+		s.wg.Add(1)
+		go s.loop()
+	})
+	return s.deliveries, s.closer, nil
+}
+
+// loop hands the messages of the bus over, dropping the expired ones. With a
+// max priority it takes up to the priority window of messages off the bus so
+// the one with the highest priority is handed first, otherwise it holds a
+// single message. While paused it neither takes nor hands messages over. Once
+// the subscriber closes the messages it holds go back to the bus.
+func (s *sub) loop() {
+	defer s.wg.Done()
+
+	out := s.out
+	waiting := &waiting{}
+	seq := uint64(0)
+	for {
+		now := time.Now()
+		for waiting.Len() > 0 && (*waiting)[0].expired(now) {
+			heap.Pop(waiting)
+		}
+		if out == nil && waiting.Len() == 0 {
+			close(s.deliveries)
+			return
+		}
+
+		paused := s.isPaused()
+		var in <-chan base.Message
+		if !paused && (waiting.Len() == 0 || s.maxPriority > 0 && waiting.Len() < s.window) {
+			in = out
+		}
+		var deliveries chan<- base.Message
+		var next base.Message
+		if !paused && waiting.Len() > 0 {
+			deliveries = s.deliveries
+			next = (*waiting)[0].Message
+		}
+
+		select {
+		case <-s.closer:
+			return
+		case <-s.quit:
+			s.requeue(waiting)
+			close(s.deliveries)
+			return
+		case <-s.wake:
+		case msg, ok := <-in:
+			if !ok {
+				out = nil
+				continue
+			}
+			w := &waitingMessage{Message: msg, seq: seq}
+			seq++
+			if m, ok := msg.(*Message); ok {
+				w.priority = m.priority
+				w.expires = m.expires
+			}
+			if w.priority > s.maxPriority {
+				w.priority = s.maxPriority
+			}
+			heap.Push(waiting, w)
+		case deliveries <- next:
+			heap.Pop(waiting)
+		}
+	}
 }
 
+// requeue sends the messages held back to the bus for the other subscribers,
+// without blocking the close.
+func (s *sub) requeue(waiting *waiting) {
+	if s.in == nil || waiting.Len() == 0 {
+		return
+	}
+
+	msgs := make([]base.Message, 0, waiting.Len())
+	for waiting.Len() > 0 {
+		msgs = append(msgs, heap.Pop(waiting).(*waitingMessage).Message)
+	}
+	go func() {
+		for _, msg := range msgs {
+			select {
+			case s.in <- msg:
+			case <-s.closer:
+				return
+			}
+		}
+	}()
+}
+
+// Pause stops handing messages over, the messages already held are handed
+// over once resumed.
+func (s *sub) Pause() error {
+	s.setPaused(true)
+	return nil
+}
+
+func (s *sub) Resume() error {
+	s.setPaused(false)
+	return nil
+}
+
+func (s *sub) setPaused(paused bool) {
+	s.mu.Lock()
+	s.paused = paused
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *sub) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// Close stops the subscriber, the messages it took off the bus but did not hand
+// over yet go back to the bus.
 func (s *sub) Close() {
-	s.wg.Done()
+	s.closeOnce.Do(func() {
+		close(s.quit)
+		s.wg.Done()
+	})
+}
+
+type waitingMessage struct {
+	base.Message
+
+	priority uint8
+	expires  time.Time
+	seq      uint64
+}
+
+func (w *waitingMessage) expired(now time.Time) bool {
+	return !w.expires.IsZero() && !now.Before(w.expires)
+}
+
+// waiting is a heap of the messages waiting to be handed over, ordered by
+// priority and then by arrival.
+type waiting []*waitingMessage
+
+func (w waiting) Len() int { return len(w) }
+
+func (w waiting) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+	return w[i].seq < w[j].seq
+}
+
+func (w waiting) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+
+func (w *waiting) Push(x interface{}) { *w = append(*w, x.(*waitingMessage)) }
+
+func (w *waiting) Pop() interface{} {
+	old := *w
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*w = old[:n-1]
+	return x
 }
@@ -0,0 +1,228 @@
+package proc
+
+import (
+	"testing"
+	"time"
+
+	base "github.com/movidesk/go-bus"
+	"github.com/movidesk/go-bus/bustest"
+	"github.com/stretchr/testify/suite"
+)
+
+type message struct {
+	base.Message
+	priority   uint8
+	expiration time.Duration
+}
+
+func (m *message) GetPriority() uint8 {
+	return m.priority
+}
+
+func (m *message) GetExpiration() time.Duration {
+	return m.expiration
+}
+
+// identified is a message published with an id.
+type identified struct {
+	base.Message
+	id string
+}
+
+func (m *identified) DeliveryInfo() base.DeliveryInfo {
+	return base.DeliveryInfo{MessageId: m.id}
+}
+
+type SubscriberUnitSuite struct {
+	suite.Suite
+}
+
+func (s *SubscriberUnitSuite) publish(pub base.Publisher, body string, priority uint8, expiration time.Duration) {
+	err, ok := pub.Publish(&message{
+		Message:    bustest.NewMessage(nil, []byte(body)),
+		priority:   priority,
+		expiration: expiration,
+	})
+	s.Require().NoError(err)
+	s.Require().True(ok)
+}
+
+func (s *SubscriberUnitSuite) receive(msgs <-chan base.Message) string {
+	select {
+	case msg := <-msgs:
+		return string(msg.GetBody())
+	case <-time.After(time.Second):
+		s.FailNow("no message received")
+		return ""
+	}
+}
+
+func (s *SubscriberUnitSuite) TestPriority() {
+	assert := s.Assert()
+
+	broker := make(chan base.Message, 10)
+	bus, _ := NewBus(SetIn(broker), SetOut(broker), SetMaxPriority(5))
+	defer bus.Close()
+
+	pub, _ := bus.NewPublisher()
+	s.publish(pub, "a", 0, 0)
+	s.publish(pub, "b", 5, 0)
+	s.publish(pub, "c", 9, 0)
+	s.publish(pub, "d", 3, 0)
+
+	sub, _ := bus.NewSubscriber()
+	defer sub.Close()
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+
+	// priorities above the max count as the max
+	time.Sleep(time.Millisecond * 50)
+	for _, expected := range []string{"b", "c", "d", "a"} {
+		assert.Equal(expected, s.receive(msgs))
+	}
+}
+
+func (s *SubscriberUnitSuite) TestPriorityWithoutMax() {
+	assert := s.Assert()
+
+	broker := make(chan base.Message, 10)
+	bus, _ := NewBus(SetIn(broker), SetOut(broker))
+	defer bus.Close()
+
+	pub, _ := bus.NewPublisher()
+	s.publish(pub, "a", 0, 0)
+	s.publish(pub, "b", 5, 0)
+
+	sub, _ := bus.NewSubscriber()
+	defer sub.Close()
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal("a", s.receive(msgs))
+	assert.Equal("b", s.receive(msgs))
+}
+
+func (s *SubscriberUnitSuite) TestExpiration() {
+	assert := s.Assert()
+
+	broker := make(chan base.Message, 10)
+	bus, _ := NewBus(SetIn(broker), SetOut(broker), SetMaxPriority(1))
+	defer bus.Close()
+
+	pub, _ := bus.NewPublisher()
+	s.publish(pub, "expired", 1, time.Millisecond*20)
+	s.publish(pub, "kept", 0, time.Minute)
+
+	time.Sleep(time.Millisecond * 50)
+
+	sub, _ := bus.NewSubscriber()
+	defer sub.Close()
+	msgs, _, err := sub.Consume()
+	s.Require().NoError(err)
+
+	msg := <-msgs
+	assert.Equal("kept", string(msg.GetBody()))
+	assert.Equal(time.Minute, msg.(base.Expirable).GetExpiration())
+}
+
+func (s *SubscriberUnitSuite) TestPriorityWindow() {
+	assert := s.Assert()
+
+	broker := make(chan base.Message, 10)
+	bus, _ := NewBus(SetIn(broker), SetOut(broker), SetMaxPriority(5), SetPriorityWindow(2))
+	defer bus.Close()
+
+	pub, _ := bus.NewPublisher()
+	for _, body := range []string{"a", "b", "c", "d", "e"} {
+		s.publish(pub, body, 0, 0)
+	}
+
+	// the first subscriber holds the window without handing anything over
+	first, _ := bus.NewSubscriber()
+	_, _, err := first.Consume()
+	s.Require().NoError(err)
+	time.Sleep(time.Millisecond * 50)
+	assert.Len(broker, 3)
+
+	second, _ := bus.NewSubscriber()
+	defer second.Close()
+	msgs, _, err := second.Consume()
+	s.Require().NoError(err)
+
+	received := []string{}
+	for i := 0; i < 3; i++ {
+		received = append(received, s.receive(msgs))
+	}
+
+	// the messages held by the first subscriber go back to the bus on close
+	first.Close()
+	for i := 0; i < 2; i++ {
+		received = append(received, s.receive(msgs))
+	}
+	assert.ElementsMatch([]string{"a", "b", "c", "d", "e"}, received)
+}
+
+func (s *SubscriberUnitSuite) TestPauseResume() {
+	assert := s.Assert()
+
+	broker := make(chan base.Message, 10)
+	bus, _ := NewBus(SetIn(broker), SetOut(broker))
+	defer bus.Close()
+
+	pub, _ := bus.NewPublisher()
+	sub, _ := bus.NewSubscriber()
+	msgs, _, _ := sub.Consume()
+
+	paused, ok := sub.(base.PausableSubscriber)
+	s.Require().True(ok)
+	assert.NoError(paused.Pause())
+
+	s.publish(pub, "a", 0, 0)
+	s.publish(pub, "b", 0, 0)
+	select {
+	case <-msgs:
+		s.Fail("message handed over while paused")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	assert.NoError(paused.Resume())
+	assert.Equal("a", s.receive(msgs))
+	assert.Equal("b", s.receive(msgs))
+}
+
+func (s *SubscriberUnitSuite) TestDeliveryInfo() {
+	assert := s.Assert()
+
+	broker := make(chan base.Message, 10)
+	bus, _ := NewBus(SetIn(broker), SetOut(broker))
+	defer bus.Close()
+
+	pub, _ := bus.NewPublisher()
+	sub, _ := bus.NewSubscriber()
+	msgs, _, _ := sub.Consume()
+
+	before := time.Now()
+	err, ok := pub.Publish(&identified{Message: bustest.NewMessage(nil, nil), id: "id"})
+	s.Require().NoError(err)
+	s.Require().True(ok)
+
+	msg := <-msgs
+	info := msg.DeliveryInfo()
+	assert.Equal("id", info.MessageId)
+	assert.False(info.Timestamp.Before(before))
+	assert.False(info.Redelivered)
+
+	assert.NoError(msg.Nack(false, true))
+	select {
+	case msg = <-msgs:
+	case <-time.After(time.Second):
+		s.FailNow("message not requeued")
+	}
+	assert.True(msg.DeliveryInfo().Redelivered)
+	assert.Equal(info.Timestamp, msg.DeliveryInfo().Timestamp)
+}
+
+func TestSubscriberUnitSuite(t *testing.T) {
+	suite.Run(t, new(SubscriberUnitSuite))
+}
@@ -0,0 +1,98 @@
+package proc
+
+import (
+	"sync"
+	"time"
+)
+
+// wheel is a hashed timing wheel holding back the delayed messages of a
+// publisher. A single goroutine advances it one slot per tick and runs the
+// timers of the slot whose rounds are over, so a timer fires up to a tick
+// after its delay, never before. The goroutine is tracked by the wait group
+// from the creation of the wheel, so the bus never waits before it is counted.
+type wheel struct {
+	tick  time.Duration
+	slots [][]*timer
+	pos   int
+
+	mu sync.Mutex
+
+	closer <-chan struct{}
+	wg     *sync.WaitGroup
+}
+
+type timer struct {
+	rounds int
+	fn     func()
+}
+
+func newWheel(tick time.Duration, size int, closer <-chan struct{}, wg *sync.WaitGroup) *wheel {
+	if wg == nil {
+		wg = &sync.WaitGroup{}
+	}
+	w := &wheel{
+		tick:   tick,
+		slots:  make([][]*timer, size),
+		closer: closer,
+		wg:     wg,
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// schedule runs fn once the delay elapsed.
+func (w *wheel) schedule(d time.Duration, fn func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// the next tick comes before a whole tick elapsed, it is not counted
+	ticks := int((d+w.tick-1)/w.tick) + 1
+	if ticks < 1 {
+		ticks = 1
+	}
+	slot := (w.pos + ticks) % len(w.slots)
+	w.slots[slot] = append(w.slots[slot], &timer{
+		rounds: (ticks - 1) / len(w.slots),
+		fn:     fn,
+	})
+}
+
+// run turns the wheel until the bus closes.
+func (w *wheel) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closer:
+			return
+		case <-ticker.C:
+			for _, fn := range w.advance() {
+				fn()
+			}
+		}
+	}
+}
+
+// advance moves to the next slot and returns the timers due.
+func (w *wheel) advance() []func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pos = (w.pos + 1) % len(w.slots)
+	due := make([]func(), 0)
+	pending := w.slots[w.pos][:0]
+	for _, t := range w.slots[w.pos] {
+		if t.rounds == 0 {
+			due = append(due, t.fn)
+			continue
+		}
+		t.rounds--
+		pending = append(pending, t)
+	}
+	w.slots[w.pos] = pending
+	return due
+}